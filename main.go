@@ -2,38 +2,46 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"os/signal"
+	"syscall"
 
+	"github.com/andrescris/alimedia/pkg/config"
 	"github.com/andrescris/alimedia/pkg/handlers"
+	"github.com/andrescris/alimedia/pkg/identity"
 	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/reconcile"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/trash"
+	"github.com/andrescris/alimedia/pkg/webhooks"
 	"github.com/andrescris/firestore/lib/firebase"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// Cargar y validar la configuración antes de tocar Firebase
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	gin.SetMode(cfg.Server.GinMode)
+	if len(cfg.Collections.Registry) == 0 {
+		log.Println("⚠️ collections.registry está vacío: se exponen todas las colecciones no internas")
+	}
+
 	// Inicializar Firebase
 	if err := firebase.InitFirebaseFromEnv(); err != nil {
 		log.Fatalf("Error initializing Firebase: %v", err)
 	}
-	defer firebase.Close()
 
 	// Configurar Gin
 	r := gin.Default()
 
-	// Middleware para CORS (permitir Postman)
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-KEY, X-Session-ID, X-Client-Subdomain")
-		
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		
-		c.Next()
-	})
+	// Middleware para CORS según los orígenes configurados
+	r.Use(middleware.CORSMiddleware(cfg.CORS))
 
 	// Health check
 	r.GET("/", func(c *gin.Context) {
@@ -45,67 +53,230 @@ func main() {
 	})
 
 	// Configurar rutas
-	setupRoutes(r)
+	idp := identity.NewFirebaseProvider()
+	idp.CredentialsCollection = cfg.Auth.CredentialsCollection
+	idp.SessionsCollection = cfg.Auth.SessionsCollection
+	idp.SessionUIDField = cfg.Auth.SessionUIDField
+	store, err := storage.NewFirestoreStore(context.Background(), firebase.GetProjectID())
+	if err != nil {
+		log.Fatalf("Error initializing Firestore: %v", err)
+	}
+	h := handlers.New(cfg, store, idp)
+	setupRoutes(r, cfg, h, idp)
+
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	// Los streams abiertos no terminan solos: se cierran al empezar el apagado
+	srv.RegisterOnShutdown(h.CloseStreams)
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", cfg.Server.Addr, err)
+	}
+
+	scheme := "http"
+	if cfg.Server.TLSEnabled() {
+		scheme = "https"
+	}
+	log.Printf("🚀 API Server iniciado en %s://%s", scheme, cfg.Server.Addr)
+	if cfg.Features.Docs {
+		log.Printf("📖 Documentación en %s://%s/api/v1/docs", scheme, cfg.Server.Addr)
+	}
+
+	// SIGINT/SIGTERM inician el apagado ordenado: dejamos de aceptar conexiones,
+	// esperamos a las peticiones en curso y solo después cerramos Firebase.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("🚀 API Server iniciado en http://localhost:8080")
-	log.Println("📖 Documentación en http://localhost:8080/api/v1/docs")
-	
-	r.Run(":8080")
+	// El purgador de la papelera se detiene con la misma señal, antes de cerrar Firebase
+	purgerDone := make(chan struct{})
+	go func() {
+		defer close(purgerDone)
+		trash.RunPurger(ctx, store, cfg.Trash.Retention, cfg.Trash.PurgeInterval)
+	}()
+	// Lo mismo para el repartidor de webhooks; una entrega a medias vuelve a la cola
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		webhooks.NewDispatcher(store, cfg.Webhooks).Run(ctx)
+	}()
+	// Y para la reconciliación periódica de usuarios (desactivada por defecto)
+	reconcilerDone := make(chan struct{})
+	go func() {
+		defer close(reconcilerDone)
+		reconcile.RunReconciler(ctx, idp, store, cfg.Reconcile.Interval, reconcile.Options{
+			Repair:      cfg.Reconcile.Repair,
+			GracePeriod: cfg.Reconcile.GracePeriod,
+		})
+	}()
+
+	if err := serve(ctx, srv, ln, cfg.Server); err != nil {
+		log.Printf("Error running server: %v", err)
+	}
+	// Si el servidor falla sin señal (puerto ocupado, certificado TLS inválido), los
+	// procesos de fondo también tienen que parar o la espera no termina nunca
+	stop()
+	<-purgerDone
+	<-dispatcherDone
+	<-reconcilerDone
+	h.FlushAudit()
+	if err := store.Close(); err != nil {
+		log.Printf("Error closing Firestore: %v", err)
+	}
+	if err := firebase.Close(); err != nil {
+		log.Printf("Error closing Firebase: %v", err)
+	}
+	log.Println("👋 API Server detenido")
 }
 
-func setupRoutes(r *gin.Engine) {
+func setupRoutes(r *gin.Engine, cfg *config.Config, h *handlers.Handler, idp identity.Provider) {
+	// Middleware de sesión compartido por las rutas protegidas
+	sessionAuth := middleware.SessionAuthMiddleware(idp)
+	// Registro de colecciones: bloquea las internas y las no expuestas
+	collectionAccess := middleware.CollectionAccessMiddleware(cfg.Collections)
+	collectionWrite := middleware.CollectionWriteMiddleware()
+
 	// Rutas de API
 	api := r.Group("/api/v1")
-	api.Use(middleware.APIKeyAuthMiddleware())
+	api.Use(middleware.RequestIDMiddleware())
+	api.Use(middleware.TimeoutMiddleware(cfg.Server.RequestTimeout, cfg.Server.RouteTimeouts))
+	api.Use(middleware.APIKeyAuthMiddleware(cfg.Auth.APIKeys))
 	{
 
-   authGroup := api.Group("/auth")
-        {
-            // El login solo necesita la API Key general
-            authGroup.POST("/login", handlers.Login)
-            // El logout necesita la API Key Y una sesión válida
-            authGroup.POST("/logout", middleware.SessionAuthMiddleware(), handlers.Logout)
-        }
+		authGroup := api.Group("/auth")
+		{
+			// El login solo necesita la API Key general
+			authGroup.POST("/login", h.Login)
+			// El logout necesita la API Key Y una sesión válida
+			authGroup.POST("/logout", sessionAuth, h.Logout)
+		}
 
 		// === USUARIOS ===
 		users := api.Group("/users")
 		{
-			users.POST("/", handlers.CreateUser)           // Crear usuario
-			users.GET("/", middleware.SessionAuthMiddleware(), middleware.AdminOnlyMiddleware(), handlers.ListUsers)
-			users.GET("/:uid", handlers.GetUser)          // Obtener usuario por UID
-			users.GET("/email/:email", handlers.GetUserByEmail) // Obtener usuario por email
-			users.PUT("/:uid", handlers.UpdateUser)        // Actualizar usuario
-			users.DELETE("/:uid", handlers.DeleteUser)     // Eliminar usuario
-			users.POST("/:uid/claims", middleware.SessionAuthMiddleware(), middleware.AdminOnlyMiddleware(), handlers.SetUserClaims)
-			//users.POST("/:uid/claims", middleware.SessionAuthMiddleware(), handlers.SetUserClaims)
-			users.PATCH("/:uid/claims", middleware.SessionAuthMiddleware(), middleware.AdminOnlyMiddleware(), handlers.UpdateUserClaims)
+			// Política en los handlers: admins gestionan a cualquiera, tenant admins a los
+			// usuarios de sus subdominios y cada usuario se lee y actualiza a sí mismo
+			users.POST("/", sessionAuth, h.CreateUser) // Crear usuario
+			users.GET("/", sessionAuth, middleware.AdminOnlyMiddleware(), h.ListUsers)
+			users.GET("/:uid", sessionAuth, h.GetUser)                // Obtener usuario por UID
+			users.GET("/email/:email", sessionAuth, h.GetUserByEmail) // Obtener usuario por email
+			users.PUT("/:uid", sessionAuth, h.UpdateUser)             // Actualizar usuario
+			users.DELETE("/:uid", sessionAuth, h.DeleteUser)          // Eliminar usuario
+			users.POST("/:uid/claims", sessionAuth, middleware.AdminOnlyMiddleware(), h.SetUserClaims)
+			//users.POST("/:uid/claims", sessionAuth, h.SetUserClaims)
+			users.PATCH("/:uid/claims", sessionAuth, middleware.AdminOnlyMiddleware(), h.UpdateUserClaims)
+			// Cuentas a medias: solo informa salvo con ?repair=true
+			users.POST("/reconcile", sessionAuth, middleware.AdminOnlyMiddleware(), h.ReconcileUsers)
+			// RGPD: exportación y borrado de todos los datos de un usuario
+			users.GET("/:uid/export", sessionAuth, middleware.AdminOnlyMiddleware(), h.ExportUser)
+			users.POST("/:uid/erase", sessionAuth, middleware.AdminOnlyMiddleware(), h.EraseUser)
+			users.POST("/erasure-receipts/verify", sessionAuth, middleware.AdminOnlyMiddleware(), h.VerifyErasureReceipt)
 		}
 
 		// === DOCUMENTOS ===
 		docs := api.Group("/collections/:collection/documents")
-		docs.Use(middleware.SessionAuthMiddleware())        // Validar sesión
-		docs.Use(middleware.SubdomainMatchMiddleware())     // Validar acceso al subdominio
+		docs.Use(sessionAuth)                           // Validar sesión
+		docs.Use(collectionAccess)                      // Validar que la colección esté expuesta
+		docs.Use(middleware.SubdomainMatchMiddleware()) // Validar acceso al subdominio
 		{
-			docs.POST("/", handlers.CreateDocument)        
-			docs.GET("/", handlers.ListDocuments)          
-			docs.GET("/:id", handlers.GetDocument)         
-			docs.PUT("/:id", handlers.UpdateDocument)      
-			docs.DELETE("/:id", handlers.DeleteDocument)   
+			docs.POST("/", collectionWrite, h.CreateDocument)
+			docs.GET("/", h.ListDocuments)
+			docs.GET("/:id", h.GetDocument)
+			docs.PUT("/:id", collectionWrite, h.UpdateDocument)
+			docs.PATCH("/:id", collectionWrite, h.PatchDocument)
+			docs.DELETE("/:id", collectionWrite, h.DeleteDocument)
+
+			// Historial de revisiones
+			docs.GET("/:id/revisions", h.ListRevisions)
+			docs.GET("/:id/revisions/:rev", h.GetRevision)
+			docs.POST("/:id/revisions/:rev/restore", collectionWrite, h.RestoreRevision)
+			docs.GET("/:id/diff", h.DiffRevisions)
+
+			// Subcolecciones anidadas: .../:id/collections/items/documents/...
+			docs.Any("/:id/collections/*path", h.Subcollection)
+		}
+
+		// === ESQUEMAS ===
+		schemas := api.Group("/collections/:collection/schema")
+		schemas.Use(sessionAuth)
+		schemas.Use(collectionAccess)
+		{
+			schemas.GET("", h.GetCollectionSchema)
+			schemas.PUT("", middleware.AdminOnlyMiddleware(), h.SetCollectionSchema)
+			schemas.DELETE("", middleware.AdminOnlyMiddleware(), h.DeleteCollectionSchema)
 		}
 
+		// === PAPELERA ===
+		trashGroup := api.Group("/collections/:collection/trash")
+		trashGroup.Use(sessionAuth)
+		trashGroup.Use(collectionAccess)
+		trashGroup.Use(middleware.SubdomainMatchMiddleware())
+		{
+			trashGroup.GET("/", h.ListTrash)
+			trashGroup.POST("/:id/restore", collectionWrite, h.UndeleteDocument)
+			trashGroup.DELETE("/:id", middleware.AdminOnlyMiddleware(), collectionWrite, h.PurgeTrashDocument)
+		}
+
+		// === LOTES ===
+		// Cada operación comprueba su colección en el handler: el lote puede abarcar varias
+		api.POST("/collections/batch",
+			sessionAuth,
+			middleware.SubdomainMatchMiddleware(),
+			h.BatchWrite,
+		)
+
+		// === STREAM DE CAMBIOS ===
+		// Sin deadline por defecto (config.StreamRoute en server.route_timeouts)
+		api.GET("/collections/:collection/stream",
+			sessionAuth,
+			collectionAccess,
+			middleware.SubdomainMatchMiddleware(),
+			h.StreamDocuments,
+		)
+
 		// === CONSULTAS ===
-		 api.POST("/collections/:collection/query", 
-            middleware.SessionAuthMiddleware(), 
-            middleware.SubdomainMatchMiddleware(), // <-- Añadimos el nuevo middleware
-            handlers.QueryDocuments,
-        )
-		
-		// === UTILIDADES ===
-		api.GET("/stats", handlers.GetStats) // Estadísticas generales
+		api.POST("/collections/:collection/query",
+			sessionAuth,
+			collectionAccess,
+			middleware.SubdomainMatchMiddleware(), // <-- Añadimos el nuevo middleware
+			h.QueryDocuments,
+		)
 
-       
+		// === AUDITORÍA ===
+		audit := api.Group("/audit")
+		audit.Use(sessionAuth)
+		audit.Use(middleware.AdminOnlyMiddleware())
+		{
+			audit.GET("/events", h.ListAuditEvents) // ?actor=&target=&action=&from=&to=
+			audit.GET("/verify", h.VerifyAuditLog)  // Comprobar la cadena de hashes
+		}
+
+		// === WEBHOOKS ===
+		hooks := api.Group("/webhooks")
+		hooks.Use(sessionAuth)
+		hooks.Use(middleware.SubdomainMatchMiddleware())
+		{
+			hooks.POST("/endpoints", h.CreateWebhookEndpoint)
+			hooks.GET("/endpoints", h.ListWebhookEndpoints)
+			hooks.PATCH("/endpoints/:id", h.UpdateWebhookEndpoint)
+			hooks.DELETE("/endpoints/:id", h.DeleteWebhookEndpoint)
+			hooks.GET("/deliveries", h.ListWebhookDeliveries) // ?status=dead&endpoint_id=
+			hooks.POST("/deliveries/:id/redeliver", h.RedeliverWebhook)
+		}
+
+		// === UTILIDADES ===
+		if cfg.Features.Stats {
+			api.GET("/stats", h.GetStats) // Estadísticas generales
+		}
 	}
-	
+
 	// Agregar ruta de documentación
-	r.GET("/api/v1/docs", handlers.ApiDocs)
-}
\ No newline at end of file
+	if cfg.Features.Docs {
+		r.GET("/api/v1/docs", handlers.ApiDocs)
+	}
+}
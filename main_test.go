@@ -0,0 +1,2430 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/auditlog"
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/andrescris/alimedia/pkg/handlers"
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/trash"
+	"github.com/andrescris/alimedia/pkg/webhooks"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+const testAPIKey = "test-api-key"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testServer es el router completo de /api/v1 sobre backends en memoria.
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	h      *handlers.Handler
+	docs   *storage.MemoryStore
+	idp    *identity.MemoryProvider
+	// faults hace fallar escrituras concretas de los handlers.
+	faults *faults
+
+	// Sesiones de los usuarios sembrados por newTestServer.
+	admin   testUser
+	alice   testUser // subdominio "acme"
+	bob     testUser // subdominio "globex"
+	nobody  testUser // sin subdominios asignados
+	project string
+}
+
+type testUser struct {
+	uid     string
+	session string
+}
+
+// newTestServer construye el router; opts permite ajustar la configuración por defecto.
+func newTestServer(t *testing.T, opts ...func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.APIKeys = []string{testAPIKey}
+	cfg.Audit.HMACSecret = "test-audit-secret"
+	cfg.Privacy.ReceiptSecret = "test-privacy-secret"
+	cfg.Pagination.PageTokenSecret = "test-page-token-secret"
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	docs := storage.NewMemoryStore()
+	idp := identity.NewMemoryProvider()
+	f := &faults{}
+	r := gin.New()
+	h := handlers.New(cfg, &faultyStore{docs, f}, &faultyProvider{idp, f})
+	setupRoutes(r, cfg, h, idp)
+
+	s := &testServer{t: t, router: r, h: h, docs: docs, idp: idp, faults: f, project: "p1"}
+	s.admin = s.createUser("admin@example.com", map[string]interface{}{"role": "admin"})
+	s.alice = s.createUser("alice@example.com", map[string]interface{}{"subdomain": []interface{}{"acme"}})
+	s.bob = s.createUser("bob@example.com", map[string]interface{}{"subdomain": []interface{}{"globex"}})
+	s.nobody = s.createUser("nobody@example.com", nil)
+	return s
+}
+
+// createUser registra un usuario con claims y abre una sesión para él.
+func (s *testServer) createUser(email string, claims map[string]interface{}) testUser {
+	s.t.Helper()
+	ctx := context.Background()
+	u, err := s.idp.CreateUser(ctx, identity.UserToCreate{Email: email, Password: "secret123"})
+	if err != nil {
+		s.t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	if err := s.idp.StoreCredentials(ctx, u.UID, "secret123"); err != nil {
+		s.t.Fatalf("StoreCredentials(%s): %v", email, err)
+	}
+	if claims != nil {
+		if err := s.idp.SetCustomClaims(ctx, u.UID, claims); err != nil {
+			s.t.Fatalf("SetCustomClaims(%s): %v", email, err)
+		}
+	}
+	res, err := s.idp.Login(ctx, identity.Credentials{Email: email, Password: "secret123"})
+	if err != nil || !res.Success {
+		s.t.Fatalf("Login(%s): %v %+v", email, err, res)
+	}
+	return testUser{uid: u.UID, session: res.SessionID}
+}
+
+// faults describe las escrituras que deben fallar.
+type faults struct {
+	collection  string // CreateDocument y CreateDocumentWithID en esta colección
+	credentials bool   // StoreCredentials
+	deleteID    string // DeleteDocument de este documento
+	getID       string // GetDocument de este documento
+}
+
+var errInjected = errors.New("injected failure")
+
+type faultyStore struct {
+	storage.DocumentStore
+	faults *faults
+}
+
+func (s *faultyStore) CreateDocument(ctx context.Context, collection string, data map[string]interface{}) (string, error) {
+	if collection == s.faults.collection {
+		return "", errInjected
+	}
+	return s.DocumentStore.CreateDocument(ctx, collection, data)
+}
+
+func (s *faultyStore) CreateDocumentWithID(ctx context.Context, collection, id string, data map[string]interface{}) error {
+	if collection == s.faults.collection {
+		return errInjected
+	}
+	return s.DocumentStore.CreateDocumentWithID(ctx, collection, id, data)
+}
+
+func (s *faultyStore) GetDocument(ctx context.Context, collection, id string) (*firebase.Document, error) {
+	if id == s.faults.getID {
+		return nil, errInjected
+	}
+	return s.DocumentStore.GetDocument(ctx, collection, id)
+}
+
+func (s *faultyStore) DeleteDocument(ctx context.Context, collection, id string) error {
+	if id == s.faults.deleteID {
+		return errInjected
+	}
+	return s.DocumentStore.DeleteDocument(ctx, collection, id)
+}
+
+type faultyProvider struct {
+	*identity.MemoryProvider
+	faults *faults
+}
+
+func (p *faultyProvider) StoreCredentials(ctx context.Context, uid, password string) error {
+	if p.faults.credentials {
+		return errInjected
+	}
+	return p.MemoryProvider.StoreCredentials(ctx, uid, password)
+}
+
+// seedDocument guarda un documento directamente en el store, saltándose la API.
+func (s *testServer) seedDocument(collection, subdomain string, data map[string]interface{}) string {
+	s.t.Helper()
+	doc := map[string]interface{}{"project_id": s.project, "subdomain": subdomain}
+	for k, v := range data {
+		doc[k] = v
+	}
+	id, err := s.docs.CreateDocument(context.Background(), collection, doc)
+	if err != nil {
+		s.t.Fatalf("seed document: %v", err)
+	}
+	return id
+}
+
+// request describe una petición HTTP de prueba. Los campos vacíos no envían la cabecera.
+type request struct {
+	method    string
+	path      string
+	body      interface{}
+	apiKey    string
+	session   string
+	subdomain string
+	headers   map[string]string
+}
+
+func (s *testServer) do(req request) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.doWithContext(context.Background(), req)
+}
+
+func (s *testServer) doWithContext(ctx context.Context, req request) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	if req.body != nil {
+		if err := json.NewEncoder(&body).Encode(req.body); err != nil {
+			s.t.Fatalf("encode body: %v", err)
+		}
+	}
+	httpReq := httptest.NewRequestWithContext(ctx, req.method, req.path, &body)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if req.apiKey != "" {
+		httpReq.Header.Set("X-API-KEY", req.apiKey)
+	}
+	if req.session != "" {
+		httpReq.Header.Set("X-Session-ID", req.session)
+	}
+	if req.subdomain != "" {
+		httpReq.Header.Set("X-Client-Subdomain", req.subdomain)
+	}
+	for name, value := range req.headers {
+		httpReq.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httpReq)
+	return w
+}
+
+// as devuelve una petición autenticada con la API Key y la sesión de u.
+func as(u testUser, subdomain, method, path string, body interface{}) request {
+	return request{method: method, path: path, body: body, apiKey: testAPIKey, session: u.session, subdomain: subdomain}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return out
+}
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		apiKey string
+		want   int
+	}{
+		{"missing key", "", http.StatusUnauthorized},
+		{"wrong key", "nope", http.StatusUnauthorized},
+		{"valid key reaches handler", testAPIKey, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(request{method: http.MethodPost, path: "/api/v1/auth/login", body: map[string]string{}, apiKey: tt.apiKey})
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(request{method: http.MethodPost, path: "/api/v1/auth/login", apiKey: testAPIKey,
+		body: map[string]string{"email": "alice@example.com", "password": "wrong-password"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("login with bad password: status = %d, want 401", w.Code)
+	}
+
+	w = s.do(request{method: http.MethodPost, path: "/api/v1/auth/login", apiKey: testAPIKey,
+		body: map[string]string{"email": "alice@example.com", "password": "secret123"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status = %d (%s)", w.Code, w.Body.String())
+	}
+	session := testUser{uid: s.alice.uid, session: decode(t, w)["session_id"].(string)}
+
+	path := "/api/v1/collections/notes/documents/"
+	if w := s.do(as(session, "acme", http.MethodGet, path, nil)); w.Code != http.StatusOK {
+		t.Fatalf("list with new session: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(session, "acme", http.MethodPost, "/api/v1/auth/logout", nil)); w.Code != http.StatusOK {
+		t.Fatalf("logout: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(session, "acme", http.MethodGet, path, nil)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("list after logout: status = %d, want 401", w.Code)
+	}
+}
+
+func TestSessionAuthMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	path := "/api/v1/collections/notes/documents/"
+
+	tests := []struct {
+		name string
+		req  request
+		want int
+	}{
+		{"missing session header", request{method: http.MethodGet, path: path, apiKey: testAPIKey, subdomain: "acme"}, http.StatusUnauthorized},
+		{"missing subdomain header", request{method: http.MethodGet, path: path, apiKey: testAPIKey, session: s.alice.session}, http.StatusUnauthorized},
+		{"unknown session", as(testUser{session: "does-not-exist"}, "acme", http.MethodGet, path, nil), http.StatusUnauthorized},
+		{"user without subdomains", as(s.nobody, "acme", http.MethodGet, path, nil), http.StatusForbidden},
+		{"subdomain not assigned to user", as(s.alice, "globex", http.MethodGet, path, nil), http.StatusForbidden},
+		{"assigned subdomain", as(s.alice, "acme", http.MethodGet, path, nil), http.StatusOK},
+		{"admin on any subdomain", as(s.admin, "anything", http.MethodGet, path, nil), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(tt.req); w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminOnlyMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	claimsPath := "/api/v1/users/" + s.bob.uid + "/claims"
+
+	tests := []struct {
+		name string
+		req  request
+		want int
+	}{
+		{"list users as user", as(s.alice, "acme", http.MethodGet, "/api/v1/users/", nil), http.StatusForbidden},
+		{"list users as admin", as(s.admin, "acme", http.MethodGet, "/api/v1/users/", nil), http.StatusOK},
+		{"set claims as user", as(s.alice, "acme", http.MethodPost, claimsPath, map[string]interface{}{"role": "admin"}), http.StatusForbidden},
+		{"patch claims as user", as(s.alice, "acme", http.MethodPatch, claimsPath, map[string]interface{}{"role": "admin"}), http.StatusForbidden},
+		{"patch claims as admin", as(s.admin, "acme", http.MethodPatch, claimsPath, map[string]interface{}{"plan": "pro"}), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(tt.req); w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	// Ningún intento de un usuario normal debe haber escalado privilegios.
+	u, err := s.idp.GetUser(context.Background(), s.bob.uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.CustomClaims["role"] != nil || u.CustomClaims["plan"] != "pro" {
+		t.Fatalf("claims = %v, want plan=pro and no role", u.CustomClaims)
+	}
+}
+
+func TestUserAccessPolicy(t *testing.T) {
+	s := newTestServer(t)
+	tenantAdmin := s.createUser("owner@example.com", map[string]interface{}{"role": "tenant_admin", "subdomain": []interface{}{"acme"}})
+	shared := s.createUser("shared@example.com", map[string]interface{}{"subdomain": []interface{}{"acme", "globex"}})
+	user := func(u testUser) string { return "/api/v1/users/" + u.uid }
+
+	tests := []struct {
+		name string
+		req  request
+		want int
+	}{
+		{"api key only", request{method: http.MethodGet, path: user(s.alice), apiKey: testAPIKey}, http.StatusUnauthorized},
+		{"read self", as(s.alice, "acme", http.MethodGet, user(s.alice), nil), http.StatusOK},
+		{"read self by email", as(s.alice, "acme", http.MethodGet, "/api/v1/users/email/alice@example.com", nil), http.StatusOK},
+		{"read other user", as(s.alice, "acme", http.MethodGet, user(s.bob), nil), http.StatusNotFound},
+		{"read other user by email", as(s.alice, "acme", http.MethodGet, "/api/v1/users/email/bob@example.com", nil), http.StatusNotFound},
+		{"update own name", as(s.alice, "acme", http.MethodPut, user(s.alice), map[string]interface{}{"display_name": "Alice"}), http.StatusOK},
+		{"enable own account", as(s.alice, "acme", http.MethodPut, user(s.alice), map[string]interface{}{"disabled": false}), http.StatusForbidden},
+		{"change own email", as(s.alice, "acme", http.MethodPut, user(s.alice), map[string]interface{}{"email": "a@evil.com"}), http.StatusForbidden},
+		{"update other user", as(s.alice, "acme", http.MethodPut, user(s.bob), map[string]interface{}{"display_name": "Bob"}), http.StatusNotFound},
+		{"delete self", as(s.alice, "acme", http.MethodDelete, user(s.alice), nil), http.StatusForbidden},
+		{"create as user", as(s.alice, "acme", http.MethodPost, "/api/v1/users/", map[string]interface{}{"project_id": s.project, "email": "x@example.com", "password": "secret123"}), http.StatusForbidden},
+		{"tenant admin reads own tenant", as(tenantAdmin, "acme", http.MethodGet, user(s.alice), nil), http.StatusOK},
+		{"tenant admin disables own tenant", as(tenantAdmin, "acme", http.MethodPut, user(s.alice), map[string]interface{}{"disabled": true}), http.StatusOK},
+		{"tenant admin reads other tenant", as(tenantAdmin, "acme", http.MethodGet, user(s.bob), nil), http.StatusNotFound},
+		{"tenant admin deletes shared user", as(tenantAdmin, "acme", http.MethodDelete, user(shared), nil), http.StatusNotFound},
+		{"tenant admin reads admin", as(tenantAdmin, "acme", http.MethodGet, user(s.admin), nil), http.StatusNotFound},
+		{"admin deletes anyone", as(s.admin, "acme", http.MethodDelete, user(shared), nil), http.StatusOK},
+		{"admin reads missing user", as(s.admin, "acme", http.MethodGet, "/api/v1/users/missing", nil), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(tt.req); w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	// Los usuarios que crea un tenant admin quedan en su subdominio y puede gestionarlos
+	w := s.do(as(tenantAdmin, "acme", http.MethodPost, "/api/v1/users/",
+		map[string]interface{}{"project_id": s.project, "email": "dave@example.com", "password": "secret123"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("tenant admin create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	uid := decode(t, w)["user"].(map[string]interface{})["uid"].(string)
+	created, err := s.idp.GetUser(context.Background(), uid)
+	if err != nil || len(created.CustomClaims["subdomain"].([]interface{})) != 1 || created.CustomClaims["subdomain"].([]interface{})[0] != "acme" {
+		t.Fatalf("created user = %+v, %v", created, err)
+	}
+	if w := s.do(as(tenantAdmin, "acme", http.MethodDelete, "/api/v1/users/"+uid, nil)); w.Code != http.StatusOK {
+		t.Fatalf("tenant admin delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+}
+
+func TestUserView(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/",
+		map[string]interface{}{"project_id": s.project, "email": "carol@example.com", "password": "secret123", "display_name": "Carol"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	created := decode(t, w)["user"].(map[string]interface{})
+	uid := created["uid"].(string)
+
+	keys := func(m map[string]interface{}) string {
+		var out []string
+		for k := range m {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+		return strings.Join(out, ",")
+	}
+	const full = "creation_timestamp,custom_claims,disabled,display_name,email,email_verified,last_signin_timestamp,photo_url,profile,uid"
+
+	// Todas las rutas devuelven la misma forma, con el perfil de "profiles"
+	views := map[string]map[string]interface{}{"create": created}
+	for name, req := range map[string]request{
+		"get":      as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+uid, nil),
+		"by email": as(s.admin, "acme", http.MethodGet, "/api/v1/users/email/carol@example.com", nil),
+		"update":   as(s.admin, "acme", http.MethodPut, "/api/v1/users/"+uid, map[string]interface{}{"display_name": "Carol B"}),
+	} {
+		w := s.do(req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d (%s)", name, w.Code, w.Body.String())
+		}
+		views[name] = decode(t, w)["user"].(map[string]interface{})
+	}
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/?limit=100", nil))
+	for _, u := range decode(t, w)["users"].([]interface{}) {
+		if u.(map[string]interface{})["uid"] == uid {
+			views["list"] = u.(map[string]interface{})
+		}
+	}
+	// El listado mantiene el nombre anterior del campo para los clientes existentes
+	if list := views["list"]; list == nil || list["last_log_in_timestamp"] != list["last_signin_timestamp"] {
+		t.Fatalf("list view = %v, want last_log_in_timestamp", list)
+	}
+	delete(views["list"], "last_log_in_timestamp")
+	for name, view := range views {
+		if keys(view) != full {
+			t.Fatalf("%s view keys = %s, want %s", name, keys(view), full)
+		}
+		profile, _ := view["profile"].(map[string]interface{})
+		if profile["project_id"] != s.project || profile["profile_id"] == nil || profile["user_id"] != nil {
+			t.Fatalf("%s profile = %v", name, profile)
+		}
+	}
+	if views["update"]["display_name"] != "Carol B" {
+		t.Fatalf("update view = %v", views["update"])
+	}
+
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+uid+"?fields=email,profile.role", nil))
+	selected := decode(t, w)["user"].(map[string]interface{})
+	if keys(selected) != "email,profile,uid" || keys(selected["profile"].(map[string]interface{})) != "role" {
+		t.Fatalf("selected view = %v", selected)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+uid+"?fields=password_hash", nil)); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown field: status = %d", w.Code)
+	}
+}
+
+func TestCrossSubdomainDocumentAccess(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      func(s *testServer) testUser
+		subdomain string
+		want      int
+	}{
+		{"other tenant is denied", func(s *testServer) testUser { return s.alice }, "acme", http.StatusForbidden},
+		{"owner tenant is allowed", func(s *testServer) testUser { return s.bob }, "globex", http.StatusOK},
+		{"admin is allowed", func(s *testServer) testUser { return s.admin }, "acme", http.StatusOK},
+	}
+	methods := []string{http.MethodGet, http.MethodPut, http.MethodDelete}
+
+	for _, tt := range tests {
+		for _, method := range methods {
+			t.Run(tt.name+"/"+method, func(t *testing.T) {
+				s := newTestServer(t)
+				id := s.seedDocument("orders", "globex", map[string]interface{}{"total": 10})
+				path := "/api/v1/collections/orders/documents/" + id
+
+				var body interface{}
+				if method == http.MethodPut {
+					body = map[string]interface{}{"total": 99}
+				}
+				w := s.do(as(tt.user(s), tt.subdomain, method, path, body))
+				if w.Code != tt.want {
+					t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+				}
+
+				doc, err := s.docs.GetDocument(context.Background(), "orders", id)
+				if tt.want == http.StatusForbidden {
+					if err != nil {
+						t.Fatalf("denied %s removed the document: %v", method, err)
+					}
+					if doc.Data["total"] != 10 {
+						t.Fatalf("denied %s modified the document: %v", method, doc.Data)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCreateDocumentForcesSessionSubdomain(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "subdomain": "globex"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+	}
+	id := decode(t, w)["document_id"].(string)
+	doc, err := s.docs.GetDocument(context.Background(), "orders", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Data["subdomain"] != "acme" {
+		t.Fatalf("subdomain = %v, want acme", doc.Data["subdomain"])
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/", map[string]interface{}{"total": 1}))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("create without project_id: status = %d, want 400", w.Code)
+	}
+}
+
+func TestUpdateDocumentKeepsSubdomain(t *testing.T) {
+	s := newTestServer(t)
+	id := s.seedDocument("orders", "acme", nil)
+
+	w := s.do(as(s.alice, "acme", http.MethodPut, "/api/v1/collections/orders/documents/"+id,
+		map[string]interface{}{"subdomain": "globex", "total": 5}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+	}
+	doc, _ := s.docs.GetDocument(context.Background(), "orders", id)
+	if doc.Data["subdomain"] != "acme" || doc.Data["total"] != float64(5) {
+		t.Fatalf("data = %v, want subdomain acme and total 5", doc.Data)
+	}
+}
+
+func TestListDocumentsIsolatesTenants(t *testing.T) {
+	s := newTestServer(t)
+	s.seedDocument("orders", "acme", nil)
+	s.seedDocument("orders", "acme", nil)
+	s.seedDocument("orders", "globex", nil)
+
+	tests := []struct {
+		name      string
+		user      testUser
+		subdomain string
+		want      int
+	}{
+		{"acme user", s.alice, "acme", 2},
+		{"globex user", s.bob, "globex", 1},
+		{"admin", s.admin, "acme", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(as(tt.user, tt.subdomain, http.MethodGet, "/api/v1/collections/orders/documents/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", w.Code, w.Body.String())
+			}
+			if got := decode(t, w)["count"]; got != float64(tt.want) {
+				t.Fatalf("count = %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDocuments(t *testing.T) {
+	s := newTestServer(t)
+	s.seedDocument("orders", "acme", map[string]interface{}{"total": 10})
+	s.seedDocument("orders", "globex", map[string]interface{}{"total": 20})
+	path := "/api/v1/collections/orders/query"
+
+	projectFilter := map[string]interface{}{"field": "project_id", "operator": "==", "value": s.project}
+	tests := []struct {
+		name      string
+		user      testUser
+		subdomain string
+		filters   []interface{}
+		want      int
+		wantCount int
+	}{
+		{"missing project_id filter", s.alice, "acme", nil, http.StatusBadRequest, 0},
+		{"project_id with other operator", s.alice, "acme",
+			[]interface{}{map[string]interface{}{"field": "project_id", "operator": "!=", "value": "x"}}, http.StatusBadRequest, 0},
+		{"user sees own tenant", s.alice, "acme", []interface{}{projectFilter}, http.StatusOK, 1},
+		{"user cannot widen to other tenant", s.alice, "acme",
+			[]interface{}{projectFilter, map[string]interface{}{"field": "subdomain", "operator": "==", "value": "globex"}}, http.StatusOK, 0},
+		{"admin sees all tenants", s.admin, "acme", []interface{}{projectFilter}, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(as(tt.user, tt.subdomain, http.MethodPost, path, map[string]interface{}{"filters": tt.filters}))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				if got := decode(t, w)["count"]; got != float64(tt.wantCount) {
+					t.Fatalf("count = %v, want %d", got, tt.wantCount)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestDeadlines(t *testing.T) {
+	listRoute := "GET /api/v1/collections/:collection/documents/"
+	path := "/api/v1/collections/orders/documents/"
+
+	t.Run("route timeout returns 504 envelope", func(t *testing.T) {
+		s := newTestServer(t, func(cfg *config.Config) {
+			cfg.Server.RouteTimeouts = map[string]time.Duration{listRoute: time.Nanosecond}
+		})
+		w := s.do(as(s.alice, "acme", http.MethodGet, path, nil))
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want 504 (%s)", w.Code, w.Body.String())
+		}
+		if body := decode(t, w); body["success"] != false || body["error"] == nil {
+			t.Fatalf("body = %v, want timeout envelope", body)
+		}
+	})
+
+	t.Run("other routes keep the default timeout", func(t *testing.T) {
+		s := newTestServer(t, func(cfg *config.Config) {
+			cfg.Server.RouteTimeouts = map[string]time.Duration{listRoute: time.Nanosecond}
+		})
+		w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/query",
+			map[string]interface{}{"filters": []interface{}{map[string]interface{}{"field": "project_id", "operator": "==", "value": s.project}}}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("client disconnect stops the request", func(t *testing.T) {
+		s := newTestServer(t)
+		id := s.seedDocument("orders", "acme", map[string]interface{}{"total": 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		w := s.doWithContext(ctx, as(s.alice, "acme", http.MethodDelete, path+id, nil))
+		if w.Code == http.StatusOK {
+			t.Fatalf("status = %d, canceled request must not succeed", w.Code)
+		}
+		if _, err := s.docs.GetDocument(context.Background(), "orders", id); err != nil {
+			t.Fatalf("canceled request still deleted the document: %v", err)
+		}
+	})
+}
+
+// collectPages recorre todas las páginas y devuelve los valores de field en orden.
+func collectPages(t *testing.T, s *testServer, page func(token string) *httptest.ResponseRecorder, field string) []interface{} {
+	t.Helper()
+	var values []interface{}
+	token := ""
+	for i := 0; ; i++ {
+		if i > 20 {
+			t.Fatal("pagination did not terminate")
+		}
+		w := page(token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("page %d: status = %d (%s)", i, w.Code, w.Body.String())
+		}
+		body := decode(t, w)
+		for _, doc := range body["documents"].([]interface{}) {
+			values = append(values, documentData(doc)[field])
+		}
+		token, _ = body["next_page_token"].(string)
+		if body["has_more"] != (token != "") {
+			t.Fatalf("has_more = %v with token %q", body["has_more"], token)
+		}
+		if token == "" {
+			return values
+		}
+	}
+}
+
+// documentData extrae los campos de un documento de la respuesta.
+func documentData(doc interface{}) map[string]interface{} {
+	m := doc.(map[string]interface{})
+	if data, ok := m["data"].(map[string]interface{}); ok {
+		return data
+	}
+	return m
+}
+
+func TestDocumentPagination(t *testing.T) {
+	s := newTestServer(t)
+	for i := 1; i <= 5; i++ {
+		s.seedDocument("orders", "acme", map[string]interface{}{"n": i, "group": i % 2})
+	}
+	s.seedDocument("orders", "globex", map[string]interface{}{"n": 100})
+
+	t.Run("list walks every tenant document once", func(t *testing.T) {
+		values := collectPages(t, s, func(token string) *httptest.ResponseRecorder {
+			return s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/?limit=2&page_token="+token, nil))
+		}, "n")
+		if len(values) != 5 {
+			t.Fatalf("values = %v, want the 5 acme documents", values)
+		}
+		seen := map[interface{}]bool{}
+		for _, v := range values {
+			if seen[v] {
+				t.Fatalf("document %v returned twice", v)
+			}
+			seen[v] = true
+		}
+	})
+
+	t.Run("query keeps order across pages", func(t *testing.T) {
+		values := collectPages(t, s, func(token string) *httptest.ResponseRecorder {
+			return s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/query", map[string]interface{}{
+				"filters":    []interface{}{map[string]interface{}{"field": "project_id", "operator": "==", "value": s.project}},
+				"order_by":   []interface{}{map[string]interface{}{"field": "group", "direction": "asc"}, map[string]interface{}{"field": "n", "direction": "desc"}},
+				"limit":      2,
+				"page_token": token,
+			}))
+		}, "n")
+		want := []interface{}{float64(4), float64(2), float64(5), float64(3), float64(1)}
+		if len(values) != len(want) {
+			t.Fatalf("values = %v, want %v", values, want)
+		}
+		for i := range want {
+			if values[i] != want[i] {
+				t.Fatalf("values = %v, want %v", values, want)
+			}
+		}
+	})
+
+	t.Run("tokens are bound to tenant and signature", func(t *testing.T) {
+		w := s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/?limit=2", nil))
+		token := decode(t, w)["next_page_token"].(string)
+
+		for name, req := range map[string]request{
+			"other tenant":   as(s.bob, "globex", http.MethodGet, "/api/v1/collections/orders/documents/?page_token="+token, nil),
+			"tampered token": as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/?page_token=x"+token, nil),
+		} {
+			if w := s.do(req); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status = %d, want 400", name, w.Code)
+			}
+		}
+	})
+}
+
+func TestDocumentEnvelope(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "total": 3}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	created := decode(t, w)["document"].(map[string]interface{})
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/", nil))
+	listed := decode(t, w)["documents"].([]interface{})
+	if len(listed) != 1 {
+		t.Fatalf("listed %d documents, want 1", len(listed))
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/query",
+		map[string]interface{}{"filters": []interface{}{map[string]interface{}{"field": "project_id", "operator": "==", "value": s.project}}}))
+	queried := decode(t, w)["documents"].([]interface{})
+
+	for name, doc := range map[string]interface{}{"create": created, "list": listed[0], "query": queried[0]} {
+		env := doc.(map[string]interface{})
+		for _, key := range []string{"id", "collection", "data", "create_time", "update_time"} {
+			if env[key] == nil {
+				t.Errorf("%s: envelope is missing %q: %v", name, key, env)
+			}
+		}
+		if env["id"] != created["id"] || env["collection"] != "orders" {
+			t.Errorf("%s: envelope = %v", name, env)
+		}
+	}
+
+	// El ID del listado sirve para leer el documento directamente.
+	id := listed[0].(map[string]interface{})["id"].(string)
+	w = s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/"+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if got := decode(t, w)["document"].(map[string]interface{})["data"].(map[string]interface{})["total"]; got != float64(3) {
+		t.Fatalf("get: total = %v", got)
+	}
+}
+
+func TestDocumentETags(t *testing.T) {
+	s := newTestServer(t)
+	id := s.seedDocument("orders", "acme", map[string]interface{}{"status": "new"})
+	path := "/api/v1/collections/orders/documents/" + id
+	withHeader := func(req request, name, value string) request {
+		req.headers = map[string]string{name: value}
+		return req
+	}
+
+	w := s.do(as(s.alice, "acme", http.MethodGet, path, nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("get: status = %d, ETag = %q", w.Code, etag)
+	}
+
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodGet, path, nil), "If-None-Match", etag))
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("If-None-Match: status = %d, body = %q", w.Code, w.Body.String())
+	}
+
+	// Dos editores parten de la misma versión: solo el primero puede escribir.
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodPut, path, map[string]interface{}{"status": "paid"}), "If-Match", etag))
+	if w.Code != http.StatusOK {
+		t.Fatalf("first update: status = %d (%s)", w.Code, w.Body.String())
+	}
+	newETag := w.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("update ETag = %q, previous %q", newETag, etag)
+	}
+
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodPut, path, map[string]interface{}{"status": "cancelled"}), "If-Match", etag))
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != newETag {
+		t.Fatalf("stale update: status = %d, ETag = %q (%s)", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodDelete, path, nil), "If-Match", etag))
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale delete: status = %d", w.Code)
+	}
+
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodGet, path, nil), "If-None-Match", etag))
+	if w.Code != http.StatusOK || documentData(decode(t, w)["document"])["status"] != "paid" {
+		t.Fatalf("get after stale writes: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// Otro subdominio no obtiene la versión del documento.
+	w = s.do(withHeader(as(s.bob, "globex", http.MethodGet, path, nil), "If-None-Match", newETag))
+	if w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" {
+		t.Fatalf("cross-tenant: status = %d, ETag = %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = s.do(withHeader(as(s.alice, "acme", http.MethodDelete, path, nil), "If-Match", newETag))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+}
+
+func TestDocumentRevisions(t *testing.T) {
+	s := newTestServer(t)
+	base := "/api/v1/collections/orders/documents/"
+
+	w := s.do(as(s.alice, "acme", http.MethodPost, base, map[string]interface{}{"project_id": s.project, "status": "new", "total": 3}))
+	id := decode(t, w)["document_id"].(string)
+	s.do(as(s.alice, "acme", http.MethodPut, base+id, map[string]interface{}{"status": "paid"}))
+	s.do(as(s.alice, "acme", http.MethodPut, base+id, map[string]interface{}{"status": "shipped", "carrier": "dhl"}))
+	if w := s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil)); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, base+id+"/revisions", nil))
+	revisions := decode(t, w)["revisions"].([]interface{})
+	var ops []string
+	for _, r := range revisions {
+		rev := r.(map[string]interface{})
+		ops = append(ops, rev["operation"].(string))
+		if rev["actor"] != s.alice.uid || rev["subdomain"] != "acme" {
+			t.Errorf("revision = %v", rev)
+		}
+	}
+	if strings.Join(ops, ",") != "delete,update,update,create" {
+		t.Fatalf("operations = %v", ops)
+	}
+	paid := revisions[1].(map[string]interface{})
+	newRev := revisions[2].(map[string]interface{})
+	if paid["data"].(map[string]interface{})["status"] != "paid" {
+		t.Fatalf("revision before the second update = %v", paid)
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, base+id+"/diff?from="+newRev["id"].(string)+"&to="+paid["id"].(string), nil))
+	var paths []string
+	for _, change := range decode(t, w)["changes"].([]interface{}) {
+		paths = append(paths, change.(map[string]interface{})["path"].(string))
+	}
+	// Además del estado cambian los campos de auditoría de la modificación
+	if strings.Join(paths, ",") != "status,updated_at" {
+		t.Fatalf("diff paths = %v", paths)
+	}
+
+	// Otro tenant no ve el historial
+	w = s.do(as(s.bob, "globex", http.MethodGet, base+id+"/revisions", nil))
+	if got := decode(t, w)["count"]; got != float64(0) {
+		t.Fatalf("bob sees %v revisions", got)
+	}
+	w = s.do(as(s.bob, "globex", http.MethodPost, base+id+"/revisions/"+paid["id"].(string)+"/restore", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("bob restore: status = %d", w.Code)
+	}
+
+	// Restaurar recrea el documento eliminado con el estado de la revisión
+	w = s.do(as(s.alice, "acme", http.MethodPost, base+id+"/revisions/"+paid["id"].(string)+"/restore", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d (%s)", w.Code, w.Body.String())
+	}
+	w = s.do(as(s.alice, "acme", http.MethodGet, base+id, nil))
+	data := documentData(decode(t, w)["document"])
+	if data["status"] != "paid" || data["carrier"] != nil {
+		t.Fatalf("restored data = %v", data)
+	}
+	etag := w.Header().Get("ETag")
+
+	// Con If-Match no se restaura sobre una versión que ha cambiado desde que se leyó
+	s.do(as(s.alice, "acme", http.MethodPut, base+id, map[string]interface{}{"status": "shipped"}))
+	restore := as(s.alice, "acme", http.MethodPost, base+id+"/revisions/"+newRev["id"].(string)+"/restore", nil)
+	restore.headers = map[string]string{"If-Match": etag}
+	if w := s.do(restore); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("restore with a stale If-Match: status = %d (%s)", w.Code, w.Body.String())
+	}
+	restore.headers["If-Match"] = s.do(as(s.alice, "acme", http.MethodGet, base+id, nil)).Header().Get("ETag")
+	if w := s.do(restore); w.Code != http.StatusOK {
+		t.Fatalf("restore with the current If-Match: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// Un error al leer el documento no se confunde con que no exista: no se pisa
+	s.faults.getID = id
+	w = s.do(as(s.alice, "acme", http.MethodPost, base+id+"/revisions/"+paid["id"].(string)+"/restore", nil))
+	s.faults.getID = ""
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("restore after a read error: status = %d (%s)", w.Code, w.Body.String())
+	}
+	w = s.do(as(s.alice, "acme", http.MethodGet, base+id, nil))
+	if data := documentData(decode(t, w)["document"]); data["status"] != "new" {
+		t.Fatalf("data after a failed restore = %v", data)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	s := newTestServer(t)
+	base := "/api/v1/collections/orders/documents/"
+	id := s.seedDocument("orders", "acme", map[string]interface{}{"status": "new"})
+
+	if w := s.do(as(s.alice, "acme", http.MethodDelete, base+id+"?hard=true", nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("hard delete as alice: status = %d", w.Code)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil)); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// El documento eliminado desaparece de la lectura y del listado
+	if w := s.do(as(s.alice, "acme", http.MethodGet, base+id, nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: status = %d", w.Code)
+	}
+	if got := decode(t, s.do(as(s.alice, "acme", http.MethodGet, base, nil)))["count"]; got != float64(0) {
+		t.Fatalf("list after delete: count = %v", got)
+	}
+
+	// ...y aparece solo en la papelera de su tenant
+	w := s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/trash/", nil))
+	entries := decode(t, w)["documents"].([]interface{})
+	if len(entries) != 1 {
+		t.Fatalf("alice trash = %v", entries)
+	}
+	entry := entries[0].(map[string]interface{})
+	if entry["document_id"] != id || entry["deleted_by"] != s.alice.uid || entry["purge_at"] == nil {
+		t.Fatalf("trash entry = %v", entry)
+	}
+	if got := decode(t, s.do(as(s.bob, "globex", http.MethodGet, "/api/v1/collections/orders/trash/", nil)))["count"]; got != float64(0) {
+		t.Fatalf("bob trash: count = %v", got)
+	}
+	if w := s.do(as(s.bob, "globex", http.MethodPost, "/api/v1/collections/orders/trash/"+id+"/restore", nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("bob undelete: status = %d", w.Code)
+	}
+
+	if w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/trash/"+id+"/restore", nil)); w.Code != http.StatusOK {
+		t.Fatalf("undelete: status = %d (%s)", w.Code, w.Body.String())
+	}
+	w = s.do(as(s.alice, "acme", http.MethodGet, base+id, nil))
+	if w.Code != http.StatusOK || documentData(decode(t, w)["document"])["status"] != "new" {
+		t.Fatalf("get after undelete: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// El purgador borra lo que supera la retención
+	s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil))
+	if n, err := trash.Purge(context.Background(), s.docs, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("purge before retention: n = %d, err = %v", n, err)
+	}
+	if n, err := trash.Purge(context.Background(), s.docs, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("purge after retention: n = %d, err = %v", n, err)
+	}
+	// ...junto con el historial del documento
+	revisionCount := func(docID string) int {
+		docs, err := s.docs.QueryDocuments(context.Background(), revisions.Collection, firebase.QueryOptions{
+			Filters: []firebase.QueryFilter{{Field: "document_id", Operator: "==", Value: docID}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(docs)
+	}
+	if n := revisionCount(id); n != 0 {
+		t.Fatalf("revisions after purge = %d", n)
+	}
+
+	// Cada borrado tiene su propia entrada: recrear y volver a borrar no pisa la anterior
+	id = s.seedDocument("orders", "acme", map[string]interface{}{"status": "first"})
+	s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil))
+	if err := s.docs.CreateDocumentWithID(context.Background(), "orders", id, map[string]interface{}{"status": "second", "subdomain": "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil))
+	if got := decode(t, s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/trash/", nil)))["count"]; got != float64(2) {
+		t.Fatalf("trash after deleting twice: count = %v", got)
+	}
+	// La restauración y la purga actúan sobre el borrado más reciente
+	if w := s.do(as(s.admin, "acme", http.MethodDelete, "/api/v1/collections/orders/trash/"+id, nil)); w.Code != http.StatusOK {
+		t.Fatalf("purge latest: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if entry, err := trash.Get(context.Background(), s.docs, "orders", id); err != nil || entry.Data["status"] != "first" {
+		t.Fatalf("remaining entry = %v, err = %v", entry.Data, err)
+	}
+
+	// El borrado definitivo de un admin no pasa por la papelera ni deja historial
+	id = s.seedDocument("orders", "acme", map[string]interface{}{"status": "new"})
+	s.do(as(s.alice, "acme", http.MethodPatch, base+id, map[string]interface{}{"status": "paid"}))
+	if n := revisionCount(id); n == 0 {
+		t.Fatalf("update left no revision")
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodDelete, base+id+"?hard=true", nil)); w.Code != http.StatusOK {
+		t.Fatalf("hard delete as admin: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if got := decode(t, s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/collections/orders/trash/", nil)))["count"]; got != float64(1) {
+		t.Fatalf("trash after hard delete: count = %v", got)
+	}
+	if n := revisionCount(id); n != 0 {
+		t.Fatalf("revisions after hard delete = %d", n)
+	}
+}
+
+func TestTrashPurgeSkipsFailures(t *testing.T) {
+	ctx := context.Background()
+	docs := storage.NewMemoryStore()
+	deletedAt := time.Now().Add(-48 * time.Hour)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		// La primera entrada es la más antigua: sin saltarla, cada purgado empezaría por ella
+		id, err := trash.Put(ctx, docs, "orders", fmt.Sprintf("o%d", i), map[string]interface{}{}, "u1", deletedAt.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	store := &faultyStore{docs, &faults{deleteID: ids[0]}}
+	n, err := trash.Purge(ctx, store, time.Now())
+	if n != 2 || !errors.Is(err, errInjected) {
+		t.Fatalf("purge with a failing entry: n = %d, err = %v", n, err)
+	}
+	left, _ := docs.GetAllDocuments(ctx, trash.Collection)
+	if len(left) != 1 || left[0].ID != ids[0] {
+		t.Fatalf("entries left = %v", left)
+	}
+}
+
+func TestPatchDocument(t *testing.T) {
+	s := newTestServer(t)
+	id := s.seedDocument("orders", "acme", map[string]interface{}{
+		"status":  "new",
+		"address": map[string]interface{}{"city": "Lima", "zip": "15001"},
+		"items":   []interface{}{"a", "b"},
+	})
+	path := "/api/v1/collections/orders/documents/" + id
+	patchAs := func(u testUser, subdomain, contentType string, body interface{}) *httptest.ResponseRecorder {
+		req := as(u, subdomain, http.MethodPatch, path, body)
+		req.headers = map[string]string{"Content-Type": contentType}
+		return s.do(req)
+	}
+
+	w := patchAs(s.alice, "acme", "application/merge-patch+json",
+		map[string]interface{}{"address": map[string]interface{}{"zip": nil, "street": "Av. Sol"}, "subdomain": "globex"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge patch: status = %d (%s)", w.Code, w.Body.String())
+	}
+	data := documentData(decode(t, w)["document"])
+	address := data["address"].(map[string]interface{})
+	if address["city"] != "Lima" || address["street"] != "Av. Sol" || address["zip"] != nil {
+		t.Fatalf("address after merge patch = %v", address)
+	}
+	if data["subdomain"] != "acme" {
+		t.Fatalf("alice changed the subdomain to %v", data["subdomain"])
+	}
+
+	w = patchAs(s.alice, "acme", "application/json-patch+json", []map[string]interface{}{
+		{"op": "test", "path": "/status", "value": "new"},
+		{"op": "replace", "path": "/status", "value": "paid"},
+		{"op": "add", "path": "/items/-", "value": "c"},
+		{"op": "remove", "path": "/subdomain"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("json patch: status = %d (%s)", w.Code, w.Body.String())
+	}
+	data = documentData(decode(t, w)["document"])
+	if data["status"] != "paid" || len(data["items"].([]interface{})) != 3 || data["subdomain"] != "acme" {
+		t.Fatalf("data after json patch = %v", data)
+	}
+
+	// Un test que falla no aplica ninguna operación
+	w = patchAs(s.alice, "acme", "application/json-patch+json", []map[string]interface{}{
+		{"op": "replace", "path": "/status", "value": "cancelled"},
+		{"op": "test", "path": "/status", "value": "new"},
+	})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("failed test op: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	if w := patchAs(s.alice, "acme", "text/plain", "status=paid"); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain: status = %d", w.Code)
+	}
+	if w := patchAs(s.bob, "globex", "application/merge-patch+json", map[string]interface{}{"status": "x"}); w.Code != http.StatusForbidden {
+		t.Fatalf("bob patch: status = %d", w.Code)
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, path, nil))
+	if got := documentData(decode(t, w)["document"])["status"]; got != "paid" {
+		t.Fatalf("status = %v after rejected patches", got)
+	}
+}
+
+func TestCollectionSchemaValidation(t *testing.T) {
+	s := newTestServer(t)
+	schemaPath := "/api/v1/collections/orders/schema"
+	base := "/api/v1/collections/orders/documents/"
+	orderSchema := map[string]interface{}{
+		"type":                 "object",
+		"required":             []string{"project_id", "total"},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"project_id": map[string]interface{}{"type": "string"},
+			"total":      map[string]interface{}{"type": "number", "minimum": 0},
+			"status":     map[string]interface{}{"enum": []string{"new", "paid"}},
+			"address": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"zip": map[string]interface{}{"type": "string", "pattern": "^[0-9]{5}$"}},
+			},
+		},
+	}
+
+	if w := s.do(as(s.alice, "acme", http.MethodPut, schemaPath, orderSchema)); w.Code != http.StatusForbidden {
+		t.Fatalf("schema as alice: status = %d", w.Code)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPut, schemaPath, map[string]interface{}{"oneOf": []interface{}{}})); w.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported schema: status = %d", w.Code)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPut, schemaPath, orderSchema)); w.Code != http.StatusOK {
+		t.Fatalf("set schema: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	fieldsOf := func(w *httptest.ResponseRecorder) string {
+		t.Helper()
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d, want 422 (%s)", w.Code, w.Body.String())
+		}
+		var fields []string
+		for _, f := range decode(t, w)["fields"].([]interface{}) {
+			fields = append(fields, f.(map[string]interface{})["field"].(string))
+		}
+		return strings.Join(fields, ",")
+	}
+
+	w := s.do(as(s.alice, "acme", http.MethodPost, base, map[string]interface{}{"project_id": s.project, "total": -1, "color": "red"}))
+	if got := fieldsOf(w); got != "color,total" {
+		t.Fatalf("create errors = %s", got)
+	}
+
+	// subdomain lo gestiona el servidor y no necesita figurar en el esquema
+	w = s.do(as(s.alice, "acme", http.MethodPost, base, map[string]interface{}{"project_id": s.project, "total": 5}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("valid create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	id := decode(t, w)["document_id"].(string)
+
+	w = s.do(as(s.alice, "acme", http.MethodPut, base+id, map[string]interface{}{"status": "lost"}))
+	if got := fieldsOf(w); got != "status" {
+		t.Fatalf("update errors = %s", got)
+	}
+
+	req := as(s.alice, "acme", http.MethodPatch, base+id, []map[string]interface{}{{"op": "add", "path": "/address", "value": map[string]interface{}{"zip": "abc"}}})
+	req.headers = map[string]string{"Content-Type": "application/json-patch+json"}
+	if got := fieldsOf(s.do(req)); got != "address.zip" {
+		t.Fatalf("patch errors = %s", got)
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, schemaPath, nil))
+	if w.Code != http.StatusOK || decode(t, w)["schema"] == nil {
+		t.Fatalf("get schema: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodDelete, schemaPath, nil)); w.Code != http.StatusOK {
+		t.Fatalf("delete schema: status = %d", w.Code)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPut, base+id, map[string]interface{}{"status": "lost"})); w.Code != http.StatusOK {
+		t.Fatalf("update without schema: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// Recuperar de la papelera o restaurar una revisión también valida contra el esquema actual
+	if w := s.do(as(s.alice, "acme", http.MethodDelete, base+id, nil)); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPut, schemaPath, orderSchema)); w.Code != http.StatusOK {
+		t.Fatalf("set schema again: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if got := fieldsOf(s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/trash/"+id+"/restore", nil))); got != "status" {
+		t.Fatalf("undelete errors = %s", got)
+	}
+	revisions := decode(t, s.do(as(s.alice, "acme", http.MethodGet, base+id+"/revisions", nil)))["revisions"].([]interface{})
+	deleted := revisions[0].(map[string]interface{})
+	if got := fieldsOf(s.do(as(s.alice, "acme", http.MethodPost, base+id+"/revisions/"+deleted["id"].(string)+"/restore", nil))); got != "status" {
+		t.Fatalf("restore errors = %s", got)
+	}
+
+	// Las subcolecciones tienen un esquema común a todos los documentos padre
+	itemSchema := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"sku": map[string]interface{}{"type": "string"}},
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPut, schemaPath+"?subcollection=items//notes", itemSchema)); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid subcollection: status = %d", w.Code)
+	}
+	w = s.do(as(s.admin, "acme", http.MethodPut, schemaPath+"?subcollection=items", itemSchema))
+	if w.Code != http.StatusOK || decode(t, w)["collection"] != "orders/*/items" {
+		t.Fatalf("set item schema: status = %d (%s)", w.Code, w.Body.String())
+	}
+	for _, order := range []string{
+		s.seedDocument("orders", "acme", map[string]interface{}{"status": "new"}),
+		s.seedDocument("orders", "acme", map[string]interface{}{"status": "paid"}),
+	} {
+		items := base + order + "/collections/items/documents/"
+		if got := fieldsOf(s.do(as(s.alice, "acme", http.MethodPost, items, map[string]interface{}{"project_id": s.project, "sku": 1}))); got != "sku" {
+			t.Fatalf("item errors = %s", got)
+		}
+		if w := s.do(as(s.alice, "acme", http.MethodPost, items, map[string]interface{}{"project_id": s.project, "sku": "A1"})); w.Code != http.StatusCreated {
+			t.Fatalf("valid item: status = %d (%s)", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCollectionRegistry(t *testing.T) {
+	// Sin registro solo se bloquean las colecciones internas
+	s := newTestServer(t)
+	for _, name := range []string{"profiles", "user_claims", "credentials", "document_revisions"} {
+		w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/collections/"+name+"/documents/", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", name, w.Code)
+		}
+	}
+
+	s = newTestServer(t, func(cfg *config.Config) {
+		cfg.Collections.Registry = map[string]config.CollectionSettings{
+			"orders":  {Enabled: true, Scope: config.ScopeTenant},
+			"catalog": {Enabled: true, Scope: config.ScopeGlobal},
+			"archive": {Enabled: true, Scope: config.ScopeTenant, ReadOnly: true},
+			"legacy":  {Enabled: false, Scope: config.ScopeTenant},
+		}
+	})
+	docs := func(name string) string { return "/api/v1/collections/" + name + "/documents/" }
+
+	for _, name := range []string{"invoices", "legacy"} {
+		if w := s.do(as(s.alice, "acme", http.MethodGet, docs(name), nil)); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", name, w.Code)
+		}
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPost, docs("orders"), map[string]interface{}{"project_id": s.project})); w.Code != http.StatusCreated {
+		t.Fatalf("orders create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPost, docs("archive"), map[string]interface{}{"project_id": s.project})); w.Code != http.StatusForbidden {
+		t.Fatalf("read-only create: status = %d", w.Code)
+	}
+
+	// Colección global: solo los admins escriben y todos los tenants leen lo mismo
+	if w := s.do(as(s.alice, "acme", http.MethodPost, docs("catalog"), map[string]interface{}{"project_id": s.project})); w.Code != http.StatusForbidden {
+		t.Fatalf("global create as alice: status = %d", w.Code)
+	}
+	w := s.do(as(s.admin, "acme", http.MethodPost, docs("catalog"), map[string]interface{}{"project_id": s.project, "sku": "A1"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("global create as admin: status = %d (%s)", w.Code, w.Body.String())
+	}
+	id := decode(t, w)["document_id"].(string)
+	w = s.do(as(s.bob, "globex", http.MethodGet, docs("catalog"), nil))
+	if got := decode(t, w)["count"]; got != float64(1) {
+		t.Fatalf("bob lists %v catalog documents", got)
+	}
+	if w := s.do(as(s.bob, "globex", http.MethodGet, docs("catalog")+id, nil)); w.Code != http.StatusOK {
+		t.Fatalf("bob reads catalog document: status = %d", w.Code)
+	}
+}
+
+func TestAuditFields(t *testing.T) {
+	s := newTestServer(t)
+	base := "/api/v1/collections/orders/documents"
+
+	forged := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	w := s.do(as(s.alice, "acme", http.MethodPost, base+"/", map[string]interface{}{
+		"project_id": s.project, "status": "new", "created_by": "mallory", "created_at": forged,
+	}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	id := decode(t, w)["document_id"].(string)
+	path := base + "/" + id
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, path, nil))
+	created := documentData(decode(t, w)["document"])
+	if created["created_by"] != s.alice.uid || created["updated_by"] != s.alice.uid {
+		t.Fatalf("audit users after create = %v", created)
+	}
+	if created["created_at"] == forged || created["created_at"] != created["updated_at"] {
+		t.Fatalf("audit times after create = %v / %v", created["created_at"], created["updated_at"])
+	}
+
+	w = s.do(as(s.admin, "acme", http.MethodPut, path, map[string]interface{}{"status": "paid", "updated_by": "mallory"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d (%s)", w.Code, w.Body.String())
+	}
+	req := as(s.alice, "acme", http.MethodPatch, path, map[string]interface{}{"note": "x", "created_by": nil})
+	req.headers = map[string]string{"Content-Type": "application/merge-patch+json"}
+	if w := s.do(req); w.Code != http.StatusOK {
+		t.Fatalf("patch: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, path, nil))
+	data := documentData(decode(t, w)["document"])
+	if data["created_by"] != s.alice.uid || data["created_at"] != created["created_at"] {
+		t.Fatalf("created fields changed: %v", data)
+	}
+	if data["updated_by"] != s.alice.uid || data["updated_at"] == created["updated_at"] {
+		t.Fatalf("updated fields after patch = %v / %v", data["updated_by"], data["updated_at"])
+	}
+
+	// Los timestamps de auditoría se pueden filtrar como texto RFC 3339 y ordenar
+	s.seedDocument("orders", "acme", map[string]interface{}{"status": "legacy"})
+	query := func(value interface{}) *httptest.ResponseRecorder {
+		return s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/query", map[string]interface{}{
+			"filters": []interface{}{
+				map[string]interface{}{"field": "project_id", "operator": "==", "value": s.project},
+				map[string]interface{}{"field": "updated_at", "operator": ">=", "value": value},
+			},
+			"order_by": []interface{}{map[string]interface{}{"field": "created_at", "direction": "desc"}},
+		}))
+	}
+	w = query(created["created_at"])
+	if w.Code != http.StatusOK {
+		t.Fatalf("query: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if got := decode(t, w)["count"]; got != float64(1) {
+		t.Fatalf("query count = %v, want 1", got)
+	}
+	if w := query("yesterday"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid timestamp: status = %d", w.Code)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newTestServer(t)
+	otherID := s.seedDocument("orders", "globex", map[string]interface{}{"total": 20})
+
+	login := request{method: http.MethodPost, path: "/api/v1/auth/login", apiKey: testAPIKey,
+		body: map[string]string{"email": "alice@example.com", "password": "wrong-password"}}
+	login.headers = map[string]string{"X-Request-ID": "req-123"}
+	if w := s.do(login); w.Code != http.StatusUnauthorized {
+		t.Fatalf("failed login: status = %d", w.Code)
+	}
+	w := s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/"+s.bob.uid+"/claims",
+		map[string]interface{}{"subdomain": []interface{}{"globex", "acme"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("set claims: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/collections/orders/documents/"+otherID, nil)); w.Code != http.StatusOK {
+		t.Fatalf("admin read: status = %d", w.Code)
+	}
+	if w := s.do(as(s.admin, "globex", http.MethodGet, "/api/v1/collections/orders/documents/"+otherID, nil)); w.Code != http.StatusOK {
+		t.Fatalf("admin read in own tenant: status = %d", w.Code)
+	}
+
+	events := func(query string) []interface{} {
+		t.Helper()
+		w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/events"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("audit events%s: status = %d (%s)", query, w.Code, w.Body.String())
+		}
+		return decode(t, w)["events"].([]interface{})
+	}
+
+	// Los logins fallidos se escriben en segundo plano
+	s.h.FlushAudit()
+	failed := events("?action=auth.login_failed")
+	if len(failed) != 1 {
+		t.Fatalf("login_failed events = %v", failed)
+	}
+	if event := failed[0].(map[string]interface{}); event["target"] != s.alice.uid || event["request_id"] != "req-123" {
+		t.Fatalf("login_failed event = %v", event)
+	}
+
+	claims := events("?action=user.claims.set&target=" + s.bob.uid)
+	if len(claims) != 1 {
+		t.Fatalf("claims events = %v", claims)
+	}
+	event := claims[0].(map[string]interface{})
+	if event["actor"] != s.admin.uid || event["before"] == nil || len(event["after"].(map[string]interface{})["subdomain"].([]interface{})) != 2 {
+		t.Fatalf("claims event = %v", event)
+	}
+
+	// Solo la lectura desde otro subdominio cuenta como acceso entre tenants
+	if reads := events("?action=document.cross_tenant_read"); len(reads) != 1 {
+		t.Fatalf("cross tenant reads = %v", reads)
+	}
+	if all := events("?from=2000-01-01T00:00:00Z&actor=" + s.admin.uid); len(all) != 2 {
+		t.Fatalf("admin events = %v", all)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/events?from=yesterday", nil)); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid from: status = %d", w.Code)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/audit/events", nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin: status = %d", w.Code)
+	}
+
+	verify := func() map[string]interface{} {
+		t.Helper()
+		w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/verify", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("verify: status = %d (%s)", w.Code, w.Body.String())
+		}
+		return decode(t, w)["verification"].(map[string]interface{})
+	}
+	if result := verify(); result["valid"] != true {
+		t.Fatalf("verification = %v", result)
+	}
+
+	// Alterar un evento guardado rompe la cadena
+	id := claims[0].(map[string]interface{})["id"].(string)
+	if err := s.docs.UpdateDocument(context.Background(), "audit_events", id, map[string]interface{}{"actor": "nobody"}); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if result := verify(); result["valid"] != false || result["broken_at"] != event["seq"] {
+		t.Fatalf("verification after tampering = %v", result)
+	}
+}
+
+func TestFailedLoginAudit(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Audit.FailedLoginLimit = 2 })
+	login := func(email, ip string) {
+		t.Helper()
+		req := request{method: http.MethodPost, path: "/api/v1/auth/login", apiKey: testAPIKey,
+			body: map[string]string{"email": email, "password": "wrong-password"}}
+		req.headers = map[string]string{"X-Forwarded-For": ip}
+		if w := s.do(req); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login: status = %d", w.Code)
+		}
+	}
+
+	// Desde una misma IP solo se registran los primeros de cada minuto
+	for i := 0; i < 4; i++ {
+		login("alice@example.com", "203.0.113.1")
+	}
+	// Un email que no es de nadie no se guarda
+	login("ghost@example.com", "203.0.113.2")
+	s.h.FlushAudit()
+
+	w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/events?action=auth.login_failed", nil))
+	if strings.Contains(w.Body.String(), "example.com") {
+		t.Fatalf("login_failed events keep the email: %s", w.Body.String())
+	}
+	targets := map[string]int{}
+	for _, item := range decode(t, w)["events"].([]interface{}) {
+		event := item.(map[string]interface{})
+		targets[event["target"].(string)]++
+		if event["target"] == "" && event["after"].(map[string]interface{})["unknown_user"] != true {
+			t.Fatalf("unknown user event = %v", event)
+		}
+	}
+	if len(targets) != 2 || targets[s.alice.uid] != 2 || targets[""] != 1 {
+		t.Fatalf("login_failed targets = %v", targets)
+	}
+}
+
+func TestBatchWrite(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Batch.MaxOperations = 3 })
+	foreign := s.seedDocument("orders", "globex", map[string]interface{}{"total": 20})
+	batch := func(u testUser, ops ...map[string]interface{}) *httptest.ResponseRecorder {
+		return s.do(as(u, "acme", http.MethodPost, "/api/v1/collections/batch", map[string]interface{}{"operations": ops}))
+	}
+	exists := func(collection, id string) bool {
+		_, err := s.docs.GetDocument(context.Background(), collection, id)
+		return err == nil
+	}
+
+	w := batch(s.alice,
+		map[string]interface{}{"op": "create", "collection": "orders", "id": "order-1",
+			"data": map[string]interface{}{"project_id": s.project, "total": 30, "subdomain": "globex"}},
+		map[string]interface{}{"op": "create", "collection": "order_items",
+			"data": map[string]interface{}{"project_id": s.project, "order_id": "order-1", "sku": "A"}},
+		map[string]interface{}{"op": "create", "collection": "order_items",
+			"data": map[string]interface{}{"project_id": s.project, "order_id": "order-1", "sku": "B"}},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch: status = %d (%s)", w.Code, w.Body.String())
+	}
+	results := decode(t, w)["results"].([]interface{})
+	if len(results) != 3 {
+		t.Fatalf("results = %v", results)
+	}
+	itemID := results[1].(map[string]interface{})["id"].(string)
+	order, err := s.docs.GetDocument(context.Background(), "orders", "order-1")
+	if err != nil {
+		t.Fatalf("order not created: %v", err)
+	}
+	if order.Data["subdomain"] != "acme" || order.Data["created_by"] != s.alice.uid {
+		t.Fatalf("order data = %v", order.Data)
+	}
+	w = s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/order_items/documents/"+itemID+"/revisions", nil))
+	if got := decode(t, w)["count"]; got != float64(1) {
+		t.Fatalf("item revisions = %v", got)
+	}
+
+	tests := []struct {
+		name      string
+		ops       []map[string]interface{}
+		want      int
+		operation float64
+	}{
+		{"other tenant document", []map[string]interface{}{
+			{"op": "create", "collection": "orders", "id": "order-2", "data": map[string]interface{}{"project_id": s.project}},
+			{"op": "update", "collection": "orders", "id": foreign, "data": map[string]interface{}{"total": 0}},
+		}, http.StatusForbidden, 1},
+		{"missing project_id", []map[string]interface{}{
+			{"op": "update", "collection": "order_items", "id": itemID, "data": map[string]interface{}{"sku": "Z"}},
+			{"op": "create", "collection": "orders", "id": "order-2", "data": map[string]interface{}{"total": 1}},
+		}, http.StatusBadRequest, 1},
+		{"existing id", []map[string]interface{}{
+			{"op": "update", "collection": "order_items", "id": itemID, "data": map[string]interface{}{"sku": "Z"}},
+			{"op": "create", "collection": "orders", "id": "order-1", "data": map[string]interface{}{"project_id": s.project}},
+		}, http.StatusConflict, 1},
+		{"stale if_match", []map[string]interface{}{
+			{"op": "delete", "collection": "order_items", "id": itemID, "if_match": `"stale"`},
+		}, http.StatusPreconditionFailed, 0},
+		{"same document twice", []map[string]interface{}{
+			{"op": "update", "collection": "order_items", "id": itemID, "data": map[string]interface{}{"sku": "Z"}},
+			{"op": "delete", "collection": "order_items", "id": itemID},
+		}, http.StatusBadRequest, 1},
+		{"internal collection", []map[string]interface{}{
+			{"op": "delete", "collection": "document_revisions", "id": "x"},
+		}, http.StatusNotFound, 0},
+		// Una subcolección se saltaría la comprobación del documento raíz
+		{"nested collection", []map[string]interface{}{
+			{"op": "create", "collection": "orders/" + foreign + "/items", "data": map[string]interface{}{"project_id": s.project}},
+		}, http.StatusNotFound, 0},
+		{"hard delete by user", []map[string]interface{}{
+			{"op": "delete", "collection": "order_items", "id": itemID, "hard": true},
+		}, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := batch(s.alice, tt.ops...)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if got := decode(t, w)["operation"]; got != tt.operation {
+				t.Fatalf("failed operation = %v, want %v", got, tt.operation)
+			}
+			// Nada del lote se aplicó
+			item, _ := s.docs.GetDocument(context.Background(), "order_items", itemID)
+			if exists("orders", "order-2") || item == nil || item.Data["sku"] != "A" {
+				t.Fatalf("batch was partially applied: order-2=%v item=%v", exists("orders", "order-2"), item)
+			}
+		})
+	}
+
+	w = batch(s.alice, map[string]interface{}{"op": "delete", "collection": "order_items", "id": itemID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if _, err := trash.Get(context.Background(), s.docs, "order_items", itemID); exists("order_items", itemID) || err != nil {
+		t.Fatalf("deleted item was not moved to trash")
+	}
+
+	ops := make([]map[string]interface{}, 4)
+	for i := range ops {
+		ops[i] = map[string]interface{}{"op": "create", "collection": "orders", "data": map[string]interface{}{"project_id": s.project}}
+	}
+	if w := batch(s.alice, ops...); w.Code != http.StatusBadRequest {
+		t.Fatalf("too many operations: status = %d", w.Code)
+	}
+}
+
+func TestSubcollections(t *testing.T) {
+	s := newTestServer(t)
+	order := s.seedDocument("orders", "acme", map[string]interface{}{"status": "new"})
+	items := "/api/v1/collections/orders/documents/" + order + "/collections/items/"
+
+	w := s.do(as(s.alice, "acme", http.MethodPost, items+"documents/", map[string]interface{}{"project_id": s.project, "sku": "A1"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create item: status = %d (%s)", w.Code, w.Body.String())
+	}
+	item := decode(t, w)["document_id"].(string)
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, items+"documents/"+item, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get item: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if data := documentData(decode(t, w)["document"]); data["sku"] != "A1" || data["subdomain"] != "acme" {
+		t.Fatalf("item = %v", data)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPatch, items+"documents/"+item, map[string]interface{}{"qty": 2})); w.Code != http.StatusOK {
+		t.Fatalf("patch item: status = %d (%s)", w.Code, w.Body.String())
+	}
+
+	// Los documentos anidados no aparecen en la colección padre
+	if got := decode(t, s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/collections/orders/documents/", nil)))["count"]; got != float64(1) {
+		t.Fatalf("orders count = %v", got)
+	}
+	w = s.do(as(s.alice, "acme", http.MethodPost, items+"query", map[string]interface{}{
+		"filters": []map[string]interface{}{
+			{"field": "project_id", "operator": "==", "value": s.project},
+			{"field": "sku", "operator": "==", "value": "A1"},
+		},
+	}))
+	if got := decode(t, w)["count"]; w.Code != http.StatusOK || got != float64(1) {
+		t.Fatalf("query items: status = %d, count = %v", w.Code, got)
+	}
+
+	// Anidamiento arbitrario
+	notes := items + "documents/" + item + "/collections/notes/documents/"
+	if w := s.do(as(s.alice, "acme", http.MethodPost, notes, map[string]interface{}{"project_id": s.project, "text": "fragile"})); w.Code != http.StatusCreated {
+		t.Fatalf("create note: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if got := decode(t, s.do(as(s.alice, "acme", http.MethodGet, notes, nil)))["count"]; got != float64(1) {
+		t.Fatalf("notes count = %v", got)
+	}
+
+	// El tenant se hereda del documento raíz
+	for _, req := range []request{
+		as(s.bob, "globex", http.MethodGet, items+"documents/"+item, nil),
+		as(s.bob, "globex", http.MethodGet, notes, nil),
+		as(s.bob, "globex", http.MethodPost, items+"documents/", map[string]interface{}{"project_id": s.project}),
+	} {
+		if w := s.do(req); w.Code != http.StatusForbidden {
+			t.Errorf("bob %s %s: status = %d, want 403", req.method, req.path, w.Code)
+		}
+	}
+	w = s.do(as(s.admin, "globex", http.MethodPost, items+"documents/", map[string]interface{}{"project_id": s.project}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("admin create item: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if data := documentData(decode(t, w)["document"]); data["subdomain"] != "acme" {
+		t.Fatalf("admin item subdomain = %v, want root subdomain", data["subdomain"])
+	}
+
+	// Rutas inválidas y documento raíz inexistente
+	for path, want := range map[string]int{
+		items + "documents/" + item + "/unknown": http.StatusNotFound,
+		items + "revisions":                      http.StatusNotFound,
+		"/api/v1/collections/orders/documents/missing/collections/items/documents/": http.StatusNotFound,
+	} {
+		if w := s.do(as(s.alice, "acme", http.MethodGet, path, nil)); w.Code != want {
+			t.Errorf("GET %s: status = %d, want %d", path, w.Code, want)
+		}
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPut, items+"documents/", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT documents: status = %d, want 405", w.Code)
+	}
+}
+
+// sseEvent es un evento leído de un stream de Server-Sent Events.
+type sseEvent struct {
+	id    string
+	event string
+	data  map[string]interface{}
+}
+
+// openStream abre un stream SSE contra srv y devuelve un canal con sus eventos; los
+// comentarios (": ping") se descartan. El stream se cierra al terminar el test.
+func openStream(t *testing.T, srv *httptest.Server, u testUser, subdomain, path, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+	req.Header.Set("X-API-KEY", testAPIKey)
+	req.Header.Set("X-Session-ID", u.session)
+	req.Header.Set("X-Client-Subdomain", subdomain)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("open stream: status = %d", resp.StatusCode)
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var current sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if current.event != "" {
+					events <- current
+				}
+				current = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				current.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				current.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.data)
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a stream event")
+	}
+	return sseEvent{}
+}
+
+func TestChangeStream(t *testing.T) {
+	s := newTestServer(t)
+	srv := httptest.NewServer(s.router)
+	t.Cleanup(srv.Close) // después de cerrar los streams (t.Cleanup va en orden inverso)
+	stream := "/api/v1/collections/orders/stream"
+	docs := "/api/v1/collections/orders/documents/"
+
+	alice := openStream(t, srv, s.alice, "acme", stream, "")
+	admin := openStream(t, srv, s.admin, "acme", stream, "")
+	open := openStream(t, srv, s.alice, "acme", stream+`?filters=[{"field":"status","operator":"==","value":"open"}]`, "")
+
+	w := s.do(as(s.bob, "globex", http.MethodPost, docs, map[string]interface{}{"project_id": s.project, "status": "open"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("bob create: status = %d", w.Code)
+	}
+	w = s.do(as(s.alice, "acme", http.MethodPost, docs, map[string]interface{}{"project_id": s.project, "status": "new"}))
+	id := decode(t, w)["document_id"].(string)
+	s.do(as(s.alice, "acme", http.MethodPut, docs+id, map[string]interface{}{"status": "open"}))
+	s.do(as(s.alice, "acme", http.MethodDelete, docs+id, nil))
+
+	// Alice no ve el documento de globex; el admin ve todos
+	created := nextEvent(t, alice)
+	if created.event != "created" || created.data["document_id"] != id || created.id == "" {
+		t.Fatalf("alice first event = %+v", created)
+	}
+	updated := nextEvent(t, alice)
+	if data, _ := updated.data["data"].(map[string]interface{}); updated.event != "updated" || data["status"] != "open" || data["updated_at"] == nil {
+		t.Fatalf("alice second event = %+v", updated)
+	}
+	if deleted := nextEvent(t, alice); deleted.event != "deleted" || deleted.data["document_id"] != id {
+		t.Fatalf("alice third event = %+v", deleted)
+	}
+	if first := nextEvent(t, admin); first.event != "created" || first.data["subdomain"] != "globex" {
+		t.Fatalf("admin first event = %+v", first)
+	}
+
+	// Con filtro solo llegan los cambios cuyo estado resultante cumple la condición
+	if e := nextEvent(t, open); e.event != "updated" || e.data["document_id"] != id {
+		t.Fatalf("filtered first event = %+v", e)
+	}
+	if e := nextEvent(t, open); e.event != "deleted" {
+		t.Fatalf("filtered second event = %+v", e)
+	}
+
+	// Reanudar desde el primer evento devuelve los siguientes del buffer
+	resumed := openStream(t, srv, s.alice, "acme", stream, created.id)
+	if e := nextEvent(t, resumed); e.event != "updated" || e.id != updated.id {
+		t.Fatalf("resumed first event = %+v", e)
+	}
+	if e := nextEvent(t, resumed); e.event != "deleted" {
+		t.Fatalf("resumed second event = %+v", e)
+	}
+	// Un ID desconocido pide recargar
+	if e := nextEvent(t, openStream(t, srv, s.alice, "acme", stream, "stale-1")); e.event != "reset" {
+		t.Fatalf("stale resume event = %+v", e)
+	}
+
+	// El admin que escucha una colección por tenant queda auditado
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/events?action=document.cross_tenant_stream", nil))
+	if got := decode(t, w)["count"]; got != float64(1) {
+		t.Fatalf("cross-tenant stream audit events = %v", got)
+	}
+
+	w = s.do(as(s.alice, "acme", http.MethodGet, stream+`?filters=[{"field":"status","operator":"~","value":1}]`, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid filter: status = %d", w.Code)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	var got []received
+	failing := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, received{r.Header.Clone(), body})
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	hooksCfg := config.Default().Webhooks
+	hooksCfg.AllowInsecureURLs = true
+	hooksCfg.MaxAttempts = 1
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Webhooks = hooksCfg })
+	dispatch := func() {
+		t.Helper()
+		if _, err := webhooks.NewDispatcher(s.docs, hooksCfg).ProcessDue(context.Background()); err != nil {
+			t.Fatalf("ProcessDue: %v", err)
+		}
+	}
+
+	// Solo los admins y los tenant admins del subdominio gestionan sus webhooks
+	owner := s.createUser("owner@example.com", map[string]interface{}{"role": "tenant_admin", "subdomain": []interface{}{"acme"}})
+	globexOwner := s.createUser("globex-owner@example.com", map[string]interface{}{"role": "tenant_admin", "subdomain": []interface{}{"globex"}})
+	if w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/webhooks/endpoints",
+		map[string]interface{}{"url": receiver.URL, "events": []string{"document.created"}})); w.Code != http.StatusForbidden {
+		t.Fatalf("create endpoint as alice: status = %d", w.Code)
+	}
+	if w := s.do(as(owner, "acme", http.MethodPost, "/api/v1/webhooks/endpoints",
+		map[string]interface{}{"url": receiver.URL, "events": []string{"document.nope"}})); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown event: status = %d", w.Code)
+	}
+	w := s.do(as(owner, "acme", http.MethodPost, "/api/v1/webhooks/endpoints",
+		map[string]interface{}{"url": receiver.URL, "events": []string{"document.created", "user.created"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: status = %d (%s)", w.Code, w.Body.String())
+	}
+	endpoint := decode(t, w)["endpoint"].(map[string]interface{})
+	endpointID, secret := endpoint["id"].(string), endpoint["secret"].(string)
+	if endpoint["subdomain"] != "acme" || secret == "" {
+		t.Fatalf("endpoint = %v", endpoint)
+	}
+
+	// El secreto no se vuelve a mostrar, y otro tenant no ve ni toca el endpoint
+	w = s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/webhooks/endpoints", nil))
+	if listed := decode(t, w)["endpoints"].([]interface{}); len(listed) != 1 || listed[0].(map[string]interface{})["secret"] != nil {
+		t.Fatalf("endpoints = %v", listed)
+	}
+	if w := s.do(as(s.bob, "globex", http.MethodGet, "/api/v1/webhooks/endpoints", nil)); decode(t, w)["count"] != float64(0) {
+		t.Fatalf("other tenant endpoints = %s", w.Body.String())
+	}
+	if w := s.do(as(globexOwner, "globex", http.MethodPatch, "/api/v1/webhooks/endpoints/"+endpointID,
+		map[string]interface{}{"active": false})); w.Code != http.StatusNotFound {
+		t.Fatalf("other tenant patch: status = %d", w.Code)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPatch, "/api/v1/webhooks/endpoints/"+endpointID,
+		map[string]interface{}{"active": false})); w.Code != http.StatusForbidden {
+		t.Fatalf("patch as alice: status = %d", w.Code)
+	}
+
+	// Crear un documento y un usuario en acme encola un evento de cada tipo; en globex nada
+	if w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "total": 10})); w.Code != http.StatusCreated {
+		t.Fatalf("create document: status = %d", w.Code)
+	}
+	if w := s.do(as(s.bob, "globex", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "total": 20})); w.Code != http.StatusCreated {
+		t.Fatalf("create document in globex: status = %d", w.Code)
+	}
+	create := as(s.admin, "acme", http.MethodPost, "/api/v1/users/",
+		map[string]interface{}{"project_id": s.project, "email": "carol@example.com", "password": "secret123"})
+	if w := s.do(create); w.Code != http.StatusCreated {
+		t.Fatalf("create user: status = %d (%s)", w.Code, w.Body.String())
+	}
+	dispatch()
+
+	mu.Lock()
+	deliveries := got
+	mu.Unlock()
+	if len(deliveries) != 2 {
+		t.Fatalf("received %d webhooks, want 2", len(deliveries))
+	}
+	events := map[string]map[string]interface{}{}
+	for _, d := range deliveries {
+		ts, _ := strconv.ParseInt(d.header.Get(webhooks.HeaderTimestamp), 10, 64)
+		if d.header.Get(webhooks.HeaderSignature) != webhooks.Sign(secret, ts, d.body) {
+			t.Fatalf("bad signature for %s", d.body)
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(d.body, &payload); err != nil {
+			t.Fatalf("payload %s: %v", d.body, err)
+		}
+		events[payload["type"].(string)] = payload["data"].(map[string]interface{})
+	}
+	if doc := events["document.created"]; doc["collection"] != "orders" || doc["data"].(map[string]interface{})["total"] != float64(10) {
+		t.Fatalf("document.created = %v", doc)
+	}
+	if user := events["user.created"]; user["email"] != "carol@example.com" {
+		t.Fatalf("user.created = %v", user)
+	}
+
+	// Con un solo intento, un fallo deja la entrega en la lista de "dead" hasta reenviarla
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	if w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "total": 30})); w.Code != http.StatusCreated {
+		t.Fatalf("create document: status = %d", w.Code)
+	}
+	dispatch()
+
+	if w := s.do(as(s.alice, "acme", http.MethodGet, "/api/v1/webhooks/deliveries", nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("deliveries as alice: status = %d", w.Code)
+	}
+	w = s.do(as(owner, "acme", http.MethodGet, "/api/v1/webhooks/deliveries?status=dead", nil))
+	dead := decode(t, w)["deliveries"].([]interface{})
+	if len(dead) != 1 || dead[0].(map[string]interface{})["last_status_code"] != float64(500) {
+		t.Fatalf("dead deliveries = %v", dead)
+	}
+	deadID := dead[0].(map[string]interface{})["id"].(string)
+	if w := s.do(as(globexOwner, "globex", http.MethodPost, "/api/v1/webhooks/deliveries/"+deadID+"/redeliver", nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("other tenant redeliver: status = %d", w.Code)
+	}
+	if w := s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/webhooks/deliveries/"+deadID+"/redeliver", nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("redeliver as alice: status = %d", w.Code)
+	}
+	if w := s.do(as(owner, "acme", http.MethodPost, "/api/v1/webhooks/deliveries/"+deadID+"/redeliver", nil)); w.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := s.do(as(owner, "acme", http.MethodPost, "/api/v1/webhooks/deliveries/"+deadID+"/redeliver", nil)); w.Code != http.StatusConflict {
+		t.Fatalf("redeliver pending: status = %d", w.Code)
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	dispatch()
+	w = s.do(as(owner, "acme", http.MethodGet, "/api/v1/webhooks/deliveries?status=delivered&limit=1", nil))
+	if body := decode(t, w); body["count"] != float64(1) || body["has_more"] != true {
+		t.Fatalf("delivered page = %v", body)
+	}
+	if w := s.do(as(owner, "acme", http.MethodGet, "/api/v1/webhooks/deliveries?status=dead", nil)); decode(t, w)["count"] != float64(0) {
+		t.Fatalf("dead after redelivery = %s", w.Body.String())
+	}
+
+	// Cambiar la URL rota el secreto; cambiar solo los eventos no
+	w = s.do(as(owner, "acme", http.MethodPatch, "/api/v1/webhooks/endpoints/"+endpointID,
+		map[string]interface{}{"events": []string{"document.created"}}))
+	if w.Code != http.StatusOK || decode(t, w)["endpoint"].(map[string]interface{})["secret"] != nil {
+		t.Fatalf("patch events: status = %d (%s)", w.Code, w.Body.String())
+	}
+	w = s.do(as(owner, "acme", http.MethodPatch, "/api/v1/webhooks/endpoints/"+endpointID,
+		map[string]interface{}{"url": receiver.URL + "/v2"}))
+	rotated, _ := decode(t, w)["endpoint"].(map[string]interface{})["secret"].(string)
+	if w.Code != http.StatusOK || rotated == "" || rotated == secret {
+		t.Fatalf("patch url: status = %d (%s)", w.Code, w.Body.String())
+	}
+	mu.Lock()
+	got = nil
+	mu.Unlock()
+	s.do(as(s.alice, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+		map[string]interface{}{"project_id": s.project, "total": 40}))
+	dispatch()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("received %d webhooks after rotation, want 1", len(got))
+	}
+	ts, _ := strconv.ParseInt(got[0].header.Get(webhooks.HeaderTimestamp), 10, 64)
+	if got[0].header.Get(webhooks.HeaderSignature) != webhooks.Sign(rotated, ts, got[0].body) {
+		t.Fatalf("delivery after rotation is not signed with the new secret")
+	}
+}
+
+func TestCreateUserRollback(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Reconcile.GracePeriod = 0 })
+	ctx := context.Background()
+	tenantAdmin := s.createUser("owner@example.com", map[string]interface{}{"role": "tenant_admin", "subdomain": []interface{}{"acme"}})
+	create := func(u testUser, email string) *httptest.ResponseRecorder {
+		return s.do(as(u, "acme", http.MethodPost, "/api/v1/users/",
+			map[string]interface{}{"project_id": s.project, "email": email, "password": "secret123"}))
+	}
+	countUsers := func() int {
+		n, _ := s.idp.CountUsers(ctx)
+		return n
+	}
+	before := countUsers()
+
+	// Si fallan las credenciales o el perfil no queda ni el usuario ni sus claims
+	s.faults.credentials = true
+	w := create(s.admin, "carol@example.com")
+	if w.Code != http.StatusInternalServerError || decode(t, w)["rolled_back"] != true {
+		t.Fatalf("credentials failure: status = %d (%s)", w.Code, w.Body.String())
+	}
+	s.faults.credentials = false
+	s.faults.collection = "profiles"
+	if w := create(tenantAdmin, "carol@example.com"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("profile failure: status = %d (%s)", w.Code, w.Body.String())
+	}
+	s.faults.collection = ""
+	if n := countUsers(); n != before {
+		t.Fatalf("users after failed creations = %d, want %d", n, before)
+	}
+	if claims, _ := s.docs.GetAllDocuments(ctx, "user_claims"); len(claims) != 0 {
+		t.Fatalf("user_claims left behind: %d", len(claims))
+	}
+
+	// El email queda libre y el alta completa funciona
+	w = create(tenantAdmin, "carol@example.com")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d (%s)", w.Code, w.Body.String())
+	}
+	carol := decode(t, w)["user"].(map[string]interface{})["uid"].(string)
+
+	// Restos de altas anteriores: un usuario sin credenciales ni perfil y un perfil huérfano
+	half, _ := s.idp.CreateUser(ctx, identity.UserToCreate{Email: "half@example.com", Password: "secret123"})
+	ghost, _ := s.docs.CreateDocument(ctx, "profiles", map[string]interface{}{"user_id": "ghost"})
+
+	reconcile := func(u testUser, query string) *httptest.ResponseRecorder {
+		return s.do(as(u, "acme", http.MethodPost, "/api/v1/users/reconcile"+query, nil))
+	}
+	if w := reconcile(tenantAdmin, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("reconcile as tenant admin: status = %d", w.Code)
+	}
+	if w := reconcile(s.admin, "?repair=maybe"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid repair: status = %d", w.Code)
+	}
+
+	issues := func(w *httptest.ResponseRecorder) map[string]map[string]interface{} {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("reconcile: status = %d (%s)", w.Code, w.Body.String())
+		}
+		out := map[string]map[string]interface{}{}
+		for _, item := range decode(t, w)["report"].(map[string]interface{})["issues"].([]interface{}) {
+			issue := item.(map[string]interface{})
+			out[issue["uid"].(string)] = issue
+		}
+		return out
+	}
+
+	// Sin ?repair solo informa. Los usuarios sembrados no tienen perfil pero ya han hecho
+	// login: se informan sin proponer borrarlos
+	dry := issues(reconcile(s.admin, ""))
+	if dry[half.UID]["kind"] != "orphaned_user" || dry[half.UID]["repair"] != "delete_user" || dry[half.UID]["repaired"] != false {
+		t.Fatalf("half-created user: %v", dry[half.UID])
+	}
+	if dry["ghost"]["kind"] != "orphaned_profile" || dry["ghost"]["profile_id"] != ghost {
+		t.Fatalf("orphaned profile: %v", dry["ghost"])
+	}
+	if dry[s.alice.uid]["kind"] != "orphaned_user" || dry[s.alice.uid]["repair"] != nil {
+		t.Fatalf("signed-in user without profile: %v", dry[s.alice.uid])
+	}
+	if _, found := dry[carol]; found {
+		t.Fatalf("complete user reported: %v", dry[carol])
+	}
+
+	repaired := issues(reconcile(s.admin, "?repair=true"))
+	if repaired[half.UID]["repaired"] != true || repaired["ghost"]["repaired"] != true || repaired[s.alice.uid]["repaired"] != false {
+		t.Fatalf("repair: %v", repaired)
+	}
+	if _, err := s.idp.GetUser(ctx, half.UID); err == nil {
+		t.Fatal("half-created user still exists")
+	}
+	if _, err := s.docs.GetDocument(ctx, "profiles", ghost); err == nil {
+		t.Fatal("orphaned profile still exists")
+	}
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/events?action=user.reconcile", nil))
+	if events := decode(t, w)["events"].([]interface{}); len(events) != 2 {
+		t.Fatalf("reconcile audit events = %d, want 2", len(events))
+	}
+}
+
+func TestDeleteUserCascade(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	tenantAdmin := s.createUser("owner@example.com", map[string]interface{}{"role": "tenant_admin", "subdomain": []interface{}{"acme"}})
+	acme := map[string]interface{}{"subdomain": []interface{}{"acme"}}
+
+	// newMember crea un usuario de acme con perfil, copia de claims y un pedido propio
+	newMember := func(email string) (testUser, string) {
+		t.Helper()
+		u := s.createUser(email, acme)
+		s.docs.CreateDocument(ctx, "profiles", map[string]interface{}{"user_id": u.uid, "project_id": s.project})
+		s.docs.CreateDocumentWithID(ctx, "user_claims", u.uid, map[string]interface{}{"claims": acme})
+		w := s.do(as(u, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+			map[string]interface{}{"project_id": s.project, "total": 10}))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create order: status = %d (%s)", w.Code, w.Body.String())
+		}
+		return u, decode(t, w)["document_id"].(string)
+	}
+	erin, erinOrder := newMember("erin@example.com")
+	aliceOrder := s.seedDocument("orders", "acme", map[string]interface{}{"created_by": s.alice.uid})
+	deleteUser := func(by testUser, u testUser, query string) *httptest.ResponseRecorder {
+		return s.do(as(by, "acme", http.MethodDelete, "/api/v1/users/"+u.uid+query, nil))
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"?documents=bogus", http.StatusBadRequest},
+		{"?documents=reassign&collections=orders", http.StatusBadRequest},
+		{"?documents=reassign&reassign_to=" + erin.uid + "&collections=orders", http.StatusBadRequest},
+		{"?documents=reassign&reassign_to=" + s.bob.uid + "&collections=orders", http.StatusBadRequest}, // bob no es de acme
+		{"?documents=anonymize", http.StatusBadRequest},                                                 // sin registro hay que indicar las colecciones
+		{"?documents=anonymize&collections=audit_events", http.StatusBadRequest},
+	} {
+		if w := deleteUser(tenantAdmin, erin, tt.query); w.Code != tt.want {
+			t.Fatalf("%s: status = %d, want %d (%s)", tt.query, w.Code, tt.want, w.Body.String())
+		}
+	}
+	if _, err := s.idp.GetUser(ctx, erin.uid); err != nil {
+		t.Fatalf("rejected deletions must not delete the user: %v", err)
+	}
+
+	w := deleteUser(tenantAdmin, erin, "?documents=reassign&reassign_to="+s.alice.uid+"&collections=orders")
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d (%s)", w.Code, w.Body.String())
+	}
+	report := decode(t, w)["report"].(map[string]interface{})
+	if report["sessions_revoked"] != 1.0 || report["profiles_deleted"] != 1.0 || report["claims_deleted"] != true ||
+		report["credentials_deleted"] != true || report["user_deleted"] != true || report["reassigned_to"] != s.alice.uid ||
+		report["documents_updated"].(map[string]interface{})["orders"] != 1.0 {
+		t.Fatalf("report = %v", report)
+	}
+
+	// No queda nada del usuario y su sesión ya no sirve
+	if w := s.do(as(erin, "acme", http.MethodGet, "/api/v1/users/"+erin.uid, nil)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked session: status = %d", w.Code)
+	}
+	if profiles, _ := s.docs.GetAllDocuments(ctx, "profiles"); len(profiles) != 0 {
+		t.Fatalf("profiles left behind: %d", len(profiles))
+	}
+	if _, err := s.docs.GetDocument(ctx, "user_claims", erin.uid); err == nil {
+		t.Fatal("user_claims left behind")
+	}
+	if ok, _ := s.idp.HasCredentials(ctx, erin.uid); ok {
+		t.Fatal("credentials left behind")
+	}
+
+	// El pedido pasa a alice, con revisión; los demás no cambian
+	order, _ := s.docs.GetDocument(ctx, "orders", erinOrder)
+	if order.Data["created_by"] != s.alice.uid || order.Data["updated_by"] != tenantAdmin.uid {
+		t.Fatalf("reassigned order = %v", order.Data)
+	}
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/collections/orders/documents/"+erinOrder+"/revisions", nil))
+	if revisions := decode(t, w)["revisions"].([]interface{}); len(revisions) != 2 {
+		t.Fatalf("revisions = %d, want 2", len(revisions))
+	}
+	if other, _ := s.docs.GetDocument(ctx, "orders", aliceOrder); other.Data["updated_by"] != nil {
+		t.Fatalf("unrelated order changed: %v", other.Data)
+	}
+
+	frank, frankOrder := newMember("frank@example.com")
+	if w := deleteUser(s.admin, frank, "?documents=anonymize&collections=orders"); w.Code != http.StatusOK {
+		t.Fatalf("delete with anonymize: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if order, _ := s.docs.GetDocument(ctx, "orders", frankOrder); order.Data["created_by"] != "deleted-user" {
+		t.Fatalf("anonymized order = %v", order.Data)
+	}
+}
+
+func TestUserExportAndErasure(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	acme := map[string]interface{}{"subdomain": []interface{}{"acme"}}
+
+	// newMember crea un usuario de acme con perfil, copia de claims, un pedido vivo y otro en la papelera
+	newMember := func(email string) (u testUser, order, trashed string) {
+		t.Helper()
+		u = s.createUser(email, acme)
+		s.docs.CreateDocument(ctx, "profiles", map[string]interface{}{"user_id": u.uid, "project_id": s.project})
+		s.docs.CreateDocumentWithID(ctx, "user_claims", u.uid, map[string]interface{}{"claims": acme})
+		ids := make([]string, 2)
+		for i := range ids {
+			w := s.do(as(u, "acme", http.MethodPost, "/api/v1/collections/orders/documents/",
+				map[string]interface{}{"project_id": s.project, "total": 10 + i}))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("create order: status = %d (%s)", w.Code, w.Body.String())
+			}
+			ids[i] = decode(t, w)["document_id"].(string)
+		}
+		if w := s.do(as(u, "acme", http.MethodDelete, "/api/v1/collections/orders/documents/"+ids[1], nil)); w.Code != http.StatusOK {
+			t.Fatalf("delete order: status = %d (%s)", w.Code, w.Body.String())
+		}
+		return u, ids[0], ids[1]
+	}
+	revisions := func(docID string) int {
+		t.Helper()
+		docs, err := s.docs.QueryDocuments(ctx, "document_revisions", firebase.QueryOptions{
+			Filters: []firebase.QueryFilter{{Field: "document_id", Operator: "==", Value: docID}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(docs)
+	}
+	dave, daveOrder, daveTrashed := newMember("dave@example.com")
+	aliceOrder := s.seedDocument("orders", "acme", map[string]interface{}{"created_by": s.alice.uid})
+
+	// Rastros de dave fuera de sus documentos: un pedido de alice que modificó (con su
+	// revisión), un documento suyo en una subcolección ajena, un login fallido con su email
+	// y una entrega de webhook con sus datos
+	aliceShared := s.seedDocument("orders", "acme", map[string]interface{}{"created_by": s.alice.uid})
+	w := s.do(as(dave, "acme", http.MethodPatch, "/api/v1/collections/orders/documents/"+aliceShared, map[string]interface{}{"note": "checked"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch shared order: status = %d (%s)", w.Code, w.Body.String())
+	}
+	itemsPath := "orders/" + aliceOrder + "/items"
+	w = s.do(as(dave, "acme", http.MethodPost, "/api/v1/collections/orders/documents/"+aliceOrder+"/collections/items/documents/",
+		map[string]interface{}{"project_id": s.project, "sku": "A1"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create item: status = %d (%s)", w.Code, w.Body.String())
+	}
+	daveItem := decode(t, w)["document_id"].(string)
+	s.do(request{method: http.MethodPost, path: "/api/v1/auth/login", apiKey: testAPIKey,
+		body: map[string]string{"email": "dave@example.com", "password": "wrong-password"}})
+	s.h.FlushAudit()
+	payload, _ := json.Marshal(map[string]interface{}{"id": "evt1", "type": "user.created", "subdomain": "acme",
+		"data": map[string]interface{}{"uid": dave.uid, "email": "dave@example.com"}})
+	delivery, _ := s.docs.CreateDocument(ctx, "webhook_deliveries", map[string]interface{}{
+		"event_type": "user.created", "event_id": "evt1", "subdomain": "acme", "payload": string(payload), "status": "delivered"})
+
+	// Solo los admins exportan y borran
+	for _, path := range []string{"/export", "/erase"} {
+		method := http.MethodGet
+		if path == "/erase" {
+			method = http.MethodPost
+		}
+		if w := s.do(as(s.alice, "acme", method, "/api/v1/users/"+dave.uid+path+"?collections=orders", nil)); w.Code != http.StatusForbidden {
+			t.Fatalf("%s by non-admin: status = %d, want 403", path, w.Code)
+		}
+	}
+
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+dave.uid+"/export?collections=orders", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("export: status = %d, disposition = %q (%s)", w.Code, w.Header().Get("Content-Disposition"), w.Body.String())
+	}
+	bundle := decode(t, w)
+	orders := bundle["documents"].(map[string]interface{})["orders"].([]interface{})
+	if bundle["uid"] != dave.uid || bundle["user"].(map[string]interface{})["email"] != "dave@example.com" ||
+		len(bundle["profiles"].([]interface{})) != 1 || bundle["claims"] == nil ||
+		len(bundle["sessions"].([]interface{})) != 1 || len(orders) != 1 || orders[0].(map[string]interface{})["id"] != daveOrder {
+		t.Fatalf("export = %v", bundle)
+	}
+	if strings.Contains(w.Body.String(), dave.session) {
+		t.Fatal("export leaks the session ID")
+	}
+	items, _ := bundle["documents"].(map[string]interface{})[itemsPath].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["id"] != daveItem {
+		t.Fatalf("exported subcollection documents = %v", bundle["documents"])
+	}
+	updated := bundle["updated_documents"].([]interface{})
+	if len(updated) != 1 || updated[0].(map[string]interface{})["document_id"] != aliceShared {
+		t.Fatalf("exported updated documents = %v", updated)
+	}
+	var sharedRevision map[string]interface{}
+	for _, item := range bundle["revisions"].([]interface{}) {
+		if rev := item.(map[string]interface{}); rev["document_id"] == aliceShared {
+			sharedRevision = rev
+		}
+	}
+	// La revisión de un documento ajeno se exporta sin sus datos, que son de alice
+	if sharedRevision == nil || sharedRevision["actor"] != dave.uid || sharedRevision["data"] != nil {
+		t.Fatalf("exported revisions = %v", bundle["revisions"])
+	}
+	var loginFailed bool
+	for _, item := range bundle["audit_events"].([]interface{}) {
+		event := item.(map[string]interface{})
+		loginFailed = loginFailed || (event["action"] == "auth.login_failed" && event["target"] == dave.uid)
+	}
+	if !loginFailed {
+		t.Fatalf("exported audit events = %v", bundle["audit_events"])
+	}
+	hooks := bundle["webhook_events"].([]interface{})
+	if len(hooks) != 1 || hooks[0].(map[string]interface{})["data"].(map[string]interface{})["email"] != "dave@example.com" {
+		t.Fatalf("exported webhook events = %v", hooks)
+	}
+
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+dave.uid+"/export?format=zip&collections=orders", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("zip export: status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip export: %v", err)
+	}
+	var files []string
+	for _, f := range archive.File {
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	want := []string{"audit_events.json", "claims.json", "documents/orders.json", "documents/" + url.PathEscape(itemsPath) + ".json",
+		"profiles.json", "revisions.json", "sessions.json", "updated_documents.json", "user.json", "webhook_events.json"}
+	sort.Strings(want)
+	if !slices.Equal(files, want) {
+		t.Fatalf("zip files = %v, want %s", files, want)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/users/"+dave.uid+"/export?format=csv", nil)); w.Code != http.StatusBadRequest {
+		t.Fatalf("export with bad format: status = %d", w.Code)
+	}
+
+	// Seudonimizar: los pedidos se quedan, sin el UID ni su historial
+	w = s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/"+dave.uid+"/erase?mode=pseudonymize&collections=orders", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("pseudonymize: status = %d (%s)", w.Code, w.Body.String())
+	}
+	receipt := decode(t, w)["receipt"].(map[string]interface{})
+	erased := receipt["erased"].(map[string]interface{})
+	pseudonym, _ := receipt["pseudonym"].(string)
+	if receipt["uid"] != dave.uid || receipt["mode"] != "pseudonymize" || !strings.HasPrefix(pseudonym, "erased-") ||
+		erased["user_deleted"] != true || erased["sessions_revoked"] != 1.0 || erased["profiles_deleted"] != 1.0 ||
+		erased["documents"].(map[string]interface{})["orders"] != 1.0 || erased["trash_deleted"] != 1.0 {
+		t.Fatalf("receipt = %v", receipt)
+	}
+	if order, _ := s.docs.GetDocument(ctx, "orders", daveOrder); order.Data["created_by"] != pseudonym {
+		t.Fatalf("pseudonymized order = %v", order.Data)
+	}
+	if entry, _ := trash.Get(ctx, s.docs, "orders", daveTrashed); entry.Data["created_by"] != pseudonym {
+		t.Fatalf("pseudonymized trash entry = %v", entry.Data)
+	}
+	if n := revisions(daveOrder) + revisions(daveTrashed); n != 0 {
+		t.Fatalf("revisions left behind: %d", n)
+	}
+	if _, err := s.idp.GetUser(ctx, dave.uid); err == nil {
+		t.Fatal("user left behind")
+	}
+	if erased["updated_by_replaced"] != 1.0 || erased["revisions_redacted"] != 1.0 || erased["webhook_deliveries_redacted"] != 1.0 ||
+		erased["audit_events_redacted"] == 0.0 || erased["documents"].(map[string]interface{})[itemsPath] != 1.0 || len(receipt["retained"].([]interface{})) != 2 {
+		t.Fatalf("receipt = %v", receipt)
+	}
+
+	// Lo que no era suyo se conserva sin sus datos
+	if item, err := s.docs.GetDocument(ctx, itemsPath, daveItem); err != nil || item.Data["created_by"] != pseudonym {
+		t.Fatalf("pseudonymized item = %v, %v", item, err)
+	}
+	if order, _ := s.docs.GetDocument(ctx, "orders", aliceShared); order.Data["updated_by"] != pseudonym || order.Data["created_by"] != s.alice.uid {
+		t.Fatalf("shared order = %v", order.Data)
+	}
+	shared, _ := s.docs.QueryDocuments(ctx, "document_revisions", firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "document_id", Operator: "==", Value: aliceShared}},
+	})
+	if len(shared) != 1 || shared[0].Data["actor"] != pseudonym {
+		t.Fatalf("shared order revisions = %v", shared)
+	}
+	if doc, _ := s.docs.GetDocument(ctx, "webhook_deliveries", delivery); strings.Contains(doc.Data["payload"].(string), "dave@example.com") {
+		t.Fatalf("webhook delivery keeps the email: %v", doc.Data["payload"])
+	}
+	events, _ := s.docs.GetAllDocuments(ctx, "audit_events")
+	for _, doc := range events {
+		event := auditlog.FromDocument(doc)
+		if event.Action == "user.erase" {
+			continue
+		}
+		if body, _ := json.Marshal(event); strings.Contains(string(body), "dave@example.com") || strings.Contains(string(body), dave.uid) {
+			t.Fatalf("audit event keeps dave's data: %s", body)
+		}
+	}
+	w = s.do(as(s.admin, "acme", http.MethodGet, "/api/v1/audit/verify", nil))
+	if result := decode(t, w)["verification"].(map[string]interface{}); result["valid"] != true || result["redacted"] == 0.0 {
+		t.Fatalf("audit chain after redaction = %v", result)
+	}
+
+	// El recibo se verifica tal cual y deja de hacerlo si se modifica
+	verify := func(r map[string]interface{}) bool {
+		t.Helper()
+		w := s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/erasure-receipts/verify", r))
+		if w.Code != http.StatusOK {
+			t.Fatalf("verify: status = %d (%s)", w.Code, w.Body.String())
+		}
+		return decode(t, w)["valid"] == true
+	}
+	if !verify(receipt) {
+		t.Fatal("receipt does not verify")
+	}
+	receipt["uid"] = s.alice.uid
+	if verify(receipt) {
+		t.Fatal("tampered receipt verifies")
+	}
+
+	// Borrar: los pedidos y la papelera desaparecen; los de otros usuarios no cambian
+	erin, erinOrder, erinTrashed := newMember("erin@example.com")
+	w = s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/"+erin.uid+"/erase?collections=orders", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("erase: status = %d (%s)", w.Code, w.Body.String())
+	}
+	if mode := decode(t, w)["receipt"].(map[string]interface{})["mode"]; mode != "delete" {
+		t.Fatalf("mode = %v", mode)
+	}
+	if _, err := s.docs.GetDocument(ctx, "orders", erinOrder); err == nil {
+		t.Fatal("erased order left behind")
+	}
+	if _, err := trash.Get(ctx, s.docs, "orders", erinTrashed); err == nil {
+		t.Fatal("erased trash entry left behind")
+	}
+	if n := revisions(erinOrder) + revisions(erinTrashed); n != 0 {
+		t.Fatalf("revisions left behind: %d", n)
+	}
+	if order, err := s.docs.GetDocument(ctx, "orders", aliceOrder); err != nil || order.Data["created_by"] != s.alice.uid {
+		t.Fatalf("unrelated order changed: %v, %v", order, err)
+	}
+	if w := s.do(as(s.admin, "acme", http.MethodPost, "/api/v1/users/"+erin.uid+"/erase?mode=bogus", nil)); w.Code != http.StatusBadRequest {
+		t.Fatalf("erase with bad mode: status = %d", w.Code)
+	}
+}
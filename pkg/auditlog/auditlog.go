@@ -0,0 +1,524 @@
+// Package auditlog implementa el registro de auditoría de eventos de autenticación y
+// administración. Los eventos solo se añaden y forman una cadena de hashes: cada evento
+// incluye el hash del anterior, de modo que modificar o borrar uno rompe la cadena y
+// Verify lo detecta. Los hashes son HMAC-SHA256 con una clave del servidor, así que quien
+// solo tiene acceso al backend no puede recalcular la cadena tras alterarla.
+//
+// Los datos personales de un evento (actor, objetivo, IP y estados) se pueden redactar con
+// Redact sin romper la cadena: Hash firma su PayloadHash y no los datos, y cada redacción
+// queda declarada en un evento ActionRedact posterior.
+package auditlog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// Collection es la colección donde se guardan los eventos de auditoría.
+const Collection = "audit_events"
+
+// verifyBatchSize es el número de eventos que Verify lee por consulta.
+const verifyBatchSize = 200
+
+// recordAttempts es cuántas veces se reintenta un lote cuando otra réplica ocupó el mismo Seq.
+const recordAttempts = 5
+
+// recordBatchSize es el número máximo de eventos que se añaden a la cadena en una escritura.
+const recordBatchSize = 100
+
+// maxAsyncEvents es cuántos eventos de RecordAsync pueden esperar a escribirse a la vez.
+const maxAsyncEvents = 1000
+
+// ActionRedact es el evento que añade Redact; After["seqs"] son los eventos redactados.
+const ActionRedact = "audit.redact"
+
+// Event es un evento de auditoría. Before y After son el estado del objetivo antes y
+// después de la acción (nil si no aplica).
+type Event struct {
+	ID        string                 `json:"id"`
+	Seq       int64                  `json:"seq"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"`
+	Subdomain string                 `json:"subdomain,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	// PayloadHash firma los datos personales del evento (actor, objetivo, IP y estados);
+	// la cadena enlaza este hash y no los datos.
+	PayloadHash string `json:"payload_hash"`
+	PrevHash    string `json:"prev_hash"`
+	Hash        string `json:"hash"`
+	// Redacted indica que Redact sustituyó los datos personales: PayloadHash ya no los cubre.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// payloadInput es lo que firma PayloadHash. Before y After van como el texto JSON
+// guardado, para que el hash no dependa de cómo el backend devuelve los tipos.
+type payloadInput struct {
+	Actor  string `json:"actor"`
+	Target string `json:"target"`
+	IP     string `json:"ip"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// hashInput es lo que firma Hash: el evento sin sus datos personales, su PayloadHash y
+// el hash del anterior.
+type hashInput struct {
+	Seq         int64  `json:"seq"`
+	Action      string `json:"action"`
+	Subdomain   string `json:"subdomain"`
+	RequestID   string `json:"request_id"`
+	CreatedAt   string `json:"created_at"`
+	PayloadHash string `json:"payload_hash"`
+	PrevHash    string `json:"prev_hash"`
+}
+
+// Logger añade eventos a la cadena. Cada evento se crea con una escritura que falla si su
+// Seq ya existe, así que varias réplicas pueden escribir en la misma colección: la que
+// pierde la carrera vuelve a leer la cabeza y reintenta con el Seq siguiente.
+//
+// Los eventos se encolan y un único escritor los añade por lotes de hasta recordBatchSize
+// en una sola escritura, así que las peticiones no se esperan unas a otras evento a evento:
+// el candado de la cola solo se toma para encolar.
+type Logger struct {
+	store storage.DocumentStore
+	key   []byte
+
+	// head es el último evento escrito (nil hasta que se lee del backend). Solo lo usa el
+	// escritor, y nunca hay más de uno a la vez.
+	head *Event
+
+	mu      sync.Mutex
+	queue   []pendingEvent
+	async   int  // eventos de RecordAsync en queue o escribiéndose
+	writing bool // hay un escritor vaciando queue
+	pending sync.WaitGroup
+}
+
+// pendingEvent es un evento encolado. done recibe el resultado; es nil en RecordAsync.
+type pendingEvent struct {
+	event Event
+	done  chan recordResult
+}
+
+type recordResult struct {
+	event Event
+	err   error
+}
+
+// New crea un Logger que guarda los eventos en store firmados con key (audit.hmac_secret).
+func New(store storage.DocumentStore, key []byte) *Logger {
+	return &Logger{store: store, key: key}
+}
+
+// Record añade event al final de la cadena y lo devuelve con Seq, CreatedAt y los hashes
+// rellenados. Se escribe aunque ctx se cancele: el evento ya ocurrió.
+func (l *Logger) Record(ctx context.Context, event Event) (Event, error) {
+	done := make(chan recordResult, 1)
+	l.enqueue(pendingEvent{event: event, done: done})
+	result := <-done
+	return result.event, result.err
+}
+
+// RecordAsync encola event sin esperar a que se escriba, para los eventos que no deben
+// frenar la petición que los provoca. Si ya hay maxAsyncEvents esperando, lo descarta y
+// devuelve false. Los errores de escritura solo se registran en el log.
+func (l *Logger) RecordAsync(event Event) bool {
+	l.mu.Lock()
+	if l.async >= maxAsyncEvents {
+		l.mu.Unlock()
+		return false
+	}
+	l.async++
+	l.pending.Add(1)
+	l.mu.Unlock()
+	l.enqueue(pendingEvent{event: event})
+	return true
+}
+
+// Flush espera a que se escriban los eventos encolados con RecordAsync.
+func (l *Logger) Flush() {
+	l.pending.Wait()
+}
+
+// enqueue añade p a la cola y arranca el escritor si no hay uno en marcha.
+func (l *Logger) enqueue(p pendingEvent) {
+	l.mu.Lock()
+	l.queue = append(l.queue, p)
+	start := !l.writing
+	l.writing = true
+	l.mu.Unlock()
+	if start {
+		go l.write()
+	}
+}
+
+// write vacía la cola por lotes hasta que queda vacía.
+func (l *Logger) write() {
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			l.writing = false
+			l.mu.Unlock()
+			return
+		}
+		batch := l.queue[:min(len(l.queue), recordBatchSize)]
+		l.queue = l.queue[len(batch):]
+		l.mu.Unlock()
+
+		events := make([]Event, 0, len(batch))
+		waiting := make([]pendingEvent, 0, len(batch))
+		for _, p := range batch {
+			// Un evento que no se puede codificar no debe tumbar el lote entero
+			if _, _, err := encodeStates(p.event); err != nil {
+				l.finish(p, Event{}, err)
+				continue
+			}
+			events = append(events, p.event)
+			waiting = append(waiting, p)
+		}
+		written, err := l.appendEvents(context.Background(), events)
+		for i, p := range waiting {
+			var event Event
+			if err == nil {
+				event = written[i]
+			}
+			l.finish(p, event, err)
+		}
+	}
+}
+
+// finish entrega el resultado de p a quien lo espera o, si es de RecordAsync, lo descuenta.
+func (l *Logger) finish(p pendingEvent, event Event, err error) {
+	if p.done != nil {
+		p.done <- recordResult{event: event, err: err}
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ No se pudo registrar el evento de auditoría %s sobre %s: %v", p.event.Action, p.event.Target, err)
+	}
+	l.mu.Lock()
+	l.async--
+	l.mu.Unlock()
+	l.pending.Done()
+}
+
+// appendEvents encadena events tras la cabeza y los escribe en una sola escritura; si otra
+// réplica ocupó alguno de sus Seq, relee la cabeza y vuelve a encadenarlos.
+func (l *Logger) appendEvents(ctx context.Context, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	for attempt := 1; ; attempt++ {
+		if l.head == nil {
+			head, err := l.loadHead(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("load audit chain head: %w", err)
+			}
+			l.head = head
+		}
+
+		// Firestore guarda los timestamps con precisión de microsegundos
+		createdAt := time.Now().UTC().Truncate(time.Microsecond)
+		chained := make([]Event, len(events))
+		writes := make([]storage.BatchWrite, len(events))
+		prev := *l.head
+		for i, event := range events {
+			event.Seq = prev.Seq + 1
+			event.PrevHash = prev.Hash
+			event.CreatedAt = createdAt
+			event.ID = eventID(event.Seq)
+
+			before, after, _ := encodeStates(event)
+			event.PayloadHash = l.payloadHash(event, before, after)
+			event.Hash = l.chainHash(event)
+
+			writes[i] = storage.BatchWrite{Op: storage.BatchCreate, Collection: Collection, ID: event.ID, Data: map[string]interface{}{
+				"seq":          event.Seq,
+				"actor":        event.Actor,
+				"action":       event.Action,
+				"target":       event.Target,
+				"subdomain":    event.Subdomain,
+				"ip":           event.IP,
+				"request_id":   event.RequestID,
+				"before_json":  before,
+				"after_json":   after,
+				"created_at":   event.CreatedAt,
+				"payload_hash": event.PayloadHash,
+				"prev_hash":    event.PrevHash,
+				"hash":         event.Hash,
+			}}
+			chained[i] = event
+			prev = event
+		}
+
+		err := l.store.ApplyBatch(ctx, writes)
+		if err == nil {
+			l.head = &chained[len(chained)-1]
+			return chained, nil
+		}
+		// Otra réplica escribió alguno de esos Seq, o no sabemos si llegó a escribirse: se
+		// vuelve a leer la cabeza antes del siguiente intento
+		l.head = nil
+		if !errors.Is(err, storage.ErrAlreadyExists) || attempt == recordAttempts {
+			return nil, err
+		}
+	}
+}
+
+// Redact sustituye los datos personales (Actor, Target, IP, Before y After) de events por
+// los que traen y los marca como redactados. Antes añade a la cadena redaction con la
+// acción ActionRedact y los Seq redactados, de modo que Verify distingue una redacción de
+// una edición: un evento redactado que ningún ActionRedact declara rompe la cadena.
+func (l *Logger) Redact(ctx context.Context, events []Event, redaction Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	seqs := make([]interface{}, len(events))
+	for i, event := range events {
+		seqs[i] = event.Seq
+	}
+	redaction.Action = ActionRedact
+	redaction.After = map[string]interface{}{"seqs": seqs}
+	if _, err := l.Record(ctx, redaction); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		before, after, err := encodeStates(event)
+		if err != nil {
+			return err
+		}
+		err = l.store.UpdateDocument(ctx, Collection, eventID(event.Seq), map[string]interface{}{
+			"actor":       event.Actor,
+			"target":      event.Target,
+			"ip":          event.IP,
+			"before_json": before,
+			"after_json":  after,
+			"redacted":    true,
+		})
+		if err != nil {
+			return fmt.Errorf("redact audit event %d: %w", event.Seq, err)
+		}
+	}
+	return nil
+}
+
+// loadHead devuelve el último evento guardado, o un evento vacío (Seq 0) si no hay ninguno.
+func (l *Logger) loadHead(ctx context.Context) (*Event, error) {
+	docs, err := l.store.QueryDocuments(ctx, Collection, firebase.QueryOptions{
+		OrderBy: []firebase.OrderBy{{Field: "seq", Direction: "desc"}},
+		Limit:   1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return &Event{}, nil
+	}
+	head := FromDocument(docs[0])
+	return &head, nil
+}
+
+// eventID rellena con ceros para que el orden por ID coincida con el de la cadena.
+func eventID(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func encodeStates(event Event) (before, after string, err error) {
+	if before, err = encodeState(event.Before); err != nil {
+		return "", "", fmt.Errorf("encode before: %w", err)
+	}
+	if after, err = encodeState(event.After); err != nil {
+		return "", "", fmt.Errorf("encode after: %w", err)
+	}
+	return before, after, nil
+}
+
+func encodeState(state map[string]interface{}) (string, error) {
+	if state == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(state)
+	return string(data), err
+}
+
+func (l *Logger) payloadHash(event Event, before, after string) string {
+	return sign(l.key, payloadInput{
+		Actor:  event.Actor,
+		Target: event.Target,
+		IP:     event.IP,
+		Before: before,
+		After:  after,
+	})
+}
+
+func (l *Logger) chainHash(event Event) string {
+	return sign(l.key, hashInput{
+		Seq:         event.Seq,
+		Action:      event.Action,
+		Subdomain:   event.Subdomain,
+		RequestID:   event.RequestID,
+		CreatedAt:   event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		PayloadHash: event.PayloadHash,
+		PrevHash:    event.PrevHash,
+	})
+}
+
+func sign(key []byte, input interface{}) string {
+	data, _ := json.Marshal(input)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FromDocument convierte un documento de Collection en Event.
+func FromDocument(doc *firebase.Document) Event {
+	event := Event{ID: doc.ID}
+	event.Seq = toInt64(doc.Data["seq"])
+	event.Actor, _ = doc.Data["actor"].(string)
+	event.Action, _ = doc.Data["action"].(string)
+	event.Target, _ = doc.Data["target"].(string)
+	event.Subdomain, _ = doc.Data["subdomain"].(string)
+	event.IP, _ = doc.Data["ip"].(string)
+	event.RequestID, _ = doc.Data["request_id"].(string)
+	event.CreatedAt, _ = doc.Data["created_at"].(time.Time)
+	event.PayloadHash, _ = doc.Data["payload_hash"].(string)
+	event.PrevHash, _ = doc.Data["prev_hash"].(string)
+	event.Hash, _ = doc.Data["hash"].(string)
+	event.Redacted, _ = doc.Data["redacted"].(bool)
+	if raw, _ := doc.Data["before_json"].(string); raw != "" {
+		_ = json.Unmarshal([]byte(raw), &event.Before)
+	}
+	if raw, _ := doc.Data["after_json"].(string); raw != "" {
+		_ = json.Unmarshal([]byte(raw), &event.After)
+	}
+	return event
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// Verification es el resultado de Verify. Si la cadena está rota, BrokenAt es el Seq del
+// primer evento que no cuadra y Reason explica por qué.
+type Verification struct {
+	Valid   bool `json:"valid"`
+	Checked int  `json:"checked"`
+	// Redacted es cuántos eventos tienen los datos personales redactados.
+	Redacted int    `json:"redacted"`
+	HeadSeq  int64  `json:"head_seq"`
+	HeadHash string `json:"head_hash,omitempty"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify recorre la cadena completa desde el primer evento y comprueba que cada hash
+// corresponda a su contenido, que enlace con el anterior y que no falten eventos. De los
+// eventos redactados no se puede comprobar el contenido, pero sí que un ActionRedact
+// posterior los declare.
+func (l *Logger) Verify(ctx context.Context) (Verification, error) {
+	options := firebase.QueryOptions{
+		OrderBy: []firebase.OrderBy{{Field: "seq", Direction: "asc"}},
+		Limit:   verifyBatchSize,
+	}
+	result := Verification{Valid: true}
+	var prev Event
+	var after *storage.Cursor
+	// undeclared son los eventos redactados que todavía no ha declarado ningún ActionRedact
+	var undeclared []int64
+	for {
+		docs, err := l.store.QueryDocumentsAfter(ctx, Collection, options, after)
+		if err != nil {
+			return result, err
+		}
+		for _, doc := range docs {
+			event := FromDocument(doc)
+			if reason := l.checkLink(prev, event, doc); reason != "" {
+				result.Valid, result.BrokenAt, result.Reason = false, event.Seq, reason
+				return result, nil
+			}
+			if event.Redacted {
+				result.Redacted++
+				undeclared = append(undeclared, event.Seq)
+			}
+			if event.Action == ActionRedact && !event.Redacted {
+				undeclared = declareRedacted(undeclared, event.After["seqs"])
+			}
+			result.Checked++
+			result.HeadSeq, result.HeadHash = event.Seq, event.Hash
+			prev = event
+		}
+		if len(docs) < verifyBatchSize {
+			break
+		}
+		after = storage.CursorAfter(docs[len(docs)-1], options)
+	}
+	if len(undeclared) > 0 {
+		result.Valid, result.BrokenAt, result.Reason = false, undeclared[0], "redacted without an audit.redact event"
+	}
+	return result, nil
+}
+
+// declareRedacted quita de undeclared los Seq de seqs (After["seqs"] de un ActionRedact).
+func declareRedacted(undeclared []int64, seqs interface{}) []int64 {
+	list, _ := seqs.([]interface{})
+	declared := make(map[int64]bool, len(list))
+	for _, seq := range list {
+		declared[toInt64(seq)] = true
+	}
+	remaining := undeclared[:0]
+	for _, seq := range undeclared {
+		if !declared[seq] {
+			remaining = append(remaining, seq)
+		}
+	}
+	return remaining
+}
+
+func (l *Logger) checkLink(prev, event Event, doc *firebase.Document) string {
+	switch {
+	case event.Seq != prev.Seq+1:
+		return fmt.Sprintf("expected seq %d, found %d", prev.Seq+1, event.Seq)
+	case event.ID != eventID(event.Seq):
+		return "document ID does not match seq"
+	case event.PrevHash != prev.Hash:
+		return "prev_hash does not match the previous event"
+	}
+	if !hmac.Equal([]byte(l.chainHash(event)), []byte(event.Hash)) {
+		return "hash does not match the event content"
+	}
+	if event.Redacted {
+		return ""
+	}
+	before, _ := doc.Data["before_json"].(string)
+	after, _ := doc.Data["after_json"].(string)
+	if !hmac.Equal([]byte(l.payloadHash(event, before, after)), []byte(event.PayloadHash)) {
+		return "payload_hash does not match the event content"
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package auditlog
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+)
+
+var testKey = []byte("audit-secret")
+
+func TestRecordChainsEvents(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	logger := New(store, testKey)
+
+	first, err := logger.Record(ctx, Event{Actor: "admin", Action: "user.claims.set", Target: "u1",
+		Before: map[string]interface{}{"role": "user"}, After: map[string]interface{}{"role": "admin"}})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	second, err := logger.Record(ctx, Event{Actor: "u1", Action: "auth.login", Target: "u1"})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if first.Seq != 1 || second.Seq != 2 || second.PrevHash != first.Hash || first.PrevHash != "" {
+		t.Fatalf("chain = %+v -> %+v", first, second)
+	}
+
+	// Un Logger nuevo (p. ej. tras reiniciar) continúa la cadena existente
+	third, err := New(store, testKey).Record(ctx, Event{Actor: "u1", Action: "auth.logout", Target: "u1"})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if third.Seq != 3 || third.PrevHash != second.Hash {
+		t.Fatalf("third event = %+v", third)
+	}
+
+	result, err := logger.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 || result.HeadHash != third.Hash {
+		t.Fatalf("Verify = %+v", result)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(store *storage.MemoryStore)
+		broken int64
+	}{
+		{"edited field", func(store *storage.MemoryStore) {
+			_ = store.UpdateDocument(context.Background(), Collection, eventID(2), map[string]interface{}{"actor": "someone-else"})
+		}, 2},
+		{"edited before state", func(store *storage.MemoryStore) {
+			_ = store.UpdateDocument(context.Background(), Collection, eventID(1), map[string]interface{}{"after_json": `{"role":"user"}`})
+		}, 1},
+		// Marcar un evento como redactado no basta: la redacción debe estar en la cadena
+		{"edited field marked as redacted", func(store *storage.MemoryStore) {
+			_ = store.UpdateDocument(context.Background(), Collection, eventID(2), map[string]interface{}{"actor": "someone-else", "redacted": true})
+		}, 2},
+		{"deleted event", func(store *storage.MemoryStore) {
+			_ = store.DeleteDocument(context.Background(), Collection, eventID(2))
+		}, 3},
+		// Sin la clave del servidor no se puede rehacer la cadena tras editar un evento
+		{"chain rebuilt with another key", func(store *storage.MemoryStore) {
+			ctx := context.Background()
+			for seq := int64(1); seq <= 3; seq++ {
+				_ = store.DeleteDocument(ctx, Collection, eventID(seq))
+			}
+			forger := New(store, []byte("guessed"))
+			for _, action := range []string{"user.claims.set", "auth.login", "auth.logout"} {
+				_, _ = forger.Record(ctx, Event{Actor: "someone-else", Action: action, Target: "u1"})
+			}
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := storage.NewMemoryStore()
+			logger := New(store, testKey)
+			for _, action := range []string{"user.claims.set", "auth.login", "auth.logout"} {
+				if _, err := logger.Record(ctx, Event{Actor: "u1", Action: action, Target: "u1",
+					After: map[string]interface{}{"role": "admin"}}); err != nil {
+					t.Fatalf("Record: %v", err)
+				}
+			}
+
+			tt.tamper(store)
+
+			result, err := logger.Verify(ctx)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if result.Valid || result.BrokenAt != tt.broken {
+				t.Fatalf("Verify = %+v, want broken at %d", result, tt.broken)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	logger := New(store, testKey)
+
+	var events []Event
+	for _, target := range []string{"ana@example.com", "u2", "ana@example.com"} {
+		event, err := logger.Record(ctx, Event{Actor: "u1", Action: "auth.login_failed", Target: target, IP: "10.0.0.1",
+			After: map[string]interface{}{"email": target}})
+		if err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	redacted := []Event{events[0], events[2]}
+	for i := range redacted {
+		redacted[i].Target, redacted[i].IP, redacted[i].After = "erased", "", nil
+	}
+	if err := logger.Redact(ctx, redacted, Event{Actor: "admin"}); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+
+	result, err := logger.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.Checked != 4 || result.Redacted != 2 {
+		t.Fatalf("Verify = %+v", result)
+	}
+	doc, _ := store.GetDocument(ctx, Collection, eventID(1))
+	if event := FromDocument(doc); event.Target != "erased" || event.IP != "" || event.After != nil || !event.Redacted || event.Actor != "u1" {
+		t.Fatalf("redacted event = %+v", event)
+	}
+	doc, _ = store.GetDocument(ctx, Collection, eventID(4))
+	if event := FromDocument(doc); event.Action != ActionRedact || event.Actor != "admin" {
+		t.Fatalf("redaction event = %+v", event)
+	}
+
+	// El evento no redactado sigue protegido
+	_ = store.UpdateDocument(ctx, Collection, eventID(2), map[string]interface{}{"target": "u3"})
+	if result, _ := logger.Verify(ctx); result.Valid || result.BrokenAt != 2 {
+		t.Fatalf("Verify after tampering = %+v", result)
+	}
+}
+
+func TestRecordFromSeveralReplicas(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	first, second := New(store, testKey), New(store, testKey)
+
+	// Cada Logger cree conocer la cabeza; el otro ya ocupó ese Seq y no se sobreescribe
+	for i := 0; i < 3; i++ {
+		for _, logger := range []*Logger{first, second} {
+			if _, err := logger.Record(ctx, Event{Actor: "u1", Action: "auth.login", Target: "u1"}); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+		}
+	}
+
+	result, err := first.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.Checked != 6 || result.HeadSeq != 6 {
+		t.Fatalf("Verify = %+v", result)
+	}
+}
+
+// countingStore cuenta las escrituras por lotes.
+type countingStore struct {
+	storage.DocumentStore
+	mu      sync.Mutex
+	batches int
+}
+
+func (s *countingStore) ApplyBatch(ctx context.Context, writes []storage.BatchWrite) error {
+	s.mu.Lock()
+	s.batches++
+	s.mu.Unlock()
+	return s.DocumentStore.ApplyBatch(ctx, writes)
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{DocumentStore: storage.NewMemoryStore()}
+	logger := New(store, testKey)
+
+	const n = 50
+	var wg sync.WaitGroup
+	seqs := make(chan int64, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event, err := logger.Record(ctx, Event{Actor: "u1", Action: "auth.login", Target: "u1"})
+			if err != nil {
+				t.Errorf("Record: %v", err)
+			}
+			seqs <- event.Seq
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+
+	seen := map[int64]bool{}
+	for seq := range seqs {
+		seen[seq] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("distinct seqs = %d, want %d", len(seen), n)
+	}
+	result, err := logger.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.HeadSeq != n {
+		t.Fatalf("Verify = %+v", result)
+	}
+	// Los eventos que llegan mientras se escribe un lote van juntos en el siguiente
+	if store.batches > n {
+		t.Fatalf("batches = %d for %d events", store.batches, n)
+	}
+}
+
+func TestRecordAsync(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	logger := New(store, testKey)
+
+	for i := 0; i < 3; i++ {
+		if !logger.RecordAsync(Event{Action: "auth.login_failed", IP: "10.0.0.1"}) {
+			t.Fatal("RecordAsync dropped an event with an empty queue")
+		}
+	}
+	logger.Flush()
+
+	result, err := logger.Verify(ctx)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.HeadSeq != 3 {
+		t.Fatalf("Verify = %+v", result)
+	}
+
+	// Con la cola llena se descarta en lugar de esperar
+	logger.mu.Lock()
+	logger.async = maxAsyncEvents
+	logger.mu.Unlock()
+	if logger.RecordAsync(Event{Action: "auth.login_failed"}) {
+		t.Fatal("RecordAsync accepted an event with a full queue")
+	}
+}
@@ -0,0 +1,164 @@
+// Package changefeed reparte los cambios de documentos entre los clientes suscritos a
+// GET /collections/:collection/stream.
+//
+// Los eventos viven en memoria: el Hub guarda los últimos para que un cliente que se
+// reconecta con Last-Event-ID reciba lo que se perdió, pero solo ve las escrituras
+// hechas a través de esta instancia del servidor. No hay una fuente compartida entre
+// réplicas: el stream solo es completo con una única instancia.
+package changefeed
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipos de evento
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// subscriberBuffer es cuántos eventos puede tener pendientes un suscriptor. Si se llena,
+// el Hub lo desconecta en lugar de bloquear las escrituras; al reconectarse con
+// Last-Event-ID recupera lo perdido desde el buffer.
+const subscriberBuffer = 64
+
+// Event es un cambio ya aplicado en un documento. Data es el estado tras el cambio
+// (el estado eliminado en Deleted).
+type Event struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection"`
+	DocumentID string                 `json:"document_id"`
+	Subdomain  string                 `json:"subdomain,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Actor      string                 `json:"actor,omitempty"`
+	Time       time.Time              `json:"time"`
+
+	seq uint64
+}
+
+// Hub numera los eventos publicados, los reparte entre los suscriptores y guarda los
+// últimos size para reanudar. Es seguro para uso concurrente.
+type Hub struct {
+	mu     sync.Mutex
+	epoch  string // distingue los IDs de este proceso de los de uno anterior
+	seq    uint64
+	size   int
+	recent []Event
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// New crea un Hub que guarda los últimos size eventos.
+func New(size int) *Hub {
+	return &Hub{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  size,
+		subs:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription recibe los eventos publicados después de Subscribe. C se cierra cuando
+// el suscriptor se queda atrás o el Hub se cierra.
+type Subscription struct {
+	C <-chan Event
+
+	events chan Event
+	hub    *Hub
+}
+
+// Publish asigna un ID a e, lo reparte entre los suscriptores y lo devuelve.
+func (h *Hub) Publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.seq = h.seq
+	e.ID = h.epoch + "-" + strconv.FormatUint(h.seq, 10)
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	h.recent = append(h.recent, e)
+	if len(h.recent) > h.size {
+		h.recent = h.recent[len(h.recent)-h.size:]
+	}
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			h.drop(sub)
+		}
+	}
+	return e
+}
+
+// Subscribe registra un suscriptor. Si lastEventID no está vacío, backlog son los eventos
+// guardados posteriores a él; complete es false si no se puede garantizar que estén todos
+// (ID de otro proceso, desconocido o ya fuera del buffer) y el cliente debe recargar.
+func (h *Hub) Subscribe(lastEventID string) (sub *Subscription, backlog []Event, complete bool) {
+	events := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: events, events: events, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(events)
+		return sub, nil, true
+	}
+	h.subs[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	last, ok := h.parseID(lastEventID)
+	if !ok || last > h.seq {
+		return sub, nil, false
+	}
+	complete = last == h.seq || (len(h.recent) > 0 && h.recent[0].seq <= last+1)
+	for _, e := range h.recent {
+		if e.seq > last {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog, complete
+}
+
+// Close desconecta a todos los suscriptores (al apagar el servidor).
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub)
+	}
+}
+
+// Close da de baja la suscripción.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		s.hub.drop(s)
+	}
+}
+
+// drop elimina sub y cierra su canal; requiere h.mu.
+func (h *Hub) drop(sub *Subscription) {
+	delete(h.subs, sub)
+	close(sub.events)
+}
+
+// parseID devuelve el número de secuencia de id si lo generó este Hub.
+func (h *Hub) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,74 @@
+package changefeed
+
+import "testing"
+
+func TestSubscribeResumesFromLastEventID(t *testing.T) {
+	hub := New(3)
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, hub.Publish(Event{Type: Created, Collection: "orders", DocumentID: string(rune('a' + i))}).ID)
+	}
+
+	tests := []struct {
+		name     string
+		last     string
+		backlog  []string
+		complete bool
+	}{
+		{"no last event", "", nil, true},
+		{"up to date", ids[3], nil, true},
+		{"in buffer", ids[1], []string{"c", "d"}, true},
+		{"oldest kept is next", ids[0], []string{"b", "c", "d"}, true},
+		{"unknown id", "other-1", nil, false},
+		{"future id", hub.epoch + "-99", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, backlog, complete := hub.Subscribe(tt.last)
+			defer sub.Close()
+			var got []string
+			for _, e := range backlog {
+				got = append(got, e.DocumentID)
+			}
+			if complete != tt.complete || len(got) != len(tt.backlog) {
+				t.Fatalf("backlog = %v, complete = %v; want %v, %v", got, complete, tt.backlog, tt.complete)
+			}
+			for i := range got {
+				if got[i] != tt.backlog[i] {
+					t.Fatalf("backlog = %v, want %v", got, tt.backlog)
+				}
+			}
+		})
+	}
+
+	// Publicado un evento más, ids[0] ya tiene un hueco: faltaría "b"
+	hub.Publish(Event{Type: Deleted, Collection: "orders", DocumentID: "a"})
+	sub, _, complete := hub.Subscribe(ids[0])
+	sub.Close()
+	if complete {
+		t.Fatal("complete = true, want false after the buffer dropped the next event")
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	hub := New(10)
+	sub, _, _ := hub.Subscribe("")
+	for i := 0; i < subscriberBuffer+1; i++ {
+		hub.Publish(Event{Type: Updated, Collection: "orders", DocumentID: "a"})
+	}
+
+	received := 0
+	for range sub.C {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Fatalf("received %d events before the channel closed, want %d", received, subscriberBuffer)
+	}
+	sub.Close() // ya estaba fuera: no debe cerrar el canal dos veces
+
+	live, _, _ := hub.Subscribe("")
+	hub.Close()
+	if _, ok := <-live.C; ok {
+		t.Fatal("Close must close the subscriptions")
+	}
+}
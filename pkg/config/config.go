@@ -0,0 +1,651 @@
+// Package config carga y valida la configuración del servidor.
+//
+// Los valores se resuelven en este orden: valores por defecto, el archivo
+// indicado en CONFIG_FILE (YAML o JSON) y, por último, variables de entorno.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config es la configuración completa del servidor.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Pagination  PaginationConfig  `yaml:"pagination"`
+	Trash       TrashConfig       `yaml:"trash"`
+	Batch       BatchConfig       `yaml:"batch"`
+	Stream      StreamConfig      `yaml:"stream"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks"`
+	Reconcile   ReconcileConfig   `yaml:"reconcile"`
+	Privacy     PrivacyConfig     `yaml:"privacy"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Collections CollectionsConfig `yaml:"collections"`
+	Features    FeaturesConfig    `yaml:"features"`
+}
+
+// ServerConfig agrupa la dirección de escucha, TLS y timeouts HTTP.
+type ServerConfig struct {
+	Addr         string        `yaml:"addr"`
+	GinMode      string        `yaml:"gin_mode"`
+	TLSCertFile  string        `yaml:"tls_cert_file"`
+	TLSKeyFile   string        `yaml:"tls_key_file"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout es el plazo para drenar peticiones en curso al apagar el servidor.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// RequestTimeout es el deadline por defecto de cada petición a /api/v1 (0 lo desactiva).
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// RouteTimeouts sobreescribe RequestTimeout por ruta, con claves "MÉTODO /api/v1/ruta/:param".
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts"`
+}
+
+// TLSEnabled indica si el servidor debe escuchar con TLS.
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+// CORSConfig define los orígenes, métodos y cabeceras permitidos.
+// Un origen "*" permite cualquier origen.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+}
+
+// AuthConfig contiene las API Keys aceptadas en X-API-KEY.
+type AuthConfig struct {
+	APIKeys []string `yaml:"api_keys"`
+	// CredentialsCollection y SessionsCollection son las colecciones donde la librería de
+	// auth guarda las credenciales y las sesiones, y SessionUIDField el campo de la sesión
+	// con el UID del usuario.
+	CredentialsCollection string `yaml:"credentials_collection"`
+	SessionsCollection    string `yaml:"sessions_collection"`
+	SessionUIDField       string `yaml:"session_uid_field"`
+}
+
+// PaginationConfig limita el tamaño de página de los listados.
+type PaginationConfig struct {
+	DefaultPageSize int `yaml:"default_page_size"`
+	MaxPageSize     int `yaml:"max_page_size"`
+	// PageTokenSecret firma los next_page_token. Es obligatorio y debe ser el mismo en
+	// todas las réplicas: con otra clave la página siguiente no se puede pedir a otra réplica.
+	PageTokenSecret string `yaml:"page_token_secret"`
+}
+
+// TrashConfig controla cuánto tiempo se conservan los documentos eliminados.
+type TrashConfig struct {
+	// Retention es el tiempo que un documento pasa en la papelera antes de purgarse.
+	Retention time.Duration `yaml:"retention"`
+	// PurgeInterval es cada cuánto se ejecuta el purgador (0 lo desactiva).
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// BatchConfig limita POST /collections/batch.
+type BatchConfig struct {
+	// MaxOperations es el número máximo de operaciones por lote.
+	MaxOperations int `yaml:"max_operations"`
+}
+
+// StreamConfig controla GET /collections/:collection/stream. Los cambios se reparten en
+// memoria, así que el stream solo ve las escrituras de esta instancia: con varias réplicas
+// un cliente pierde las que atienden las demás.
+type StreamConfig struct {
+	// BufferSize es cuántos cambios recientes se guardan para reanudar con Last-Event-ID.
+	BufferSize int `yaml:"buffer_size"`
+	// Heartbeat es cada cuánto se envía un comentario para que proxies y clientes no
+	// cierren la conexión por inactividad.
+	Heartbeat time.Duration `yaml:"heartbeat"`
+}
+
+// WebhooksConfig controla la entrega de webhooks salientes.
+type WebhooksConfig struct {
+	// MaxAttempts es cuántas veces se intenta una entrega antes de dejarla como "dead".
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialBackoff es la espera tras el primer fallo; se duplica en cada intento hasta MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// PollInterval es cada cuánto se buscan entregas pendientes (0 desactiva el repartidor).
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Timeout limita cada petición a un endpoint.
+	Timeout time.Duration `yaml:"timeout"`
+	// AllowInsecureURLs acepta endpoints http y en direcciones privadas o de loopback.
+	// Solo para desarrollo y tests.
+	AllowInsecureURLs bool `yaml:"allow_insecure_urls"`
+}
+
+// ReconcileConfig controla la reconciliación de cuentas de usuario a medias.
+type ReconcileConfig struct {
+	// Interval es cada cuánto se ejecuta la reconciliación (0 la desactiva).
+	Interval time.Duration `yaml:"interval"`
+	// GracePeriod excluye los usuarios creados hace menos de este tiempo, cuya alta puede
+	// seguir en curso.
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Repair hace que la ejecución periódica repare lo que encuentra en vez de solo informar.
+	Repair bool `yaml:"repair"`
+}
+
+// PrivacyConfig controla la exportación y el borrado de los datos de un usuario.
+type PrivacyConfig struct {
+	// ReceiptSecret firma los recibos de borrado y genera los seudónimos. Es obligatorio y
+	// debe ser el mismo en todas las réplicas: con otra clave los recibos no se verifican y
+	// el mismo usuario tendría otro seudónimo.
+	ReceiptSecret string `yaml:"receipt_secret"`
+}
+
+// AuditConfig controla el registro de auditoría.
+type AuditConfig struct {
+	// HMACSecret firma la cadena de hashes del registro. Es obligatorio y debe ser el mismo
+	// en todas las réplicas y entre reinicios: con otra clave la cadena deja de verificarse.
+	HMACSecret string `yaml:"hmac_secret"`
+	// FailedLoginLimit es cuántos logins fallidos por minuto se registran desde una misma
+	// IP; los demás solo se cuentan en el siguiente evento que se registre.
+	FailedLoginLimit int `yaml:"failed_login_limit"`
+}
+
+// StreamRoute es la ruta del stream de cambios; por defecto no tiene deadline.
+const StreamRoute = "GET /api/v1/collections/:collection/stream"
+
+// InternalCollections son las colecciones que usa el propio servidor (perfiles, claims,
+// credenciales de la librería de auth, historial, papelera, esquemas, auditoría y webhooks). Nunca se exponen
+// por /collections/:collection, aunque figuren en el registro.
+var InternalCollections = []string{
+	"profiles",
+	"user_claims",
+	"credentials",
+	"user_credentials",
+	"sessions",
+	"user_sessions",
+	"document_revisions",
+	"document_trash",
+	"collection_schemas",
+	"audit_events",
+	"webhook_endpoints",
+	"webhook_deliveries",
+}
+
+// Ámbitos de una colección
+const (
+	// ScopeTenant aísla los documentos por subdominio (comportamiento por defecto).
+	ScopeTenant = "tenant"
+	// ScopeGlobal comparte los documentos entre todos los subdominios; solo los admins escriben.
+	ScopeGlobal = "global"
+)
+
+// CollectionsConfig es el registro de colecciones expuestas por la API.
+type CollectionsConfig struct {
+	// Registry lista las colecciones expuestas y su configuración. Si está vacío se exponen
+	// todas las colecciones no internas con los valores por defecto (comportamiento anterior).
+	Registry map[string]CollectionSettings `yaml:"registry"`
+	// Reserved añade nombres a InternalCollections (p. ej. otra colección de la librería de auth).
+	Reserved []string `yaml:"reserved"`
+}
+
+// CollectionSettings es la configuración de una colección del registro.
+type CollectionSettings struct {
+	Enabled  bool   `yaml:"enabled"`
+	Scope    string `yaml:"scope"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+// defaultCollectionSettings son los valores de una colección registrada sin más detalles.
+func defaultCollectionSettings() CollectionSettings {
+	return CollectionSettings{Enabled: true, Scope: ScopeTenant}
+}
+
+// UnmarshalYAML aplica los valores por defecto a los campos que el archivo no define.
+func (s *CollectionSettings) UnmarshalYAML(value *yaml.Node) error {
+	type plain CollectionSettings
+	settings := plain(defaultCollectionSettings())
+	if err := value.Decode(&settings); err != nil {
+		return err
+	}
+	*s = CollectionSettings(settings)
+	return nil
+}
+
+// IsReserved indica si name es una colección interna.
+func (c CollectionsConfig) IsReserved(name string) bool {
+	for _, reserved := range InternalCollections {
+		if name == reserved {
+			return true
+		}
+	}
+	for _, reserved := range c.Reserved {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup devuelve la configuración de la colección name y si está expuesta. Solo acepta
+// colecciones de primer nivel: una ruta con "/" (orders/O1/items) debe pasar por el acceso
+// de subcolecciones, que comprueba el documento raíz.
+func (c CollectionsConfig) Lookup(name string) (CollectionSettings, bool) {
+	if name == "" || strings.Contains(name, "/") || c.IsReserved(name) {
+		return CollectionSettings{}, false
+	}
+	if len(c.Registry) == 0 {
+		return defaultCollectionSettings(), true
+	}
+	settings, ok := c.Registry[name]
+	if !ok || !settings.Enabled {
+		return CollectionSettings{}, false
+	}
+	return settings, true
+}
+
+// FeaturesConfig activa o desactiva endpoints opcionales.
+type FeaturesConfig struct {
+	Docs  bool `yaml:"docs"`
+	Stats bool `yaml:"stats"`
+}
+
+// Default devuelve la configuración por defecto (sin API Keys).
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:         ":8080",
+			GinMode:      "debug",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  60 * time.Second,
+
+			ShutdownTimeout: 30 * time.Second,
+			RequestTimeout:  20 * time.Second,
+			RouteTimeouts:   map[string]time.Duration{StreamRoute: 0},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-KEY", "X-Session-ID", "X-Client-Subdomain", "If-Match", "If-None-Match", "X-Request-ID", "Last-Event-ID"},
+		},
+		Auth: AuthConfig{
+			CredentialsCollection: "user_credentials",
+			SessionsCollection:    "user_sessions",
+			SessionUIDField:       "uid",
+		},
+		Pagination: PaginationConfig{
+			DefaultPageSize: 10,
+			MaxPageSize:     100,
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Batch: BatchConfig{
+			MaxOperations: 50,
+		},
+		Stream: StreamConfig{
+			BufferSize: 1000,
+			Heartbeat:  15 * time.Second,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    8,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     6 * time.Hour,
+			PollInterval:   5 * time.Second,
+			Timeout:        10 * time.Second,
+		},
+		Reconcile: ReconcileConfig{
+			GracePeriod: time.Hour,
+		},
+		Audit: AuditConfig{
+			FailedLoginLimit: 10,
+		},
+		Features: FeaturesConfig{
+			Docs:  true,
+			Stats: true,
+		},
+	}
+}
+
+// Load construye la configuración a partir de CONFIG_FILE y del entorno, y la valida.
+func Load() (*Config, error) {
+	return load(os.LookupEnv)
+}
+
+func load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path, ok := lookupEnv("CONFIG_FILE"); ok && path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile sobreescribe cfg con el contenido del archivo. YAML es un superconjunto
+// de JSON, así que el mismo decodificador sirve para .yaml, .yml y .json.
+func (cfg *Config) loadFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return fmt.Errorf("config file %s: unsupported extension (use .yaml, .yml or .json)", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sobreescribe cfg con las variables de entorno definidas.
+func (cfg *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	env := func(name string) (string, bool) {
+		value, ok := lookupEnv(name)
+		value = strings.TrimSpace(value)
+		return value, ok && value != ""
+	}
+
+	if v, ok := env("PORT"); ok {
+		cfg.Server.Addr = ":" + v
+	}
+	if v, ok := env("LISTEN_ADDR"); ok {
+		cfg.Server.Addr = v
+	}
+	if v, ok := env("GIN_MODE"); ok {
+		cfg.Server.GinMode = v
+	}
+	if v, ok := env("TLS_CERT_FILE"); ok {
+		cfg.Server.TLSCertFile = v
+	}
+	if v, ok := env("TLS_KEY_FILE"); ok {
+		cfg.Server.TLSKeyFile = v
+	}
+
+	durations := map[string]*time.Duration{
+		"READ_TIMEOUT":  &cfg.Server.ReadTimeout,
+		"WRITE_TIMEOUT": &cfg.Server.WriteTimeout,
+		"IDLE_TIMEOUT":  &cfg.Server.IdleTimeout,
+
+		"SHUTDOWN_TIMEOUT": &cfg.Server.ShutdownTimeout,
+		"REQUEST_TIMEOUT":  &cfg.Server.RequestTimeout,
+
+		"TRASH_RETENTION":      &cfg.Trash.Retention,
+		"TRASH_PURGE_INTERVAL": &cfg.Trash.PurgeInterval,
+
+		"STREAM_HEARTBEAT": &cfg.Stream.Heartbeat,
+
+		"WEBHOOK_INITIAL_BACKOFF": &cfg.Webhooks.InitialBackoff,
+		"WEBHOOK_MAX_BACKOFF":     &cfg.Webhooks.MaxBackoff,
+		"WEBHOOK_POLL_INTERVAL":   &cfg.Webhooks.PollInterval,
+		"WEBHOOK_TIMEOUT":         &cfg.Webhooks.Timeout,
+
+		"RECONCILE_INTERVAL":     &cfg.Reconcile.Interval,
+		"RECONCILE_GRACE_PERIOD": &cfg.Reconcile.GracePeriod,
+	}
+	for name, target := range durations {
+		if v, ok := env(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: invalid duration %q", name, v)
+			}
+			*target = d
+		}
+	}
+
+	if v, ok := env("AUTH_CREDENTIALS_COLLECTION"); ok {
+		cfg.Auth.CredentialsCollection = v
+	}
+	if v, ok := env("AUTH_SESSIONS_COLLECTION"); ok {
+		cfg.Auth.SessionsCollection = v
+	}
+	if v, ok := env("AUTH_SESSION_UID_FIELD"); ok {
+		cfg.Auth.SessionUIDField = v
+	}
+	if v, ok := env("PAGE_TOKEN_SECRET"); ok {
+		cfg.Pagination.PageTokenSecret = v
+	}
+	if v, ok := env("PRIVACY_RECEIPT_SECRET"); ok {
+		cfg.Privacy.ReceiptSecret = v
+	}
+	if v, ok := env("AUDIT_HMAC_SECRET"); ok {
+		cfg.Audit.HMACSecret = v
+	}
+	// COLLECTIONS registra colecciones con la configuración por defecto (tenant, lectura y escritura)
+	if v, ok := env("COLLECTIONS"); ok {
+		if cfg.Collections.Registry == nil {
+			cfg.Collections.Registry = make(map[string]CollectionSettings)
+		}
+		for _, name := range splitList(v) {
+			if _, exists := cfg.Collections.Registry[name]; !exists {
+				cfg.Collections.Registry[name] = defaultCollectionSettings()
+			}
+		}
+	}
+	if v, ok := env("RESERVED_COLLECTIONS"); ok {
+		cfg.Collections.Reserved = append(cfg.Collections.Reserved, splitList(v)...)
+	}
+	if v, ok := env("CORS_ALLOWED_ORIGINS"); ok {
+		cfg.CORS.AllowedOrigins = splitList(v)
+	}
+	if v, ok := env("CORS_ALLOWED_HEADERS"); ok {
+		cfg.CORS.AllowedHeaders = splitList(v)
+	}
+
+	// API_KEY se mantiene por compatibilidad; API_KEYS permite rotar claves.
+	if v, ok := env("API_KEYS"); ok {
+		cfg.Auth.APIKeys = splitList(v)
+	}
+	if v, ok := env("API_KEY"); ok {
+		cfg.Auth.APIKeys = append(cfg.Auth.APIKeys, v)
+	}
+
+	ints := map[string]*int{
+		"DEFAULT_PAGE_SIZE": &cfg.Pagination.DefaultPageSize,
+		"MAX_PAGE_SIZE":     &cfg.Pagination.MaxPageSize,
+
+		"BATCH_MAX_OPERATIONS": &cfg.Batch.MaxOperations,
+		"STREAM_BUFFER_SIZE":   &cfg.Stream.BufferSize,
+		"WEBHOOK_MAX_ATTEMPTS": &cfg.Webhooks.MaxAttempts,
+
+		"AUDIT_FAILED_LOGIN_LIMIT": &cfg.Audit.FailedLoginLimit,
+	}
+	for name, target := range ints {
+		if v, ok := env(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: invalid integer %q", name, v)
+			}
+			*target = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"ENABLE_DOCS":  &cfg.Features.Docs,
+		"ENABLE_STATS": &cfg.Features.Stats,
+
+		"WEBHOOK_ALLOW_INSECURE_URLS": &cfg.Webhooks.AllowInsecureURLs,
+		"RECONCILE_REPAIR":            &cfg.Reconcile.Repair,
+	}
+	for name, target := range bools {
+		if v, ok := env(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: invalid boolean %q", name, v)
+			}
+			*target = b
+		}
+	}
+	return nil
+}
+
+// Validate comprueba que la configuración sea coherente y devuelve todos los errores encontrados.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must not be empty"))
+	}
+	switch cfg.Server.GinMode {
+	case "debug", "release", "test":
+	default:
+		errs = append(errs, fmt.Errorf("server.gin_mode must be debug, release or test, got %q", cfg.Server.GinMode))
+	}
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		errs = append(errs, errors.New("server.tls_cert_file and server.tls_key_file must be set together"))
+	}
+	for _, file := range []string{cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("tls file: %w", err))
+		}
+	}
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", cfg.Server.ReadTimeout},
+		{"server.write_timeout", cfg.Server.WriteTimeout},
+		{"server.idle_timeout", cfg.Server.IdleTimeout},
+		{"server.shutdown_timeout", cfg.Server.ShutdownTimeout},
+		{"server.request_timeout", cfg.Server.RequestTimeout},
+		{"trash.purge_interval", cfg.Trash.PurgeInterval},
+		{"webhooks.poll_interval", cfg.Webhooks.PollInterval},
+		{"reconcile.interval", cfg.Reconcile.Interval},
+		{"reconcile.grace_period", cfg.Reconcile.GracePeriod},
+	}
+	for _, t := range timeouts {
+		if t.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", t.name))
+		}
+	}
+	for route, d := range cfg.Server.RouteTimeouts {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok || method == "" || !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("server.route_timeouts: key %q must look like \"GET /api/v1/path\"", route))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("server.route_timeouts[%q] must not be negative", route))
+		}
+	}
+
+	if len(cfg.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowed_origins must list at least one origin (use \"*\" to allow any)"))
+	}
+
+	if len(cfg.Auth.APIKeys) == 0 {
+		errs = append(errs, errors.New("auth.api_keys is empty: set API_KEY or API_KEYS"))
+	}
+	for _, key := range cfg.Auth.APIKeys {
+		if key == "" {
+			errs = append(errs, errors.New("auth.api_keys must not contain empty keys"))
+			break
+		}
+	}
+
+	if cfg.Audit.HMACSecret == "" {
+		errs = append(errs, errors.New("audit.hmac_secret is empty: set AUDIT_HMAC_SECRET"))
+	}
+	if cfg.Audit.FailedLoginLimit <= 0 {
+		errs = append(errs, errors.New("audit.failed_login_limit must be positive"))
+	}
+	if cfg.Privacy.ReceiptSecret == "" {
+		errs = append(errs, errors.New("privacy.receipt_secret is empty: set PRIVACY_RECEIPT_SECRET"))
+	}
+	if cfg.Pagination.PageTokenSecret == "" {
+		errs = append(errs, errors.New("pagination.page_token_secret is empty: set PAGE_TOKEN_SECRET"))
+	}
+
+	// Las credenciales y sesiones nunca deben quedar expuestas por /collections
+	authCollections := []struct {
+		name  string
+		value string
+	}{
+		{"auth.credentials_collection", cfg.Auth.CredentialsCollection},
+		{"auth.sessions_collection", cfg.Auth.SessionsCollection},
+	}
+	for _, collection := range authCollections {
+		if collection.value == "" {
+			errs = append(errs, fmt.Errorf("%s must not be empty", collection.name))
+		} else if !cfg.Collections.IsReserved(collection.value) {
+			errs = append(errs, fmt.Errorf("%s %q must be an internal collection (add it to collections.reserved)", collection.name, collection.value))
+		}
+	}
+
+	if cfg.Auth.SessionUIDField == "" {
+		errs = append(errs, errors.New("auth.session_uid_field must not be empty"))
+	}
+
+	if cfg.Pagination.MaxPageSize <= 0 {
+		errs = append(errs, errors.New("pagination.max_page_size must be positive"))
+	}
+	if cfg.Pagination.DefaultPageSize <= 0 || cfg.Pagination.DefaultPageSize > cfg.Pagination.MaxPageSize {
+		errs = append(errs, errors.New("pagination.default_page_size must be between 1 and pagination.max_page_size"))
+	}
+
+	for name, settings := range cfg.Collections.Registry {
+		if cfg.Collections.IsReserved(name) {
+			errs = append(errs, fmt.Errorf("collections.registry: %q is an internal collection and cannot be exposed", name))
+		}
+		if settings.Scope != ScopeTenant && settings.Scope != ScopeGlobal {
+			errs = append(errs, fmt.Errorf("collections.registry[%q].scope must be %s or %s, got %q", name, ScopeTenant, ScopeGlobal, settings.Scope))
+		}
+	}
+
+	if cfg.Trash.Retention <= 0 {
+		errs = append(errs, errors.New("trash.retention must be positive"))
+	}
+	if cfg.Batch.MaxOperations <= 0 {
+		errs = append(errs, errors.New("batch.max_operations must be positive"))
+	}
+	if cfg.Stream.BufferSize <= 0 {
+		errs = append(errs, errors.New("stream.buffer_size must be positive"))
+	}
+	if cfg.Stream.Heartbeat <= 0 {
+		errs = append(errs, errors.New("stream.heartbeat must be positive"))
+	}
+	if cfg.Webhooks.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhooks.max_attempts must be positive"))
+	}
+	if cfg.Webhooks.InitialBackoff <= 0 || cfg.Webhooks.InitialBackoff > cfg.Webhooks.MaxBackoff {
+		errs = append(errs, errors.New("webhooks.initial_backoff must be positive and not above webhooks.max_backoff"))
+	}
+	if cfg.Webhooks.Timeout <= 0 {
+		errs = append(errs, errors.New("webhooks.timeout must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envFrom(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	cfg, err := load(envFrom(map[string]string{
+		"PORT":                   "9090",
+		"GIN_MODE":               "release",
+		"API_KEY":                "k1",
+		"AUDIT_HMAC_SECRET":      "audit",
+		"PRIVACY_RECEIPT_SECRET": "privacy",
+		"PAGE_TOKEN_SECRET":      "pages",
+		"API_KEYS":               "k2, k3",
+		"READ_TIMEOUT":           "5s",
+		"CORS_ALLOWED_ORIGINS":   "https://a.example.com,https://b.example.com",
+		"MAX_PAGE_SIZE":          "50",
+		"ENABLE_DOCS":            "false",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":9090" || cfg.Server.GinMode != "release" {
+		t.Errorf("server = %+v", cfg.Server)
+	}
+	if got := strings.Join(cfg.Auth.APIKeys, ","); got != "k2,k3,k1" {
+		t.Errorf("api keys = %s", got)
+	}
+	if cfg.Server.ReadTimeout != 5*time.Second {
+		t.Errorf("read timeout = %s", cfg.Server.ReadTimeout)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.Pagination.MaxPageSize != 50 || cfg.Features.Docs {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
+
+func TestLoadFileThenEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+server:
+  addr: ":7000"
+  write_timeout: 1m
+auth:
+  api_keys: ["from-file"]
+audit:
+  hmac_secret: "audit"
+privacy:
+  receipt_secret: "privacy"
+pagination:
+  default_page_size: 20
+  page_token_secret: "pages"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load(envFrom(map[string]string{"CONFIG_FILE": path, "PORT": "7001"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":7001" {
+		t.Errorf("addr = %s, env must override file", cfg.Server.Addr)
+	}
+	if cfg.Server.WriteTimeout != time.Minute || cfg.Pagination.DefaultPageSize != 20 {
+		t.Errorf("cfg = %+v", cfg)
+	}
+	if len(cfg.Auth.APIKeys) != 1 || cfg.Auth.APIKeys[0] != "from-file" {
+		t.Errorf("api keys = %v", cfg.Auth.APIKeys)
+	}
+}
+
+func TestCollectionsRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+auth:
+  api_keys: ["k"]
+audit:
+  hmac_secret: "audit"
+privacy:
+  receipt_secret: "privacy"
+pagination:
+  page_token_secret: "pages"
+collections:
+  reserved: ["auth_tokens"]
+  registry:
+    orders: {}
+    catalog: {scope: global, read_only: true}
+    legacy: {enabled: false}
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := load(envFrom(map[string]string{"CONFIG_FILE": path, "COLLECTIONS": "invoices"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want CollectionSettings
+		ok   bool
+	}{
+		{"orders", CollectionSettings{Enabled: true, Scope: ScopeTenant}, true},
+		{"catalog", CollectionSettings{Enabled: true, Scope: ScopeGlobal, ReadOnly: true}, true},
+		{"invoices", CollectionSettings{Enabled: true, Scope: ScopeTenant}, true},
+		{"legacy", CollectionSettings{}, false},
+		{"unlisted", CollectionSettings{}, false},
+		{"auth_tokens", CollectionSettings{}, false},
+		{"orders/O1/items", CollectionSettings{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.Collections.Lookup(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%q) = %+v, %v; want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.json")
+	if err := os.WriteFile(unknown, []byte(`{"server": {"port": 1}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"missing api key", map[string]string{}, "auth.api_keys is empty"},
+		{"missing audit secret", map[string]string{"API_KEY": "k"}, "audit.hmac_secret is empty"},
+		{"missing receipt secret", map[string]string{"API_KEY": "k", "AUDIT_HMAC_SECRET": "audit"}, "privacy.receipt_secret is empty"},
+		{"missing page token secret", map[string]string{"API_KEY": "k", "AUDIT_HMAC_SECRET": "audit", "PRIVACY_RECEIPT_SECRET": "privacy"}, "pagination.page_token_secret is empty"},
+		{"bad duration", map[string]string{"API_KEY": "k", "IDLE_TIMEOUT": "soon"}, "IDLE_TIMEOUT"},
+		{"bad gin mode", map[string]string{"API_KEY": "k", "GIN_MODE": "prod"}, "gin_mode"},
+		{"tls half configured", map[string]string{"API_KEY": "k", "TLS_CERT_FILE": "cert.pem"}, "must be set together"},
+		{"page size above max", map[string]string{"API_KEY": "k", "DEFAULT_PAGE_SIZE": "500"}, "default_page_size"},
+		{"internal collection exposed", map[string]string{"API_KEY": "k", "COLLECTIONS": "orders,profiles"}, "internal collection"},
+		{"zero trash retention", map[string]string{"API_KEY": "k", "TRASH_RETENTION": "0s"}, "trash.retention"},
+		{"zero batch size", map[string]string{"API_KEY": "k", "BATCH_MAX_OPERATIONS": "0"}, "batch.max_operations"},
+		{"zero failed login limit", map[string]string{"API_KEY": "k", "AUDIT_FAILED_LOGIN_LIMIT": "0"}, "audit.failed_login_limit"},
+		{"zero stream heartbeat", map[string]string{"API_KEY": "k", "STREAM_HEARTBEAT": "0s"}, "stream.heartbeat"},
+		{"webhook backoff above max", map[string]string{"API_KEY": "k", "WEBHOOK_INITIAL_BACKOFF": "12h"}, "webhooks.initial_backoff"},
+		{"exposable credentials collection", map[string]string{"API_KEY": "k", "AUTH_CREDENTIALS_COLLECTION": "passwords"}, "auth.credentials_collection"},
+		{"exposable sessions collection", map[string]string{"API_KEY": "k", "AUTH_SESSIONS_COLLECTION": "logins"}, "auth.sessions_collection"},
+		{"unknown file field", map[string]string{"API_KEY": "k", "CONFIG_FILE": unknown}, "port"},
+		{"unsupported file", map[string]string{"API_KEY": "k", "CONFIG_FILE": "config.toml"}, "unsupported extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(envFrom(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/gin-gonic/gin"
+)
+
+// isAdmin indica si la sesión tiene el claim role=admin.
+func isAdmin(c *gin.Context) bool {
+	claims, _ := c.Get("claims")
+	claimsMap, _ := claims.(map[string]interface{})
+	role, _ := claimsMap["role"].(string)
+	return role == "admin"
+}
+
+// canAccessSubdomain aplica la misma regla que los handlers de documentos: los datos sin
+// subdominio o del subdominio de la sesión son accesibles, y los admins acceden a todo.
+// En las colecciones globales todo es accesible.
+func canAccessSubdomain(c *gin.Context, data map[string]interface{}) bool {
+	userSubdomain, exists := c.Get("subdomain")
+	if !exists || !tenantScoped(c) {
+		return true
+	}
+	docSubdomain, hasSubdomain := data["subdomain"].(string)
+	return !hasSubdomain || docSubdomain == userSubdomain.(string) || isAdmin(c)
+}
+
+// tenantScoped indica si la colección de la ruta aísla los documentos por subdominio
+// (lo fija CollectionAccessMiddleware; por defecto sí).
+func tenantScoped(c *gin.Context) bool {
+	value, exists := c.Get("collection_settings")
+	settings, _ := value.(config.CollectionSettings)
+	return !exists || settings.Scope != config.ScopeGlobal
+}
+
+// requestSubdomain es el subdominio de la sesión o, en las rutas que solo piden API Key,
+// el de X-Client-Subdomain.
+func requestSubdomain(c *gin.Context) string {
+	if subdomain := c.GetString("subdomain"); subdomain != "" {
+		return subdomain
+	}
+	return c.GetHeader("X-Client-Subdomain")
+}
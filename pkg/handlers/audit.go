@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// auditFields son los campos de auditoría que gestiona el servidor en cada escritura.
+// Los valores que envíe el cliente se ignoran.
+var auditFields = []string{"created_at", "created_by", "updated_at", "updated_by"}
+
+// auditTimeFields son los campos de auditoría que se guardan como timestamp.
+var auditTimeFields = map[string]bool{"created_at": true, "updated_at": true}
+
+// stripAuditFields elimina de data los campos de auditoría enviados por el cliente.
+func stripAuditFields(data map[string]interface{}) {
+	for _, field := range auditFields {
+		delete(data, field)
+	}
+}
+
+// stampCreated marca data como creado (y modificado) ahora por el usuario de la sesión.
+func stampCreated(c *gin.Context, data map[string]interface{}, now time.Time) {
+	data["created_at"] = now
+	data["created_by"] = c.GetString("uid")
+	stampUpdated(c, data, now)
+}
+
+// stampUpdated marca data como modificado ahora por el usuario de la sesión.
+func stampUpdated(c *gin.Context, data map[string]interface{}, now time.Time) {
+	data["updated_at"] = now
+	data["updated_by"] = c.GetString("uid")
+}
+
+// keepCreated copia created_at y created_by de previous a data, para las escrituras que
+// reemplazan el documento completo.
+func keepCreated(data, previous map[string]interface{}) {
+	for _, field := range []string{"created_at", "created_by"} {
+		if value, ok := previous[field]; ok {
+			data[field] = value
+		} else {
+			delete(data, field)
+		}
+	}
+}
+
+// normalizeAuditFilters convierte a time.Time los valores RFC 3339 de los filtros sobre
+// created_at y updated_at, que en el backend son timestamps y no strings.
+func normalizeAuditFilters(filters []firebase.QueryFilter) error {
+	for i, filter := range filters {
+		if !auditTimeFields[filter.Field] {
+			continue
+		}
+		value, err := toTimestamp(filter.Value)
+		if err != nil {
+			return fmt.Errorf("filter on %s: %w", filter.Field, err)
+		}
+		filters[i].Value = value
+	}
+	return nil
+}
+
+func toTimestamp(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an RFC 3339 timestamp", v)
+		}
+		return t, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			t, err := toTimestamp(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = t
+		}
+		return out, nil
+	default:
+		return value, nil
+	}
+}
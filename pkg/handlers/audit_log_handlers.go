@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/auditlog"
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// Acciones del registro de auditoría
+const (
+	auditLogin           = "auth.login"
+	auditLoginFailed     = "auth.login_failed"
+	auditLogout          = "auth.logout"
+	auditClaimsSet       = "user.claims.set"
+	auditClaimsUpdate    = "user.claims.update"
+	auditUserDelete      = "user.delete"
+	auditUserReconcile   = "user.reconcile"
+	auditUserExport      = "user.export"
+	auditUserErase       = "user.erase"
+	auditCrossTenantRead = "document.cross_tenant_read"
+	// Un admin que abre el stream de una colección por tenant recibe cambios de todos
+	auditCrossTenantStream = "document.cross_tenant_stream"
+)
+
+// recordAudit añade un evento al registro de auditoría con el actor, subdominio, IP y
+// request ID de la petición. La acción ya se hizo, así que un fallo solo se registra en el log.
+func (h *Handler) recordAudit(c *gin.Context, action, target string, before, after map[string]interface{}) {
+	_, err := h.audit.Record(c.Request.Context(), h.auditEvent(c, action, target, before, after))
+	if err != nil {
+		log.Printf("⚠️ No se pudo registrar el evento de auditoría %s sobre %s: %v", action, target, err)
+	}
+}
+
+// auditEvent construye el evento de action sobre target con los datos de la petición.
+func (h *Handler) auditEvent(c *gin.Context, action, target string, before, after map[string]interface{}) auditlog.Event {
+	return auditlog.Event{
+		Actor:     c.GetString("uid"),
+		Action:    action,
+		Target:    target,
+		Subdomain: requestSubdomain(c),
+		IP:        c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+		Before:    before,
+		After:     after,
+	}
+}
+
+// auditCrossTenantRead registra las lecturas de un admin que devuelven documentos de
+// subdominios distintos al de su sesión. Los documentos sin subdominio no cuentan.
+func (h *Handler) auditCrossTenantRead(c *gin.Context, collection string, docs []*firebase.Document) {
+	if !isAdmin(c) || !tenantScoped(c) {
+		return
+	}
+	own := c.GetString("subdomain")
+	seen := map[string]bool{}
+	var ids []interface{}
+	for _, doc := range docs {
+		subdomain, ok := doc.Data["subdomain"].(string)
+		if !ok || subdomain == own {
+			continue
+		}
+		seen[subdomain] = true
+		ids = append(ids, doc.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	subdomains := make([]string, 0, len(seen))
+	for subdomain := range seen {
+		subdomains = append(subdomains, subdomain)
+	}
+	sort.Strings(subdomains)
+	others := make([]interface{}, len(subdomains))
+	for i, subdomain := range subdomains {
+		others[i] = subdomain
+	}
+
+	target := collection
+	if len(docs) == 1 && c.Param("id") != "" {
+		target = collection + "/" + docs[0].ID
+	}
+	h.recordAudit(c, auditCrossTenantRead, target, nil, map[string]interface{}{
+		"route":        c.FullPath(),
+		"subdomains":   others,
+		"document_ids": ids,
+	})
+}
+
+// ListAuditEvents consulta el registro de auditoría (solo admins), del evento más
+// reciente al más antiguo. Filtros: ?actor=, ?target=, ?action=, ?from= y ?to= (RFC 3339),
+// paginado con ?limit= y ?page_token=
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	options := firebase.QueryOptions{
+		OrderBy: []firebase.OrderBy{{Field: "created_at", Direction: "desc"}},
+	}
+	for _, field := range []string{"actor", "target", "action"} {
+		if value := c.Query(field); value != "" {
+			options.Filters = append(options.Filters, firebase.QueryFilter{Field: field, Operator: "==", Value: value})
+		}
+	}
+	for _, bound := range []struct{ param, operator string }{{"from", ">="}, {"to", "<"}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid " + bound.param + " parameter",
+				"details": "Se espera una fecha RFC 3339, p. ej. 2025-07-01T00:00:00Z",
+			})
+			return
+		}
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "created_at", Operator: bound.operator, Value: t.UTC()})
+	}
+
+	limit := h.pageSize(c)
+	after, err := h.decodePageToken(c.Query("page_token"), auditlog.Collection, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	query := options
+	query.Limit = limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(c.Request.Context(), auditlog.Collection, query, after)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to query audit events",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	docs, nextToken, err := h.paginate(auditlog.Collection, options, docs, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	events := make([]auditlog.Event, len(docs))
+	for i, doc := range docs {
+		events[i] = auditlog.FromDocument(doc)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"events":          events,
+		"count":           len(events),
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
+	})
+}
+
+// VerifyAuditLog recorre la cadena de hashes del registro de auditoría (solo admins) e
+// indica el primer evento alterado, si lo hay.
+func (h *Handler) VerifyAuditLog(c *gin.Context) {
+	result, err := h.audit.Verify(c.Request.Context())
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to verify audit log",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"verification": result,
+	})
+}
+
+// auditUserState es el estado de un usuario que se guarda en el registro de auditoría.
+func auditUserState(user *identity.User) map[string]interface{} {
+	return map[string]interface{}{
+		"email":         user.Email,
+		"display_name":  user.DisplayName,
+		"disabled":      user.Disabled,
+		"custom_claims": user.CustomClaims,
+	}
+}
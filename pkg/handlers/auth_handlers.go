@@ -3,61 +3,69 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/andrescris/firestore/lib/firebase/auth" // Asegúrate que el path sea correcto
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 // Login maneja la solicitud de inicio de sesión.
-func Login(c *gin.Context) {
-	var req auth.LoginRequest
+func (h *Handler) Login(c *gin.Context) {
+	var req identity.Credentials
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "El email y la contraseña son requeridos."})
 		return
 	}
 
-	// Llama a la función Login de tu librería
-	loginResponse, err := auth.Login(c.Request.Context(), req)
+	// Llama al Login del proveedor de identidad
+	loginResponse, err := h.users.Login(c.Request.Context(), req)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ocurrió un error durante el login", "details": err.Error()})
 		return
 	}
 
 	if !loginResponse.Success {
+		h.auditFailedLogin(c, req.Email, loginResponse.Message)
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": loginResponse.Message})
 		return
 	}
 
-	// ### CAMBIO IMPORTANTE AQUÍ ###
-	// En lugar de devolver el objeto 'loginResponse' completo (que causa el error de fecha),
-	// construimos una respuesta limpia solo con los datos que el cliente necesita.
+	// El actor aún no está en el contexto: la sesión se acaba de abrir
+	c.Set("uid", loginResponse.UID)
+	h.recordAudit(c, auditLogin, loginResponse.UID, nil, map[string]interface{}{"email": req.Email})
+
+	// Construimos una respuesta limpia solo con los datos que el cliente necesita
+	// (el objeto User completo causaba errores de fecha al serializar).
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
 		"message":      loginResponse.Message,
 		"session_id":   loginResponse.SessionID,
 		"custom_token": loginResponse.CustomToken,
 		"expires_at":   loginResponse.ExpiresAt,
-		"uid":          loginResponse.User.UID, // Devolvemos solo el UID en lugar del objeto User completo
+		"uid":          loginResponse.UID,
 		"claims":       loginResponse.Claims,
 	})
 }
 
 // Logout maneja el cierre de sesión.
-func Logout(c *gin.Context) {
+func (h *Handler) Logout(c *gin.Context) {
 	// El middleware de sesión ya validó y guardó estos datos en el contexto
-	sessionID, _ := c.Get("session_id")
-	uid, _ := c.Get("uid")
-
-	req := auth.LogoutRequest{
-		UID:       uid.(string),
-		SessionID: sessionID.(string),
-	}
+	sessionID := c.GetString("session_id")
+	uid := c.GetString("uid")
 
-	// Llama a la función Logout de tu librería
-	logoutResponse, err := auth.Logout(c.Request.Context(), req)
-	if err != nil {
+	if err := h.users.Logout(c.Request.Context(), uid, sessionID); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ocurrió un error durante el logout", "details": err.Error()})
 		return
 	}
+	h.recordAudit(c, auditLogout, uid, nil, nil)
 
-	c.JSON(http.StatusOK, logoutResponse)
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sesión cerrada exitosamente",
+	})
+}
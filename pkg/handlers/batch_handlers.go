@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/schema"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/trash"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// Operaciones de POST /collections/batch
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// batchChangeType es el evento del stream de cambios de cada escritura de documento.
+var batchChangeType = map[string]string{
+	storage.BatchCreate:  changefeed.Created,
+	storage.BatchReplace: changefeed.Updated,
+	storage.BatchDelete:  changefeed.Deleted,
+}
+
+// batchOperation es una operación de un lote. ID es opcional al crear (se genera uno);
+// IfMatch y Hard equivalen a la cabecera If-Match y a ?hard=true de las rutas individuales.
+type batchOperation struct {
+	Op         string                 `json:"op"`
+	Collection string                 `json:"collection"`
+	ID         string                 `json:"id"`
+	Data       map[string]interface{} `json:"data"`
+	IfMatch    string                 `json:"if_match"`
+	Hard       bool                   `json:"hard"`
+}
+
+// batchResult es el resultado de una operación de un lote aplicado.
+type batchResult struct {
+	Index      int    `json:"index"`
+	Op         string `json:"op"`
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+	Status     int    `json:"status"`
+}
+
+// batchFailure es el motivo por el que una operación impide aplicar el lote.
+type batchFailure struct {
+	status  int
+	message string
+	err     error
+	etag    string
+	fields  []schema.FieldError
+}
+
+// BatchWrite aplica hasta batch.max_operations operaciones create, update y delete sobre
+// una o varias colecciones, todas o ninguna. Cada operación pasa las mismas comprobaciones
+// que su ruta individual (registro de colecciones, subdominio, project_id, esquema e
+// If-Match) y deja su revisión y, al eliminar, su entrada en la papelera dentro del lote.
+// Un borrado definitivo no deja revisión y elimina el historial del documento tras el lote.
+func (h *Handler) BatchWrite(c *gin.Context) {
+	var req struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(req.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'operations'"})
+		return
+	}
+	if limit := h.cfg.Batch.MaxOperations; len(req.Operations) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Too many operations",
+			"details": fmt.Sprintf("a batch accepts at most %d operations", limit),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	var writes []storage.BatchWrite
+	owner := []int{} // operación a la que pertenece cada escritura
+	results := make([]batchResult, len(req.Operations))
+	seen := make(map[string]bool, len(req.Operations))
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		if op.Op == batchCreate && op.ID == "" {
+			op.ID = storage.NewDocumentID()
+		}
+		// Las condiciones se comprueban contra el estado previo al lote
+		key := op.Collection + "/" + op.ID
+		if op.ID != "" && seen[key] {
+			batchFailed(c, i, *op, &batchFailure{status: http.StatusBadRequest, message: "El lote modifica el mismo documento más de una vez"})
+			return
+		}
+		seen[key] = true
+
+		opWrites, failure := h.prepareBatchOperation(c, *op, now)
+		if failure != nil {
+			if middleware.AbortIfContextDone(c, failure.err) {
+				return
+			}
+			batchFailed(c, i, *op, failure)
+			return
+		}
+		writes = append(writes, opWrites...)
+		for range opWrites {
+			owner = append(owner, i)
+		}
+
+		status := http.StatusOK
+		if op.Op == batchCreate {
+			status = http.StatusCreated
+		}
+		results[i] = batchResult{Index: i, Op: op.Op, Collection: op.Collection, ID: op.ID, Status: status}
+	}
+
+	if err := h.docs.ApplyBatch(c.Request.Context(), writes); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		var batchErr *storage.BatchError
+		if !errors.As(err, &batchErr) || batchErr.Index >= len(owner) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to apply batch",
+				"details": err.Error(),
+			})
+			return
+		}
+		i := owner[batchErr.Index]
+		batchFailed(c, i, req.Operations[i], applyFailure(req.Operations[i], err))
+		return
+	}
+
+	// La primera escritura de cada operación es la del documento
+	for j, write := range writes {
+		if j > 0 && owner[j] == owner[j-1] {
+			continue
+		}
+		h.publishChange(c, batchChangeType[write.Op], write.Collection, write.ID, write.Data)
+	}
+	// Los borrados definitivos se llevan su historial; el lote ya se aplicó, así que un fallo solo se registra
+	for _, op := range req.Operations {
+		if op.Op != batchDelete || !op.Hard {
+			continue
+		}
+		if _, err := revisions.Delete(c.Request.Context(), h.docs, op.Collection, op.ID, time.Time{}); err != nil {
+			log.Printf("⚠️ No se pudo borrar el historial de %s/%s: %v", op.Collection, op.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Batch applied successfully",
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+// batchFailed responde con el error de la operación index; no se aplicó ninguna.
+func batchFailed(c *gin.Context, index int, op batchOperation, failure *batchFailure) {
+	response := gin.H{
+		"error":      failure.message,
+		"message":    "No se aplicó ninguna operación del lote",
+		"operation":  index,
+		"op":         op.Op,
+		"collection": op.Collection,
+		"id":         op.ID,
+	}
+	if failure.err != nil {
+		response["details"] = failure.err.Error()
+	}
+	if failure.etag != "" {
+		response["etag"] = failure.etag
+	}
+	if len(failure.fields) > 0 {
+		response["fields"] = failure.fields
+	}
+	c.JSON(failure.status, response)
+}
+
+// applyFailure traduce el error de ApplyBatch: el documento cambió entre la comprobación y
+// la escritura (otra petición lo modificó, eliminó o creó).
+func applyFailure(op batchOperation, err error) *batchFailure {
+	switch {
+	case errors.Is(err, storage.ErrVersionMismatch) && op.IfMatch != "":
+		return &batchFailure{status: http.StatusPreconditionFailed, message: "El documento fue modificado por otra petición", err: err}
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return &batchFailure{status: http.StatusConflict, message: "El documento fue modificado por otra petición", err: err}
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return &batchFailure{status: http.StatusConflict, message: "Ya existe un documento con ese ID en la colección", err: err}
+	case errors.Is(err, storage.ErrNotFound):
+		return &batchFailure{status: http.StatusNotFound, message: "Document not found", err: err}
+	default:
+		return &batchFailure{status: http.StatusInternalServerError, message: "Failed to apply batch", err: err}
+	}
+}
+
+// prepareBatchOperation aplica a op las comprobaciones de su ruta individual y devuelve
+// las escrituras que le corresponden en el lote.
+func (h *Handler) prepareBatchOperation(c *gin.Context, op batchOperation, now time.Time) ([]storage.BatchWrite, *batchFailure) {
+	settings, ok := h.cfg.Collections.Lookup(op.Collection)
+	if !ok {
+		return nil, &batchFailure{status: http.StatusNotFound, message: "Collection not found"}
+	}
+	// Los helpers de acceso leen la colección del contexto, como tras CollectionAccessMiddleware
+	c.Set("collection_settings", settings)
+	if reason := middleware.CollectionWriteDenied(settings, isAdmin(c)); reason != "" {
+		return nil, &batchFailure{status: http.StatusForbidden, message: reason}
+	}
+	if !validDocumentID(op.ID) {
+		return nil, &batchFailure{status: http.StatusBadRequest, message: "Missing or invalid 'id'"}
+	}
+
+	switch op.Op {
+	case batchCreate:
+		return h.prepareBatchCreate(c, op, now)
+	case batchUpdate:
+		return h.prepareBatchUpdate(c, op, now)
+	case batchDelete:
+		return h.prepareBatchDelete(c, op, now)
+	default:
+		return nil, &batchFailure{status: http.StatusBadRequest, message: "Invalid 'op' (use create, update or delete)"}
+	}
+}
+
+func (h *Handler) prepareBatchCreate(c *gin.Context, op batchOperation, now time.Time) ([]storage.BatchWrite, *batchFailure) {
+	if op.Data == nil {
+		return nil, &batchFailure{status: http.StatusBadRequest, message: "Missing 'data'"}
+	}
+	if projectID, ok := op.Data["project_id"].(string); !ok || projectID == "" {
+		return nil, &batchFailure{status: http.StatusBadRequest, message: "Missing or invalid 'project_id'"}
+	}
+
+	// SEGURIDAD: igual que CreateDocument, el subdominio es siempre el de la sesión
+	if userSubdomain, exists := c.Get("subdomain"); exists && tenantScoped(c) {
+		op.Data["subdomain"] = userSubdomain.(string)
+	}
+	stripAuditFields(op.Data)
+	if failure := h.batchSchemaCheck(c, op.Collection, op.Data); failure != nil {
+		return nil, failure
+	}
+	stampCreated(c, op.Data, now)
+
+	return []storage.BatchWrite{
+		{Op: storage.BatchCreate, Collection: op.Collection, ID: op.ID, Data: op.Data},
+		{Op: storage.BatchCreate, Collection: revisionsCollection, ID: storage.NewDocumentID(),
+			Data: revisionData(c, op.Collection, op.ID, revisionCreate, nil)},
+	}, nil
+}
+
+func (h *Handler) prepareBatchUpdate(c *gin.Context, op batchOperation, now time.Time) ([]storage.BatchWrite, *batchFailure) {
+	if op.Data == nil {
+		return nil, &batchFailure{status: http.StatusBadRequest, message: "Missing 'data'"}
+	}
+	current, failure := h.batchCurrentDocument(c, op)
+	if failure != nil {
+		return nil, failure
+	}
+
+	// SEGURIDAD: igual que UpdateDocument, solo los admins cambian el subdominio
+	if !isAdmin(c) {
+		delete(op.Data, "subdomain")
+	}
+	stripAuditFields(op.Data)
+
+	// Como UpdateDocument, se fusionan las claves de primer nivel; el lote escribe el resultado
+	merged := make(map[string]interface{}, len(current.Data)+len(op.Data))
+	for key, value := range current.Data {
+		merged[key] = value
+	}
+	for key, value := range op.Data {
+		merged[key] = value
+	}
+	if failure := h.batchSchemaCheck(c, op.Collection, merged); failure != nil {
+		return nil, failure
+	}
+	stampUpdated(c, merged, now)
+
+	return []storage.BatchWrite{
+		{Op: storage.BatchReplace, Collection: op.Collection, ID: op.ID, Data: merged, Version: storage.DocumentVersion(current)},
+		{Op: storage.BatchCreate, Collection: revisionsCollection, ID: storage.NewDocumentID(),
+			Data: revisionData(c, op.Collection, op.ID, revisionUpdate, current.Data)},
+	}, nil
+}
+
+func (h *Handler) prepareBatchDelete(c *gin.Context, op batchOperation, now time.Time) ([]storage.BatchWrite, *batchFailure) {
+	if op.Hard && !isAdmin(c) {
+		return nil, &batchFailure{status: http.StatusForbidden, message: "Solo los administradores pueden eliminar documentos definitivamente"}
+	}
+	current, failure := h.batchCurrentDocument(c, op)
+	if failure != nil {
+		return nil, failure
+	}
+
+	writes := []storage.BatchWrite{
+		{Op: storage.BatchDelete, Collection: op.Collection, ID: op.ID, Data: current.Data, Version: storage.DocumentVersion(current)},
+	}
+	if !op.Hard {
+		writes = append(writes,
+			storage.BatchWrite{Op: storage.BatchCreate, Collection: revisionsCollection, ID: storage.NewDocumentID(),
+				Data: revisionData(c, op.Collection, op.ID, revisionDelete, current.Data)},
+			storage.BatchWrite{Op: storage.BatchCreate, Collection: trash.Collection,
+				ID: trash.NewEntryID(), Data: trash.EntryData(op.Collection, op.ID, current.Data, c.GetString("uid"), now)})
+	}
+	return writes, nil
+}
+
+// batchCurrentDocument carga el documento de op comprobando el subdominio y el if_match.
+func (h *Handler) batchCurrentDocument(c *gin.Context, op batchOperation) (*firebase.Document, *batchFailure) {
+	doc, err := h.docs.GetDocument(c.Request.Context(), op.Collection, op.ID)
+	if err != nil {
+		return nil, &batchFailure{status: http.StatusNotFound, message: "Document not found", err: err}
+	}
+	if !canAccessSubdomain(c, doc.Data) {
+		return nil, &batchFailure{status: http.StatusForbidden, message: "No puedes modificar documentos de otro subdominio"}
+	}
+	if etag := documentETag(doc); op.IfMatch != "" && !etagMatches(op.IfMatch, etag) {
+		return nil, &batchFailure{status: http.StatusPreconditionFailed, message: "El documento fue modificado por otra petición", etag: etag}
+	}
+	return doc, nil
+}
+
+// batchSchemaCheck es validateDocument para una operación de un lote.
+func (h *Handler) batchSchemaCheck(c *gin.Context, collection string, data map[string]interface{}) *batchFailure {
+	errs, err := h.schemaErrors(c, collection, data)
+	if err != nil {
+		return &batchFailure{status: http.StatusInternalServerError, message: "Failed to load collection schema", err: err}
+	}
+	if len(errs) > 0 {
+		return &batchFailure{status: http.StatusUnprocessableEntity, message: "El documento no cumple el esquema de la colección", fields: errs}
+	}
+	return nil
+}
+
+// validDocumentID aplica las restricciones de Firestore a los IDs de documento.
+func validDocumentID(id string) bool {
+	if id == "" || id == "." || id == ".." || len(id) > 1500 || strings.Contains(id, "/") {
+		return false
+	}
+	return !(strings.HasPrefix(id, "__") && strings.HasSuffix(id, "__"))
+}
@@ -3,16 +3,25 @@
 package handlers
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/patch"
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/trash"
 	"github.com/andrescris/firestore/lib/firebase"
-	"github.com/andrescris/firestore/lib/firebase/firestore"
 	"github.com/gin-gonic/gin"
 )
 
 // CreateDocument maneja la creación de nuevos documentos
-func CreateDocument(c *gin.Context) {
+func (h *Handler) CreateDocument(c *gin.Context) {
 	collection := c.Param("collection")
 	var data map[string]interface{}
 
@@ -33,15 +42,25 @@ func CreateDocument(c *gin.Context) {
 		return
 	}
 
-	// SEGURIDAD: Forzar el subdomain del usuario autenticado
+	// SEGURIDAD: Forzar el subdomain del usuario autenticado (las colecciones globales no tienen)
 	userSubdomain, exists := c.Get("subdomain")
-	if exists {
+	if exists && tenantScoped(c) {
 		data["subdomain"] = userSubdomain.(string)
 	}
 
-	ctx := context.Background()
-	docID, err := firestore.CreateDocument(ctx, collection, data)
+	// Los campos de auditoría los pone el servidor
+	stripAuditFields(data)
+	if !h.validateDocument(c, collection, data) {
+		return
+	}
+	stampCreated(c, data, time.Now().UTC())
+
+	ctx := c.Request.Context()
+	docID, err := h.docs.CreateDocument(ctx, collection, data)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to create document",
 			"details": err.Error(),
@@ -49,23 +68,40 @@ func CreateDocument(c *gin.Context) {
 		return
 	}
 
+	// El documento ya existe: si el historial falla solo lo registramos en el log
+	if _, err := h.recordRevision(c, collection, docID, revisionCreate, nil); err != nil {
+		log.Printf("⚠️ No se pudo registrar la revisión de %s/%s: %v", collection, docID, err)
+	}
+
+	// Releemos el documento para devolver las fechas asignadas por el backend;
+	// si la lectura falla, el documento ya existe y respondemos con lo que tenemos.
+	view := documentView{ID: docID, Collection: collection, Data: data}
+	if doc, err := h.docs.GetDocument(ctx, collection, docID); err == nil {
+		view = newDocumentView(collection, doc)
+	}
+	h.publishChange(c, changefeed.Created, collection, docID, view.Data)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"success":     true,
 		"document_id": docID,
 		"collection":  collection,
-		"data":        data,
+		"data":        view.Data,
+		"document":    view,
 		"message":     "Document created successfully",
 	})
 }
 
 // GetDocument obtiene un documento específico por ID
-func GetDocument(c *gin.Context) {
+func (h *Handler) GetDocument(c *gin.Context) {
 	collection := c.Param("collection")
 	docID := c.Param("id")
 
-	ctx := context.Background()
-	doc, err := firestore.GetDocument(ctx, collection, docID)
+	ctx := c.Request.Context()
+	doc, err := h.docs.GetDocument(ctx, collection, docID)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{
 			"error":       "Document not found",
 			"collection":  collection,
@@ -76,16 +112,16 @@ func GetDocument(c *gin.Context) {
 
 	// SEGURIDAD: Verificar que el documento pertenezca al subdominio del usuario
 	userSubdomain, exists := c.Get("subdomain")
-	if exists {
+	if exists && tenantScoped(c) {
 		docSubdomain, hasSubdomain := doc.Data["subdomain"].(string)
-		
+
 		// Si el documento tiene subdomain y no coincide, denegar acceso
 		if hasSubdomain && docSubdomain != userSubdomain.(string) {
 			// Verificar si es admin (los admins pueden ver todo)
 			claims, _ := c.Get("claims")
 			claimsMap, _ := claims.(map[string]interface{})
 			role, _ := claimsMap["role"].(string)
-			
+
 			if role != "admin" {
 				c.JSON(http.StatusForbidden, gin.H{
 					"error": "No tienes permiso para ver este documento",
@@ -95,17 +131,27 @@ func GetDocument(c *gin.Context) {
 		}
 	}
 
+	h.auditCrossTenantRead(c, collection, []*firebase.Document{doc})
+
+	// El ETag se calcula después de comprobar el acceso para no revelar versiones ajenas
+	etag := documentETag(doc)
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
-		"document": doc,
+		"document": newDocumentView(collection, doc),
 	})
 }
 
-// ListDocuments obtiene todos los documentos de una colección
-func ListDocuments(c *gin.Context) {
+// ListDocuments obtiene los documentos de una colección, paginados con ?limit= y ?page_token=
+func (h *Handler) ListDocuments(c *gin.Context) {
 	collection := c.Param("collection")
 
-	// SEGURIDAD: En lugar de obtener TODOS los documentos, 
+	// SEGURIDAD: En lugar de obtener TODOS los documentos,
 	// hacemos una consulta filtrada por subdomain
 	userSubdomain, exists := c.Get("subdomain")
 	if !exists {
@@ -120,24 +166,30 @@ func ListDocuments(c *gin.Context) {
 	claimsMap, _ := claims.(map[string]interface{})
 	role, _ := claimsMap["role"].(string)
 
-	ctx := context.Background()
-	var docs []*firebase.Document
-	var err error
-
-	if role == "admin" {
-		// Los admins pueden ver todos los documentos
-		docs, err = firestore.GetAllDocuments(ctx, collection)
-	} else {
-		// Usuarios normales solo ven sus documentos
-		options := firebase.QueryOptions{
-			Filters: []firebase.QueryFilter{
-				{Field: "subdomain", Operator: "==", Value: userSubdomain.(string)},
-			},
+	var options firebase.QueryOptions
+	if role != "admin" && tenantScoped(c) {
+		// Usuarios normales solo ven sus documentos; los admins ven todos
+		options.Filters = []firebase.QueryFilter{
+			{Field: "subdomain", Operator: "==", Value: userSubdomain.(string)},
 		}
-		docs, err = firestore.QueryDocuments(ctx, collection, options)
 	}
 
+	limit := h.pageSize(c)
+	after, err := h.decodePageToken(c.Query("page_token"), collection, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	// Pedimos un documento extra para saber si hay más páginas
+	ctx := c.Request.Context()
+	query := options
+	query.Limit = limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(ctx, collection, query, after)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to list documents",
 			"details": err.Error(),
@@ -145,22 +197,28 @@ func ListDocuments(c *gin.Context) {
 		return
 	}
 
-	// Convertir los documentos a formato map para la respuesta JSON
-	docMaps := make([]map[string]interface{}, len(docs))
-	for i, doc := range docs {
-		docMaps[i] = doc.Data
+	docs, nextToken, err := h.paginate(collection, options, docs, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
 	}
 
+	h.auditCrossTenantRead(c, collection, docs)
 	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
-		"documents":  docMaps,
-		"count":      len(docMaps),
-		"collection": collection,
+		"success":         true,
+		"documents":       newDocumentViews(collection, docs),
+		"count":           len(docs),
+		"collection":      collection,
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
 	})
 }
 
 // UpdateDocument actualiza un documento existente
-func UpdateDocument(c *gin.Context) {
+func (h *Handler) UpdateDocument(c *gin.Context) {
 	collection := c.Param("collection")
 	docID := c.Param("id")
 	var data map[string]interface{}
@@ -173,11 +231,14 @@ func UpdateDocument(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	// SEGURIDAD: Verificar que el documento existe y pertenece al usuario
-	currentDoc, err := firestore.GetDocument(ctx, collection, docID)
+	currentDoc, err := h.docs.GetDocument(ctx, collection, docID)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{
 			"error":       "Document not found",
 			"collection":  collection,
@@ -187,40 +248,60 @@ func UpdateDocument(c *gin.Context) {
 	}
 
 	// Verificar ownership por subdomain
-	userSubdomain, exists := c.Get("subdomain")
-	if exists {
-		docSubdomain, hasSubdomain := currentDoc.Data["subdomain"].(string)
-		
-		if hasSubdomain && docSubdomain != userSubdomain.(string) {
-			// Verificar si es admin
-			claims, _ := c.Get("claims")
-			claimsMap, _ := claims.(map[string]interface{})
-			role, _ := claimsMap["role"].(string)
-			
-			if role != "admin" {
-				c.JSON(http.StatusForbidden, gin.H{
-					"error": "No puedes modificar documentos de otro subdominio",
-				})
-				return
-			}
-		}
+	if !canAccessSubdomain(c, currentDoc.Data) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "No puedes modificar documentos de otro subdominio",
+		})
+		return
 	}
 
 	// SEGURIDAD: Prevenir que cambien el subdomain via update
 	// (solo admins podrían hacerlo, y solo si es necesario)
-	if exists {
-		claims, _ := c.Get("claims")
-		claimsMap, _ := claims.(map[string]interface{})
-		role, _ := claimsMap["role"].(string)
-		
-		if role != "admin" {
-			// Usuarios normales no pueden cambiar el subdomain
-			delete(data, "subdomain")
-		}
+	if _, exists := c.Get("subdomain"); exists && !isAdmin(c) {
+		// Usuarios normales no pueden cambiar el subdomain
+		delete(data, "subdomain")
+	}
+	stripAuditFields(data)
+
+	// UpdateDocument fusiona las claves de primer nivel: validamos el resultado de esa fusión
+	merged := make(map[string]interface{}, len(currentDoc.Data)+len(data))
+	for key, value := range currentDoc.Data {
+		merged[key] = value
+	}
+	for key, value := range data {
+		merged[key] = value
+	}
+	if !h.validateDocument(c, collection, merged) {
+		return
+	}
+	stampUpdated(c, data, time.Now().UTC())
+
+	// Concurrencia optimista: con If-Match la escritura solo se aplica sobre la versión leída
+	version, ok := checkIfMatch(c, currentDoc)
+	if !ok {
+		return
 	}
 
-	err = firestore.UpdateDocument(ctx, collection, docID, data)
+	// Guardamos el estado anterior antes de escribir; sin revisión no hay escritura
+	revisionID, err := h.recordRevision(c, collection, docID, revisionUpdate, currentDoc.Data)
 	if err != nil {
+		revisionFailed(c, err)
+		return
+	}
+	if version != "" {
+		err = h.docs.UpdateDocumentIfMatch(ctx, collection, docID, data, version)
+	} else {
+		err = h.docs.UpdateDocument(ctx, collection, docID, data)
+	}
+	if err != nil {
+		h.discardRevision(c, revisionID)
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			preconditionFailed(c, h.currentETag(c, collection, docID))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to update document",
 			"details": err.Error(),
@@ -228,6 +309,16 @@ func UpdateDocument(c *gin.Context) {
 		return
 	}
 
+	// UpdateDocument fusiona las claves de primer nivel, así que merged más data es el estado final
+	for key, value := range data {
+		merged[key] = value
+	}
+	h.publishChange(c, changefeed.Updated, collection, docID, merged)
+
+	if etag := h.currentETag(c, collection, docID); etag != "" {
+		c.Header("ETag", etag)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":     true,
 		"message":     "Document updated successfully",
@@ -236,16 +327,192 @@ func UpdateDocument(c *gin.Context) {
 	})
 }
 
-// DeleteDocument elimina un documento
-func DeleteDocument(c *gin.Context) {
+// Tipos de contenido aceptados por PatchDocument
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchRetries es cuántas veces PatchDocument reintenta sin If-Match si otro cliente
+// escribe el documento entre la lectura y la escritura.
+const patchRetries = 3
+
+// PatchDocument actualiza parte de un documento con JSON Merge Patch (RFC 7396, también
+// para application/json) o JSON Patch (RFC 6902)
+func (h *Handler) PatchDocument(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to read request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var apply func(map[string]interface{}) (map[string]interface{}, error)
+	switch c.ContentType() {
+	case mergePatchType, "application/json":
+		var mergePatch map[string]interface{}
+		if err := json.Unmarshal(body, &mergePatch); err != nil || mergePatch == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid merge patch: the body must be a JSON object",
+				"details": fmt.Sprint(err),
+			})
+			return
+		}
+		apply = func(data map[string]interface{}) (map[string]interface{}, error) {
+			return patch.Merge(data, mergePatch), nil
+		}
+	case jsonPatchType:
+		var ops []patch.Operation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid JSON Patch: the body must be an array of operations",
+				"details": err.Error(),
+			})
+			return
+		}
+		apply = func(data map[string]interface{}) (map[string]interface{}, error) {
+			return patch.Apply(data, ops)
+		}
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type must be " + mergePatchType + " or " + jsonPatchType,
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	for attempt := 1; ; attempt++ {
+		currentDoc, err := h.docs.GetDocument(ctx, collection, docID)
+		if err != nil {
+			if middleware.AbortIfContextDone(c, err) {
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":       "Document not found",
+				"collection":  collection,
+				"document_id": docID,
+			})
+			return
+		}
+
+		// SEGURIDAD: mismas reglas de subdominio que UpdateDocument
+		if !canAccessSubdomain(c, currentDoc.Data) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "No puedes modificar documentos de otro subdominio",
+			})
+			return
+		}
+		if _, ok := checkIfMatch(c, currentDoc); !ok {
+			return
+		}
+
+		data, err := apply(currentDoc.Data)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, patch.ErrConflict) {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{
+				"error":   "Patch could not be applied",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// SEGURIDAD: los usuarios normales no pueden cambiar ni quitar el subdomain;
+		// igual que en PUT, el cambio se ignora
+		if _, exists := c.Get("subdomain"); exists && !isAdmin(c) {
+			if original, ok := currentDoc.Data["subdomain"]; ok {
+				data["subdomain"] = original
+			} else {
+				delete(data, "subdomain")
+			}
+		}
+
+		// Los campos de auditoría no se pueden modificar con el patch
+		keepCreated(data, currentDoc.Data)
+		delete(data, "updated_at")
+		delete(data, "updated_by")
+		if !h.validateDocument(c, collection, data) {
+			return
+		}
+		stampUpdated(c, data, time.Now().UTC())
+
+		revisionID, err := h.recordRevision(c, collection, docID, revisionUpdate, currentDoc.Data)
+		if err != nil {
+			revisionFailed(c, err)
+			return
+		}
+		// La escritura siempre es condicional a la versión leída, para no pisar cambios ajenos
+		err = h.docs.ReplaceDocumentIfMatch(ctx, collection, docID, data, storage.DocumentVersion(currentDoc))
+		if err != nil {
+			h.discardRevision(c, revisionID)
+			if middleware.AbortIfContextDone(c, err) {
+				return
+			}
+			if errors.Is(err, storage.ErrVersionMismatch) {
+				// Con If-Match el cliente decide; sin él, reintentamos sobre la versión nueva
+				if c.GetHeader("If-Match") != "" {
+					preconditionFailed(c, h.currentETag(c, collection, docID))
+					return
+				}
+				if attempt < patchRetries {
+					continue
+				}
+				c.JSON(http.StatusConflict, gin.H{
+					"error":   "El documento se modificó mientras se aplicaba el patch",
+					"details": err.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to patch document",
+				"details": err.Error(),
+			})
+			return
+		}
+		h.publishChange(c, changefeed.Updated, collection, docID, data)
+		break
+	}
+
+	view := documentView{ID: docID, Collection: collection}
+	if doc, err := h.docs.GetDocument(ctx, collection, docID); err == nil {
+		view = newDocumentView(collection, doc)
+		c.Header("ETag", documentETag(doc))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Document patched successfully",
+		"document": view,
+	})
+}
+
+// DeleteDocument mueve un documento a la papelera. Con ?hard=true (solo admins) lo elimina definitivamente.
+func (h *Handler) DeleteDocument(c *gin.Context) {
 	collection := c.Param("collection")
 	docID := c.Param("id")
 
-	ctx := context.Background()
+	hard := c.Query("hard") == "true"
+	if hard && !isAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Solo los administradores pueden eliminar documentos definitivamente",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
 
 	// SEGURIDAD: Verificar que el documento existe y pertenece al usuario
-	currentDoc, err := firestore.GetDocument(ctx, collection, docID)
+	currentDoc, err := h.docs.GetDocument(ctx, collection, docID)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{
 			"error":       "Document not found",
 			"collection":  collection,
@@ -255,63 +522,126 @@ func DeleteDocument(c *gin.Context) {
 	}
 
 	// Verificar ownership por subdomain
-	userSubdomain, exists := c.Get("subdomain")
-	if exists {
-		docSubdomain, hasSubdomain := currentDoc.Data["subdomain"].(string)
-		
-		if hasSubdomain && docSubdomain != userSubdomain.(string) {
-			// Verificar si es admin
-			claims, _ := c.Get("claims")
-			claimsMap, _ := claims.(map[string]interface{})
-			role, _ := claimsMap["role"].(string)
-			
-			if role != "admin" {
-				c.JSON(http.StatusForbidden, gin.H{
-					"error": "No puedes eliminar documentos de otro subdominio",
-				})
+	if !canAccessSubdomain(c, currentDoc.Data) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "No puedes eliminar documentos de otro subdominio",
+		})
+		return
+	}
+
+	version, ok := checkIfMatch(c, currentDoc)
+	if !ok {
+		return
+	}
+
+	// El borrado definitivo no deja revisión: el historial se elimina con el documento
+	var revisionID, entryID string
+	if !hard {
+		revisionID, err = h.recordRevision(c, collection, docID, revisionDelete, currentDoc.Data)
+		if err != nil {
+			revisionFailed(c, err)
+			return
+		}
+	}
+
+	// Primero la copia en la papelera: si falla, el documento sigue intacto
+	deletedAt := time.Now().UTC()
+	if !hard {
+		entryID, err = trash.Put(ctx, h.docs, collection, docID, currentDoc.Data, c.GetString("uid"), deletedAt)
+		if err != nil {
+			h.discardRevision(c, revisionID)
+			if middleware.AbortIfContextDone(c, err) {
 				return
 			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to move document to trash",
+				"details": err.Error(),
+			})
+			return
 		}
 	}
-
-	err = firestore.DeleteDocument(ctx, collection, docID)
+	if version != "" {
+		err = h.docs.DeleteDocumentIfMatch(ctx, collection, docID, version)
+	} else {
+		err = h.docs.DeleteDocument(ctx, collection, docID)
+	}
 	if err != nil {
+		if !hard {
+			h.discardRevision(c, revisionID)
+			if err := trash.Remove(ctx, h.docs, entryID); err != nil {
+				log.Printf("⚠️ No se pudo quitar %s/%s de la papelera: %v", collection, docID, err)
+			}
+		}
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			preconditionFailed(c, h.currentETag(c, collection, docID))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to delete document",
 			"details": err.Error(),
 		})
 		return
 	}
+	h.publishChange(c, changefeed.Deleted, collection, docID, currentDoc.Data)
 
+	if hard {
+		if _, err := revisions.Delete(ctx, h.docs, collection, docID, time.Time{}); err != nil {
+			log.Printf("⚠️ No se pudo borrar el historial de %s/%s: %v", collection, docID, err)
+			if middleware.AbortIfContextDone(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Document deleted but its revisions could not be removed",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"message":     "Document permanently deleted",
+			"collection":  collection,
+			"document_id": docID,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success":     true,
-		"message":     "Document deleted successfully",
+		"message":     "Document moved to trash",
 		"collection":  collection,
 		"document_id": docID,
+		"deleted_at":  deletedAt,
+		"purge_at":    deletedAt.Add(h.cfg.Trash.Retention),
 	})
 }
 
 // QueryDocuments realiza consultas con filtros en una colección
-func QueryDocuments(c *gin.Context) {
+func (h *Handler) QueryDocuments(c *gin.Context) {
 	collection := c.Param("collection")
-	var options firebase.QueryOptions
+	var req struct {
+		firebase.QueryOptions
+		PageToken string `json:"page_token"`
+	}
 
-	if err := c.ShouldBindJSON(&options); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "Invalid JSON format",
 			"details": err.Error(),
 		})
 		return
 	}
+	options := req.QueryOptions
 
-	// SEGURIDAD: Añadir automáticamente filtro por subdominio
+	// SEGURIDAD: Añadir automáticamente filtro por subdominio (salvo en colecciones globales)
 	userSubdomain, exists := c.Get("subdomain")
-	if exists {
+	if exists && tenantScoped(c) {
 		// Verificar si es admin
 		claims, _ := c.Get("claims")
 		claimsMap, _ := claims.(map[string]interface{})
 		role, _ := claimsMap["role"].(string)
-		
+
 		// Solo añadir filtro de subdomain si NO es admin
 		if role != "admin" {
 			subdomainFilter := firebase.QueryFilter{
@@ -323,6 +653,15 @@ func QueryDocuments(c *gin.Context) {
 		}
 	}
 
+	// Los timestamps de auditoría llegan como texto RFC 3339
+	if err := normalizeAuditFilters(options.Filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid filter value",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	// Validar que al menos uno de los filtros sea project_id
 	hasProjectFilter := false
 	for _, filter := range options.Filters {
@@ -338,9 +677,29 @@ func QueryDocuments(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	docs, err := firestore.QueryDocuments(ctx, collection, options)
+	// Limitar el tamaño de página igual que en los listados
+	if options.Limit <= 0 {
+		options.Limit = h.cfg.Pagination.DefaultPageSize
+	}
+	if options.Limit > h.cfg.Pagination.MaxPageSize {
+		options.Limit = h.cfg.Pagination.MaxPageSize
+	}
+
+	after, err := h.decodePageToken(req.PageToken, collection, options)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	// Pedimos un documento extra para saber si hay más páginas
+	ctx := c.Request.Context()
+	query := options
+	query.Limit = options.Limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(ctx, collection, query, after)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to query documents",
 			"details": err.Error(),
@@ -348,11 +707,23 @@ func QueryDocuments(c *gin.Context) {
 		return
 	}
 
+	docs, nextToken, err := h.paginate(collection, options, docs, options.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	h.auditCrossTenantRead(c, collection, docs)
 	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
-		"documents":  docs,
-		"count":      len(docs),
-		"collection": collection,
-		"query":      options,
+		"success":         true,
+		"documents":       newDocumentViews(collection, docs),
+		"count":           len(docs),
+		"collection":      collection,
+		"query":           options,
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
 	})
-}
\ No newline at end of file
+}
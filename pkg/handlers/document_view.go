@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// documentView es el sobre común con el que la API devuelve un documento.
+type documentView struct {
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	Data       map[string]interface{} `json:"data"`
+	CreateTime *time.Time             `json:"create_time,omitempty"`
+	UpdateTime *time.Time             `json:"update_time,omitempty"`
+}
+
+func newDocumentView(collection string, doc *firebase.Document) documentView {
+	return documentView{
+		ID:         doc.ID,
+		Collection: collection,
+		Data:       doc.Data,
+		CreateTime: optionalTime(doc.CreateTime),
+		UpdateTime: optionalTime(doc.UpdateTime),
+	}
+}
+
+func newDocumentViews(collection string, docs []*firebase.Document) []documentView {
+	views := make([]documentView, len(docs))
+	for i, doc := range docs {
+		views[i] = newDocumentView(collection, doc)
+	}
+	return views
+}
+
+// optionalTime omite del JSON las fechas que el backend no informó.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
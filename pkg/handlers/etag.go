@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// documentETag devuelve el ETag (fuerte) de la versión actual de doc.
+func documentETag(doc *firebase.Document) string {
+	return `"` + storage.DocumentVersion(doc) + `"`
+}
+
+// etagMatches indica si la cabecera (If-Match / If-None-Match) incluye etag o "*".
+// Las etiquetas débiles (W/) se comparan por su valor, que es lo que pide If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evalúa If-Match contra la versión actual. Devuelve la versión esperada
+// (vacía si no hay cabecera) o false tras responder 412 si no coincide.
+func checkIfMatch(c *gin.Context, doc *firebase.Document) (string, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return "", true
+	}
+	etag := documentETag(doc)
+	if !etagMatches(header, etag) {
+		preconditionFailed(c, etag)
+		return "", false
+	}
+	return storage.DocumentVersion(doc), true
+}
+
+// preconditionFailed responde 412 indicando la versión actual para que el cliente
+// pueda releer el documento y reintentar.
+func preconditionFailed(c *gin.Context, currentETag string) {
+	if currentETag != "" {
+		c.Header("ETag", currentETag)
+	}
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "El documento fue modificado por otra petición",
+		"details": "If-Match does not match the current document version",
+	})
+}
+
+// currentETag relee el documento para devolver su ETag tras una escritura; vacío si no se puede leer.
+func (h *Handler) currentETag(c *gin.Context, collection, docID string) string {
+	doc, err := h.docs.GetDocument(c.Request.Context(), collection, docID)
+	if err != nil {
+		return ""
+	}
+	return documentETag(doc)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failedLoginWindow es el periodo en el que se cuentan los logins fallidos de cada IP.
+const failedLoginWindow = time.Minute
+
+// failedLoginMaxIPs limita cuántas IPs recuerda el limitador. Con el mapa lleno, los
+// fallidos de una IP nueva no se registran hasta que caduque alguna ventana.
+const failedLoginMaxIPs = 10000
+
+// failedLoginLimiter limita cuántos logins fallidos por IP y minuto llegan al registro de
+// auditoría: cualquiera puede provocarlos y todos los eventos comparten una sola cadena.
+type failedLoginLimiter struct {
+	limit int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*failedLoginCount
+}
+
+// failedLoginCount son los fallidos de una IP en la ventana que empezó en start.
+type failedLoginCount struct {
+	start      time.Time
+	recorded   int
+	suppressed int // descartados desde el último evento registrado
+}
+
+func newFailedLoginLimiter(limit int) *failedLoginLimiter {
+	return &failedLoginLimiter{limit: limit, now: time.Now, windows: make(map[string]*failedLoginCount)}
+}
+
+// allow indica si el login fallido desde ip se registra y, en ese caso, cuántos se
+// descartaron antes desde esa IP.
+func (l *failedLoginLimiter) allow(ip string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	count := l.windows[ip]
+	if count == nil {
+		if len(l.windows) >= failedLoginMaxIPs {
+			l.sweep(now)
+			if len(l.windows) >= failedLoginMaxIPs {
+				return false, 0
+			}
+		}
+		count = &failedLoginCount{start: now}
+		l.windows[ip] = count
+	} else if now.Sub(count.start) >= failedLoginWindow {
+		// Los descartados de la ventana anterior se informan en el siguiente evento
+		count.start, count.recorded = now, 0
+	}
+
+	if count.recorded >= l.limit {
+		count.suppressed++
+		return false, 0
+	}
+	count.recorded++
+	suppressed := count.suppressed
+	count.suppressed = 0
+	return true, suppressed
+}
+
+// sweep olvida las IPs cuya ventana ya terminó y no tienen descartados pendientes.
+func (l *failedLoginLimiter) sweep(now time.Time) {
+	for ip, count := range l.windows {
+		if now.Sub(count.start) >= failedLoginWindow && count.suppressed == 0 {
+			delete(l.windows, ip)
+		}
+	}
+}
+
+// auditFailedLogin registra un login fallido sin frenar la respuesta: pasa por el
+// limitador por IP y se escribe en segundo plano. El email lo elige quien llama, así que
+// el objetivo es el UID de la cuenta si existe y nunca el email.
+func (h *Handler) auditFailedLogin(c *gin.Context, email, reason string) {
+	ok, suppressed := h.failedLogins.allow(c.ClientIP())
+	if !ok {
+		return
+	}
+	after := map[string]interface{}{"reason": reason}
+	if suppressed > 0 {
+		after["suppressed"] = suppressed
+	}
+	target := ""
+	if user, err := h.users.GetUserByEmail(c.Request.Context(), email); err == nil {
+		target = user.UID
+	} else {
+		after["unknown_user"] = true
+	}
+	if !h.audit.RecordAsync(h.auditEvent(c, auditLoginFailed, target, nil, after)) {
+		log.Printf("⚠️ Cola de auditoría llena: se descarta un evento %s", auditLoginFailed)
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/andrescris/alimedia/pkg/auditlog"
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler agrupa las dependencias compartidas por los handlers HTTP.
+// Se construye una sola vez en main y sus métodos se registran como rutas de Gin.
+type Handler struct {
+	cfg   *config.Config
+	docs  storage.DocumentStore
+	users identity.Provider
+	audit *auditlog.Logger
+	// changes reparte los cambios de documentos entre los streams abiertos
+	changes *changefeed.Hub
+	// hooks encola los webhooks salientes; los envía el repartidor que arranca main
+	hooks *webhooks.Dispatcher
+	// failedLogins limita los logins fallidos que llegan al registro de auditoría
+	failedLogins *failedLoginLimiter
+
+	pageTokenKey []byte
+	// privacyKey firma los recibos de borrado y genera los seudónimos
+	privacyKey []byte
+}
+
+// New crea un Handler que usa docs como backend de documentos y users como proveedor de identidad.
+func New(cfg *config.Config, docs storage.DocumentStore, users identity.Provider) *Handler {
+	h := &Handler{
+		cfg:     cfg,
+		docs:    docs,
+		users:   users,
+		audit:   auditlog.New(docs, []byte(cfg.Audit.HMACSecret)),
+		changes: changefeed.New(cfg.Stream.BufferSize),
+		hooks:   webhooks.NewDispatcher(docs, cfg.Webhooks),
+
+		failedLogins: newFailedLoginLimiter(cfg.Audit.FailedLoginLimit),
+	}
+
+	h.pageTokenKey = []byte(cfg.Pagination.PageTokenSecret)
+	h.privacyKey = []byte(cfg.Privacy.ReceiptSecret)
+	return h
+}
+
+// FlushAudit espera a que se escriban los eventos de auditoría pendientes (los logins
+// fallidos se registran en segundo plano). main la llama antes de cerrar Firestore.
+func (h *Handler) FlushAudit() {
+	h.audit.Flush()
+}
+
+// pageSize lee el parámetro ?limit= aplicando el tamaño por defecto y el máximo configurados.
+func (h *Handler) pageSize(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return h.cfg.Pagination.DefaultPageSize
+	}
+	if limit > h.cfg.Pagination.MaxPageSize {
+		return h.cfg.Pagination.MaxPageSize // Máximo para evitar sobrecarga
+	}
+	return limit
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+var errInvalidPageToken = errors.New("invalid page_token")
+
+// pageToken es el contenido firmado de next_page_token. Query es una huella de la
+// colección, filtros y orden, para que un token no se pueda reutilizar en otra consulta
+// (ni en otro subdominio, porque el filtro de subdominio forma parte de la huella).
+type pageToken struct {
+	Query  string       `json:"q"`
+	Values []tokenValue `json:"v,omitempty"`
+	ID     string       `json:"id"`
+}
+
+// tokenValue conserva el tipo de los valores que JSON no distingue (fechas).
+type tokenValue struct {
+	Type  string      `json:"t,omitempty"`
+	Value interface{} `json:"v"`
+}
+
+// encodePageToken genera un token opaco y firmado (HMAC-SHA256) que apunta después de cursor.
+func (h *Handler) encodePageToken(collection string, options firebase.QueryOptions, cursor *storage.Cursor) (string, error) {
+	token := pageToken{Query: queryFingerprint(collection, options), ID: cursor.ID}
+	for _, v := range cursor.Values {
+		if t, ok := v.(time.Time); ok {
+			token.Values = append(token.Values, tokenValue{Type: "time", Value: t.Format(time.RFC3339Nano)})
+			continue
+		}
+		token.Values = append(token.Values, tokenValue{Value: v})
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(h.signPageToken(payload)), nil
+}
+
+// decodePageToken valida la firma y la huella del token y devuelve su cursor.
+// Un token vacío significa primera página y devuelve nil.
+func (h *Handler) decodePageToken(raw, collection string, options firebase.QueryOptions) (*storage.Cursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, errInvalidPageToken
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, h.signPageToken(payload)) {
+		return nil, errInvalidPageToken
+	}
+
+	var token pageToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, errInvalidPageToken
+	}
+	if token.Query != queryFingerprint(collection, options) {
+		return nil, errInvalidPageToken
+	}
+
+	cursor := &storage.Cursor{ID: token.ID, Values: make([]interface{}, len(token.Values))}
+	for i, v := range token.Values {
+		cursor.Values[i] = v.Value
+		if v.Type == "time" {
+			s, _ := v.Value.(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, errInvalidPageToken
+			}
+			cursor.Values[i] = t
+		}
+	}
+	return cursor, nil
+}
+
+func (h *Handler) signPageToken(payload []byte) []byte {
+	mac := hmac.New(sha256.New, h.pageTokenKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// queryFingerprint resume lo que define el orden de los resultados; el límite queda
+// fuera para que el cliente pueda cambiar el tamaño de página entre llamadas.
+func queryFingerprint(collection string, options firebase.QueryOptions) string {
+	data, _ := json.Marshal(struct {
+		Collection string      `json:"c"`
+		Filters    interface{} `json:"f"`
+		OrderBy    interface{} `json:"o"`
+	}{collection, options.Filters, options.OrderBy})
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// paginate recorta docs (pedidos con limit+1) a limit y genera el token de la página siguiente.
+func (h *Handler) paginate(collection string, options firebase.QueryOptions, docs []*firebase.Document, limit int) ([]*firebase.Document, string, error) {
+	if len(docs) <= limit {
+		return docs, "", nil
+	}
+	docs = docs[:limit]
+	next, err := h.encodePageToken(collection, options, storage.CursorAfter(docs[len(docs)-1], options))
+	if err != nil {
+		return nil, "", err
+	}
+	return docs, next, nil
+}
@@ -0,0 +1,733 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/auditlog"
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/privacy"
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/trash"
+	"github.com/andrescris/alimedia/pkg/webhooks"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// userExport es el paquete de GET /users/:uid/export: todo lo que la API guarda de un usuario.
+type userExport struct {
+	UID        string                    `json:"uid"`
+	ExportedAt time.Time                 `json:"exported_at"`
+	ExportedBy string                    `json:"exported_by"`
+	User       UserView                  `json:"user"`
+	Profiles   []documentView            `json:"profiles"`
+	Claims     map[string]interface{}    `json:"claims"`
+	Sessions   []exportedSession         `json:"sessions"`
+	Documents  map[string][]documentView `json:"documents"`
+	// UpdatedDocuments son los documentos de otros usuarios que modificó por última vez
+	// (updated_by). Solo se identifican: su contenido no es del usuario.
+	UpdatedDocuments []documentRef `json:"updated_documents"`
+	// Revisions son las revisiones que hizo; las de documentos ajenos, sin sus datos.
+	Revisions []revisionView `json:"revisions"`
+	// AuditEvents son los eventos de auditoría en los que aparece como actor u objetivo;
+	// los estados solo se incluyen si el objetivo es el usuario.
+	AuditEvents []auditlog.Event `json:"audit_events"`
+	// WebhookEvents son los eventos de usuario enviados (o por enviar) a los webhooks.
+	WebhookEvents []webhooks.Payload `json:"webhook_events"`
+}
+
+// documentRef identifica un documento.
+type documentRef struct {
+	Collection string `json:"collection"`
+	DocumentID string `json:"document_id"`
+}
+
+// exportedSession es una sesión del usuario sin su ID, que sigue siendo una credencial.
+type exportedSession struct {
+	Active    bool      `json:"active"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExportUser devuelve todos los datos de un usuario (RGPD, derecho de acceso): el usuario
+// de Auth, sus perfiles, la copia de sus claims, sus sesiones, los documentos que creó o
+// modificó en ?collections= (por defecto, todas las del registro) y sus subcolecciones, sus
+// revisiones, sus eventos de auditoría y los webhooks sobre él. ?format=json (por defecto) o zip.
+func (h *Handler) ExportUser(c *gin.Context) {
+	uid := c.Param("uid")
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format (use json or zip)"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, _, ok := h.loadUser(c, userAccessManage, func() (*identity.User, error) { return h.users.GetUser(ctx, uid) })
+	if !ok {
+		return
+	}
+	collections, ok := h.userCollections(c, false)
+	if !ok {
+		return
+	}
+	fail := func(message string, err error) {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+	}
+
+	bundle := userExport{
+		UID:        uid,
+		ExportedAt: time.Now().UTC(),
+		ExportedBy: c.GetString("uid"),
+		User:       newUserView(user, nil),
+		Sessions:   []exportedSession{},
+		Documents:  map[string][]documentView{},
+
+		UpdatedDocuments: []documentRef{},
+		Revisions:        []revisionView{},
+		AuditEvents:      []auditlog.Event{},
+		WebhookEvents:    []webhooks.Payload{},
+	}
+	profiles, err := h.docs.QueryDocuments(ctx, profilesCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "user_id", Operator: "==", Value: uid}},
+	})
+	if err != nil {
+		fail("Failed to load profiles", err)
+		return
+	}
+	bundle.Profiles = newDocumentViews(profilesCollection, profiles)
+
+	if doc, err := h.docs.GetDocument(ctx, "user_claims", uid); err == nil {
+		bundle.Claims = doc.Data
+	} else if !storage.IsNotFound(err) {
+		fail("Failed to load claims", err)
+		return
+	}
+
+	sessions, err := h.users.ListSessions(ctx, uid)
+	if err != nil {
+		fail("Failed to load sessions", err)
+		return
+	}
+	for _, session := range sessions {
+		bundle.Sessions = append(bundle.Sessions, exportedSession{Active: session.Active, ExpiresAt: session.ExpiresAt})
+	}
+
+	tree, err := h.collectionTree(ctx, collections)
+	if err != nil {
+		fail("Failed to list subcollections", err)
+		return
+	}
+	for i, collection := range tree {
+		docs, err := h.userDocuments(ctx, collection, uid)
+		if err != nil {
+			fail("Failed to load documents in '"+collection+"'", err)
+			return
+		}
+		// Las subcolecciones solo aparecen si tienen documentos del usuario
+		if i < len(collections) || len(docs) > 0 {
+			bundle.Documents[collection] = newDocumentViews(collection, docs)
+		}
+		updated, err := h.queryAll(ctx, collection, firebase.QueryFilter{Field: "updated_by", Operator: "==", Value: uid})
+		if err != nil {
+			fail("Failed to load documents in '"+collection+"'", err)
+			return
+		}
+		for _, doc := range updated {
+			if doc.Data["created_by"] != uid {
+				bundle.UpdatedDocuments = append(bundle.UpdatedDocuments, documentRef{Collection: collection, DocumentID: doc.ID})
+			}
+		}
+	}
+
+	revisions, err := h.userRevisions(ctx, uid, tree, "actor")
+	if err != nil {
+		fail("Failed to load revisions", err)
+		return
+	}
+	for _, doc := range revisions {
+		view := newRevisionView(doc)
+		if view.Data["created_by"] != uid {
+			view.Data = nil
+		}
+		bundle.Revisions = append(bundle.Revisions, view)
+	}
+
+	events, err := h.userAuditEvents(ctx, uid, user.Email)
+	if err != nil {
+		fail("Failed to load audit events", err)
+		return
+	}
+	for _, event := range events {
+		if !auditEventAbout(event, uid, user.Email) {
+			event.Before, event.After = nil, nil
+		}
+		bundle.AuditEvents = append(bundle.AuditEvents, event)
+	}
+
+	deliveries, err := h.hooks.UserDeliveries(ctx, uid)
+	if err != nil {
+		fail("Failed to load webhook deliveries", err)
+		return
+	}
+	// Un evento se entrega una vez por endpoint
+	sent := map[string]bool{}
+	for _, delivery := range deliveries {
+		event, err := delivery.Event()
+		if err != nil || sent[event.ID] {
+			continue
+		}
+		sent[event.ID] = true
+		bundle.WebhookEvents = append(bundle.WebhookEvents, event)
+	}
+	h.recordAudit(c, auditUserExport, uid, nil, map[string]interface{}{
+		"format":      format,
+		"collections": collections,
+	})
+
+	filename := "user-" + uid + "-export." + format
+	c.Header("Content-Disposition", `attachment; filename="`+url.PathEscape(filename)+`"`)
+	if format == "json" {
+		c.JSON(http.StatusOK, bundle)
+		return
+	}
+	archive, err := bundle.zip()
+	if err != nil {
+		fail("Failed to build export archive", err)
+		return
+	}
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// zip empaqueta la exportación con un JSON por sección y uno por colección de documentos.
+func (e userExport) zip() ([]byte, error) {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", gin.H{"uid": e.UID, "exported_at": e.ExportedAt, "exported_by": e.ExportedBy, "user": e.User}},
+		{"profiles.json", e.Profiles},
+		{"claims.json", e.Claims},
+		{"sessions.json", e.Sessions},
+		{"updated_documents.json", e.UpdatedDocuments},
+		{"revisions.json", e.Revisions},
+		{"audit_events.json", e.AuditEvents},
+		{"webhook_events.json", e.WebhookEvents},
+	}
+	for collection, docs := range e.Documents {
+		// La colección puede contener "/" (subcolecciones)
+		files = append(files, struct {
+			name string
+			data interface{}
+		}{"documents/" + url.PathEscape(collection) + ".json", docs})
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// userDocuments devuelve todos los documentos de collection que creó uid.
+func (h *Handler) userDocuments(ctx context.Context, collection, uid string) ([]*firebase.Document, error) {
+	return h.queryAll(ctx, collection, firebase.QueryFilter{Field: "created_by", Operator: "==", Value: uid})
+}
+
+// queryAll devuelve todos los documentos de collection que cumplen filters, leídos por páginas.
+func (h *Handler) queryAll(ctx context.Context, collection string, filters ...firebase.QueryFilter) ([]*firebase.Document, error) {
+	options := firebase.QueryOptions{Filters: filters, Limit: userDocumentsBatch}
+	var all []*firebase.Document
+	var after *storage.Cursor
+	for {
+		docs, err := h.docs.QueryDocumentsAfter(ctx, collection, options, after)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, docs...)
+		if len(docs) < userDocumentsBatch {
+			return all, nil
+		}
+		after = storage.CursorAfter(docs[len(docs)-1], options)
+	}
+}
+
+// EraseUser borra todos los datos de un usuario (RGPD, derecho de supresión) y devuelve un
+// recibo firmado. Con ?mode=delete (por defecto) se borran también los documentos que creó
+// en ?collections= y sus subcolecciones; con ?mode=pseudonymize se conservan con un
+// seudónimo en lugar del UID. En ambos casos se borran sus revisiones, que guardan los
+// datos anteriores. En lo que no es suyo (updated_by y revisiones de documentos ajenos,
+// entregas de webhooks, eventos de auditoría) se sustituyen sus datos; los eventos de
+// auditoría no se pueden borrar sin romper la cadena y el recibo lo declara en retained.
+func (h *Handler) EraseUser(c *gin.Context) {
+	uid := c.Param("uid")
+	mode := c.DefaultQuery("mode", privacy.ModeDelete)
+	if mode != privacy.ModeDelete && mode != privacy.ModePseudonymize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode (use delete or pseudonymize)"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, _, ok := h.loadUser(c, userAccessManage, func() (*identity.User, error) { return h.users.GetUser(ctx, uid) })
+	if !ok {
+		return
+	}
+	collections, ok := h.userCollections(c, true)
+	if !ok {
+		return
+	}
+
+	receipt := privacy.Receipt{
+		ID:          privacy.NewReceiptID(),
+		UID:         uid,
+		Mode:        mode,
+		RequestedBy: c.GetString("uid"),
+		Erased:      privacy.Erased{Documents: map[string]int{}},
+	}
+	if mode == privacy.ModePseudonymize {
+		receipt.Pseudonym = privacy.Pseudonym(h.privacyKey, uid)
+	}
+	fail := func(message string, err error) {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   message,
+			"details": err.Error(),
+			"erased":  receipt.Erased,
+		})
+	}
+
+	// 1. Sesiones
+	revoked, err := h.users.RevokeSessions(ctx, uid)
+	if err != nil {
+		fail("Failed to revoke sessions", err)
+		return
+	}
+	receipt.Erased.SessionsRevoked = revoked
+
+	// 2. Documentos que creó y sus revisiones, también los que están en la papelera, y su
+	// UID en updated_by de los demás, en cada colección y sus subcolecciones
+	tree, err := h.collectionTree(ctx, collections)
+	if err != nil {
+		fail("Failed to list subcollections", err)
+		return
+	}
+	author := receipt.Pseudonym
+	if author == "" {
+		author = deletedUserID
+	}
+	for _, collection := range tree {
+		if err := h.eraseUserDocuments(c, collection, uid, receipt.Pseudonym, &receipt.Erased); err != nil {
+			fail("Failed to erase documents in '"+collection+"'", err)
+			return
+		}
+		if err := h.eraseUserTrash(ctx, collection, uid, receipt.Pseudonym, &receipt.Erased); err != nil {
+			fail("Failed to erase trash of '"+collection+"'", err)
+			return
+		}
+		if err := h.replaceUpdatedBy(c, collection, uid, author, &receipt.Erased); err != nil {
+			fail("Failed to erase updated_by in '"+collection+"'", err)
+			return
+		}
+	}
+
+	// 3. Sus datos en revisiones de documentos ajenos, webhooks y auditoría
+	if err := h.redactUserRevisions(ctx, uid, author, tree, &receipt.Erased); err != nil {
+		fail("Failed to redact revisions", err)
+		return
+	}
+	if err := h.redactUserDeliveries(ctx, uid, &receipt.Erased); err != nil {
+		fail("Failed to redact webhook deliveries", err)
+		return
+	}
+	if err := h.redactUserAuditEvents(c, uid, user.Email, author, &receipt.Erased); err != nil {
+		fail("Failed to redact audit events", err)
+		return
+	}
+	if receipt.Erased.AuditEventsRedacted > 0 {
+		receipt.Retained = append(receipt.Retained, privacy.Retention{
+			Collection: auditlog.Collection,
+			Count:      receipt.Erased.AuditEventsRedacted,
+			Reason:     "the audit hash chain does not allow deleting events: they are kept with the user's data replaced by " + author,
+		})
+	}
+	receipt.Retained = append(receipt.Retained, privacy.Retention{
+		Collection: auditlog.Collection,
+		Count:      1,
+		Reason:     "the user.erase event keeps the uid as proof of this erasure",
+	})
+
+	// 4. Perfiles, claims, credenciales y el usuario de Auth
+	var report userDeletionReport
+	message, err := h.purgeUserAccount(ctx, uid, &report)
+	receipt.Erased.ProfilesDeleted = report.ProfilesDeleted
+	receipt.Erased.ClaimsDeleted = report.ClaimsDeleted
+	receipt.Erased.CredentialsDeleted = report.CredentialsDeleted
+	receipt.Erased.UserDeleted = report.UserDeleted
+	if err != nil {
+		fail(message, err)
+		return
+	}
+
+	receipt.CompletedAt = time.Now().UTC()
+	receipt.Sign(h.privacyKey)
+	// La auditoría no guarda datos del usuario: el recibo basta para acreditar el borrado
+	h.recordAudit(c, auditUserErase, uid, nil, map[string]interface{}{
+		"receipt_id": receipt.ID,
+		"mode":       mode,
+	})
+	subdomains := claimSubdomains(user.CustomClaims)
+	if len(subdomains) == 0 {
+		subdomains = []string{requestSubdomain(c)}
+	}
+	for _, subdomain := range subdomains {
+		h.enqueueWebhook(c, webhooks.UserDeleted, subdomain, map[string]interface{}{"uid": uid, "erased": true})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "User data erased successfully",
+		"receipt": receipt,
+	})
+}
+
+// eraseUserDocuments borra (o, con pseudonym, seudonimiza) los documentos de collection
+// que creó uid y borra sus revisiones. Los cambios llegan a streams y webhooks sin los
+// datos del documento, que es justo lo que se está borrando.
+func (h *Handler) eraseUserDocuments(c *gin.Context, collection, uid, pseudonym string, erased *privacy.Erased) error {
+	ctx := c.Request.Context()
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "created_by", Operator: "==", Value: uid}},
+		Limit:   userDocumentsBatch,
+	}
+	for {
+		// Cada documento tratado deja de cumplir el filtro, así que se consulta desde el principio
+		docs, err := h.docs.QueryDocuments(ctx, collection, options)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			notice := map[string]interface{}{"subdomain": doc.Data["subdomain"]}
+			if pseudonym == "" {
+				err = h.docs.DeleteDocumentIfMatch(ctx, collection, doc.ID, storage.DocumentVersion(doc))
+			} else {
+				update := map[string]interface{}{"created_by": pseudonym}
+				if doc.Data["updated_by"] == uid {
+					update["updated_by"] = pseudonym
+				}
+				err = h.docs.UpdateDocumentIfMatch(ctx, collection, doc.ID, update, storage.DocumentVersion(doc))
+			}
+			if err != nil {
+				return err
+			}
+			deleted, err := h.deleteRevisions(ctx, collection, doc.ID)
+			erased.RevisionsDeleted += deleted
+			if err != nil {
+				return err
+			}
+			erased.Documents[collection]++
+
+			if pseudonym == "" {
+				h.publishChange(c, changefeed.Deleted, collection, doc.ID, notice)
+			} else {
+				h.publishChange(c, changefeed.Updated, collection, doc.ID, notice)
+			}
+		}
+		if len(docs) < userDocumentsBatch {
+			return nil
+		}
+	}
+}
+
+// eraseUserTrash borra (o seudonimiza) las entradas de la papelera de collection que creó
+// uid y las revisiones de sus documentos.
+func (h *Handler) eraseUserTrash(ctx context.Context, collection, uid, pseudonym string, erased *privacy.Erased) error {
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{
+			{Field: "collection", Operator: "==", Value: collection},
+			{Field: "data.created_by", Operator: "==", Value: uid},
+		},
+		Limit: userDocumentsBatch,
+	}
+	for {
+		docs, err := h.docs.QueryDocuments(ctx, trash.Collection, options)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			entry := trash.FromDocument(doc)
+			if pseudonym == "" {
+				err = h.docs.DeleteDocument(ctx, trash.Collection, doc.ID)
+			} else {
+				data := maps.Clone(entry.Data)
+				data["created_by"] = pseudonym
+				if data["updated_by"] == uid {
+					data["updated_by"] = pseudonym
+				}
+				err = h.docs.UpdateDocument(ctx, trash.Collection, doc.ID, map[string]interface{}{"data": data})
+			}
+			if err != nil {
+				return err
+			}
+			deleted, err := h.deleteRevisions(ctx, collection, entry.DocumentID)
+			erased.RevisionsDeleted += deleted
+			if err != nil {
+				return err
+			}
+			erased.TrashDeleted++
+		}
+		if len(docs) < userDocumentsBatch {
+			return nil
+		}
+	}
+}
+
+// replaceUpdatedBy sustituye uid por author en updated_by de los documentos de collection
+// que creó otro usuario, y en los de su papelera. No se guarda revisión: tendría el UID.
+func (h *Handler) replaceUpdatedBy(c *gin.Context, collection, uid, author string, erased *privacy.Erased) error {
+	ctx := c.Request.Context()
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "updated_by", Operator: "==", Value: uid}},
+		Limit:   userDocumentsBatch,
+	}
+	for {
+		// Cada documento tratado deja de cumplir el filtro, así que se consulta desde el principio
+		docs, err := h.docs.QueryDocuments(ctx, collection, options)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			update := map[string]interface{}{"updated_by": author}
+			if err := h.docs.UpdateDocumentIfMatch(ctx, collection, doc.ID, update, storage.DocumentVersion(doc)); err != nil {
+				return err
+			}
+			erased.UpdatedByReplaced++
+			h.publishChange(c, changefeed.Updated, collection, doc.ID, map[string]interface{}{"subdomain": doc.Data["subdomain"]})
+		}
+		if len(docs) < userDocumentsBatch {
+			break
+		}
+	}
+
+	entries, err := h.queryAll(ctx, trash.Collection,
+		firebase.QueryFilter{Field: "collection", Operator: "==", Value: collection},
+		firebase.QueryFilter{Field: "data.updated_by", Operator: "==", Value: uid})
+	if err != nil {
+		return err
+	}
+	for _, doc := range entries {
+		data := maps.Clone(trash.FromDocument(doc).Data)
+		data["updated_by"] = author
+		if err := h.docs.UpdateDocument(ctx, trash.Collection, doc.ID, map[string]interface{}{"data": data}); err != nil {
+			return err
+		}
+		erased.UpdatedByReplaced++
+	}
+	return nil
+}
+
+// collectionTree devuelve collections seguidas de todas sus subcolecciones, a cualquier
+// profundidad. Las subcolecciones no se pueden consultar sin conocer su documento, así que
+// se recorren todos los documentos, también los de la papelera: en Firestore las
+// subcolecciones sobreviven a su documento.
+func (h *Handler) collectionTree(ctx context.Context, collections []string) ([]string, error) {
+	tree := slices.Clone(collections)
+	for i := 0; i < len(tree); i++ {
+		collection := tree[i]
+		docs, err := h.queryAll(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := h.queryAll(ctx, trash.Collection, firebase.QueryFilter{Field: "collection", Operator: "==", Value: collection})
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0, len(docs)+len(entries))
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		for _, doc := range entries {
+			ids = append(ids, trash.FromDocument(doc).DocumentID)
+		}
+		slices.Sort(ids)
+		for _, id := range slices.Compact(ids) {
+			subcollections, err := h.docs.ListSubcollections(ctx, collection, id)
+			if err != nil {
+				return nil, err
+			}
+			for _, sub := range subcollections {
+				tree = append(tree, collection+"/"+id+"/"+sub)
+			}
+		}
+	}
+	return tree, nil
+}
+
+// userRevisions devuelve las revisiones de documentos de tree en las que alguno de fields
+// ("actor", "data.updated_by"...) es uid.
+func (h *Handler) userRevisions(ctx context.Context, uid string, tree []string, fields ...string) ([]*firebase.Document, error) {
+	seen := map[string]bool{}
+	var found []*firebase.Document
+	for _, field := range fields {
+		docs, err := h.queryAll(ctx, revisionsCollection, firebase.QueryFilter{Field: field, Operator: "==", Value: uid})
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			collection, _ := doc.Data["collection"].(string)
+			if !seen[doc.ID] && slices.Contains(tree, collection) {
+				seen[doc.ID] = true
+				found = append(found, doc)
+			}
+		}
+	}
+	return found, nil
+}
+
+// redactUserRevisions sustituye uid por author como actor y en updated_by de las revisiones
+// que quedan en tree; las de sus propios documentos ya se borraron.
+func (h *Handler) redactUserRevisions(ctx context.Context, uid, author string, tree []string, erased *privacy.Erased) error {
+	docs, err := h.userRevisions(ctx, uid, tree, "actor", "data.updated_by")
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		update := map[string]interface{}{}
+		if doc.Data["actor"] == uid {
+			update["actor"] = author
+		}
+		if data, ok := doc.Data["data"].(map[string]interface{}); ok && data["updated_by"] == uid {
+			data = maps.Clone(data)
+			data["updated_by"] = author
+			update["data"] = data
+		}
+		if err := h.docs.UpdateDocument(ctx, revisionsCollection, doc.ID, update); err != nil {
+			return err
+		}
+		erased.RevisionsRedacted++
+	}
+	return nil
+}
+
+// redactUserDeliveries deja solo el UID en las entregas de webhooks de user.created y
+// user.deleted sobre uid, que guardan su email y su nombre.
+func (h *Handler) redactUserDeliveries(ctx context.Context, uid string, erased *privacy.Erased) error {
+	deliveries, err := h.hooks.UserDeliveries(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if err := h.hooks.RedactDelivery(ctx, delivery, map[string]interface{}{"uid": uid, "erased": true}); err != nil {
+			return err
+		}
+		erased.WebhookDeliveriesRedacted++
+	}
+	return nil
+}
+
+// userAuditEvents devuelve, ordenados por Seq, los eventos de auditoría con uid como actor
+// o con uid o email como objetivo (los auth.login_failed antiguos guardaban el email). Los
+// eventos auditlog.ActionRedact no se cuentan: declaran redacciones y no se pueden redactar.
+func (h *Handler) userAuditEvents(ctx context.Context, uid, email string) ([]auditlog.Event, error) {
+	filters := []firebase.QueryFilter{
+		{Field: "actor", Operator: "==", Value: uid},
+		{Field: "target", Operator: "==", Value: uid},
+	}
+	if email != "" {
+		filters = append(filters, firebase.QueryFilter{Field: "target", Operator: "==", Value: email})
+	}
+	seen := map[string]bool{}
+	var events []auditlog.Event
+	for _, filter := range filters {
+		docs, err := h.queryAll(ctx, auditlog.Collection, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			event := auditlog.FromDocument(doc)
+			if !seen[event.ID] && event.Action != auditlog.ActionRedact {
+				seen[event.ID] = true
+				events = append(events, event)
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events, nil
+}
+
+// auditEventAbout indica si el objetivo de event es el usuario: entonces sus estados
+// (before y after) también son datos del usuario.
+func auditEventAbout(event auditlog.Event, uid, email string) bool {
+	return event.Target == uid || (email != "" && event.Target == email)
+}
+
+// redactUserAuditEvents sustituye por author el actor y el objetivo que eran el usuario, y
+// quita la IP y, si el evento trata de él, los estados. Los eventos se conservan: borrarlos
+// rompería la cadena de auditoría.
+func (h *Handler) redactUserAuditEvents(c *gin.Context, uid, email, author string, erased *privacy.Erased) error {
+	ctx := c.Request.Context()
+	events, err := h.userAuditEvents(ctx, uid, email)
+	if err != nil {
+		return err
+	}
+	for i, event := range events {
+		if auditEventAbout(event, uid, email) {
+			event.Target, event.IP, event.Before, event.After = author, "", nil, nil
+		}
+		if event.Actor == uid {
+			event.Actor, event.IP = author, ""
+		}
+		events[i] = event
+	}
+	err = h.audit.Redact(ctx, events, auditlog.Event{
+		Actor:     c.GetString("uid"),
+		Subdomain: requestSubdomain(c),
+		IP:        c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+	})
+	if err != nil {
+		return err
+	}
+	erased.AuditEventsRedacted = len(events)
+	return nil
+}
+
+// deleteRevisions borra el historial de collection/docID y devuelve cuántas revisiones borró.
+func (h *Handler) deleteRevisions(ctx context.Context, collection, docID string) (int, error) {
+	return revisions.Delete(ctx, h.docs, collection, docID, time.Time{})
+}
+
+// VerifyErasureReceipt comprueba que un recibo de EraseUser lo firmó este servidor y no se ha modificado.
+func (h *Handler) VerifyErasureReceipt(c *gin.Context) {
+	var receipt privacy.Receipt
+	if err := c.ShouldBindJSON(&receipt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": receipt.Verify(h.privacyKey)})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// fieldChange describe una diferencia entre dos versiones de un documento.
+// Path usa la notación con puntos de los filtros (p. ej. "address.city").
+type fieldChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added, removed o changed
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// diffData compara from con to recorriendo los mapas anidados. El resultado está ordenado por Path.
+func diffData(from, to map[string]interface{}) []fieldChange {
+	changes := []fieldChange{}
+	diffInto(&changes, "", from, to)
+	return changes
+}
+
+func diffInto(changes *[]fieldChange, prefix string, from, to map[string]interface{}) {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := prefix + key
+		before, inFrom := from[key]
+		after, inTo := to[key]
+		switch {
+		case !inTo:
+			*changes = append(*changes, fieldChange{Path: path, Op: "removed", From: before})
+		case !inFrom:
+			*changes = append(*changes, fieldChange{Path: path, Op: "added", To: after})
+		default:
+			beforeMap, okBefore := before.(map[string]interface{})
+			afterMap, okAfter := after.(map[string]interface{})
+			if okBefore && okAfter {
+				diffInto(changes, path+".", beforeMap, afterMap)
+				continue
+			}
+			if !sameJSON(before, after) {
+				*changes = append(*changes, fieldChange{Path: path, Op: "changed", From: before, To: after})
+			}
+		}
+	}
+}
+
+// sameJSON compara por su representación JSON, para que 3 (int64 del backend) y 3.0
+// (float64 del cuerpo de la petición) se consideren iguales.
+func sameJSON(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// revisionsCollection guarda el historial de todas las colecciones. Cada revisión es el
+// estado del documento ANTES de una escritura (vacío en la creación), con quién y cuándo la hizo.
+const revisionsCollection = revisions.Collection
+
+// Operaciones registradas en el historial
+const (
+	revisionCreate   = "create"
+	revisionUpdate   = "update"
+	revisionDelete   = "delete"
+	revisionRestore  = "restore"
+	revisionUndelete = "undelete"
+)
+
+// revisionView es la representación pública de una revisión.
+type revisionView struct {
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	DocumentID string                 `json:"document_id"`
+	Operation  string                 `json:"operation"`
+	Data       map[string]interface{} `json:"data"`
+	Actor      string                 `json:"actor"`
+	Subdomain  string                 `json:"subdomain,omitempty"`
+	CreatedAt  *time.Time             `json:"created_at,omitempty"`
+}
+
+func newRevisionView(doc *firebase.Document) revisionView {
+	view := revisionView{ID: doc.ID}
+	view.Collection, _ = doc.Data["collection"].(string)
+	view.DocumentID, _ = doc.Data["document_id"].(string)
+	view.Operation, _ = doc.Data["operation"].(string)
+	view.Data, _ = doc.Data["data"].(map[string]interface{})
+	view.Actor, _ = doc.Data["actor"].(string)
+	view.Subdomain, _ = doc.Data["subdomain"].(string)
+	if t, ok := doc.Data["created_at"].(time.Time); ok {
+		view.CreatedAt = &t
+	}
+	return view
+}
+
+// recordRevision guarda previous como revisión de collection/docID y devuelve su ID.
+// La revisión lleva el subdominio del documento (o el de la sesión si no tiene) para
+// que el historial quede aislado por tenant igual que los documentos.
+func (h *Handler) recordRevision(c *gin.Context, collection, docID, operation string, previous map[string]interface{}) (string, error) {
+	return h.docs.CreateDocument(c.Request.Context(), revisionsCollection, revisionData(c, collection, docID, operation, previous))
+}
+
+// revisionData son los datos de la revisión que guarda recordRevision.
+func revisionData(c *gin.Context, collection, docID, operation string, previous map[string]interface{}) map[string]interface{} {
+	subdomain, ok := previous["subdomain"].(string)
+	if !ok {
+		subdomain = c.GetString("subdomain")
+	}
+	return map[string]interface{}{
+		"collection":  collection,
+		"document_id": docID,
+		"operation":   operation,
+		"data":        previous,
+		"actor":       c.GetString("uid"),
+		"subdomain":   subdomain,
+		"created_at":  time.Now().UTC(),
+	}
+}
+
+// discardRevision elimina una revisión cuya escritura no llegó a aplicarse.
+func (h *Handler) discardRevision(c *gin.Context, revisionID string) {
+	if err := h.docs.DeleteDocument(c.Request.Context(), revisionsCollection, revisionID); err != nil {
+		log.Printf("⚠️ No se pudo descartar la revisión %s: %v", revisionID, err)
+	}
+}
+
+// revisionFailed responde 500 cuando no se pudo registrar la revisión; la escritura no se aplica.
+func revisionFailed(c *gin.Context, err error) {
+	if middleware.AbortIfContextDone(c, err) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   "Failed to record document revision",
+		"details": err.Error(),
+	})
+}
+
+// getRevision carga la revisión :rev de collection/docID comprobando el subdominio.
+// Si algo falla ya respondió y devuelve nil.
+func (h *Handler) getRevision(c *gin.Context, collection, docID, revisionID string) *revisionView {
+	doc, err := h.docs.GetDocument(c.Request.Context(), revisionsCollection, revisionID)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return nil
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found", "revision_id": revisionID})
+		return nil
+	}
+	view := newRevisionView(doc)
+	// Una revisión de otro documento se trata como inexistente
+	if view.Collection != collection || view.DocumentID != docID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found", "revision_id": revisionID})
+		return nil
+	}
+	if !canAccessSubdomain(c, doc.Data) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para ver esta revisión"})
+		return nil
+	}
+	return &view
+}
+
+// ListRevisions lista el historial de un documento, del más reciente al más antiguo,
+// paginado con ?limit= y ?page_token=
+func (h *Handler) ListRevisions(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{
+			{Field: "collection", Operator: "==", Value: collection},
+			{Field: "document_id", Operator: "==", Value: docID},
+		},
+		OrderBy: []firebase.OrderBy{{Field: "created_at", Direction: "desc"}},
+	}
+	// SEGURIDAD: los usuarios normales solo ven revisiones de su subdominio
+	if userSubdomain, exists := c.Get("subdomain"); exists && !isAdmin(c) && tenantScoped(c) {
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "subdomain", Operator: "==", Value: userSubdomain.(string)})
+	}
+
+	limit := h.pageSize(c)
+	after, err := h.decodePageToken(c.Query("page_token"), revisionsCollection, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	query := options
+	query.Limit = limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(c.Request.Context(), revisionsCollection, query, after)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list revisions",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	docs, nextToken, err := h.paginate(revisionsCollection, options, docs, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	revisions := make([]revisionView, len(docs))
+	for i, doc := range docs {
+		revisions[i] = newRevisionView(doc)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"revisions":       revisions,
+		"count":           len(revisions),
+		"collection":      collection,
+		"document_id":     docID,
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
+	})
+}
+
+// GetRevision devuelve una revisión concreta de un documento
+func (h *Handler) GetRevision(c *gin.Context) {
+	revision := h.getRevision(c, c.Param("collection"), c.Param("id"), c.Param("rev"))
+	if revision == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"revision": revision,
+	})
+}
+
+// DiffRevisions compara dos revisiones: ?from=<rev>&to=<rev>. Si falta to se compara
+// con el estado actual del documento.
+func (h *Handler) DiffRevisions(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	fromID := c.Query("from")
+	if fromID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required query parameter: from"})
+		return
+	}
+	from := h.getRevision(c, collection, docID, fromID)
+	if from == nil {
+		return
+	}
+
+	toID := c.DefaultQuery("to", "current")
+	var toData map[string]interface{}
+	if toID == "current" {
+		doc, err := h.docs.GetDocument(c.Request.Context(), collection, docID)
+		if err != nil {
+			if middleware.AbortIfContextDone(c, err) {
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":       "Document not found",
+				"collection":  collection,
+				"document_id": docID,
+			})
+			return
+		}
+		if !canAccessSubdomain(c, doc.Data) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para ver este documento"})
+			return
+		}
+		toData = doc.Data
+	} else {
+		to := h.getRevision(c, collection, docID, toID)
+		if to == nil {
+			return
+		}
+		toData = to.Data
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"collection":  collection,
+		"document_id": docID,
+		"from":        fromID,
+		"to":          toID,
+		"changes":     diffData(from.Data, toData),
+	})
+}
+
+// RestoreRevision vuelve a dejar el documento exactamente como estaba en la revisión
+// (reemplazándolo, o recreándolo si se eliminó). La restauración queda a su vez en el historial.
+func (h *Handler) RestoreRevision(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	revision := h.getRevision(c, collection, docID, c.Param("rev"))
+	if revision == nil {
+		return
+	}
+	if revision.Data == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "La revisión corresponde a la creación del documento y no tiene datos que restaurar",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var previous map[string]interface{}
+	var version string
+	current, err := h.docs.GetDocument(ctx, collection, docID)
+	switch {
+	case err == nil:
+		if !canAccessSubdomain(c, current.Data) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No puedes modificar documentos de otro subdominio"})
+			return
+		}
+		// Concurrencia optimista: con If-Match solo se restaura sobre la versión leída
+		var ok bool
+		if version, ok = checkIfMatch(c, current); !ok {
+			return
+		}
+		previous = current.Data
+	case middleware.AbortIfContextDone(c, err):
+		return
+	case !storage.IsNotFound(err):
+		// Solo recreamos el documento si sabemos que no existe: tras un error de lectura
+		// pisaríamos el actual sin guardarlo en el historial
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read document",
+			"details": err.Error(),
+		})
+		return
+	case c.GetHeader("If-Match") != "":
+		// If-Match exige una versión actual y el documento ya no existe
+		preconditionFailed(c, "")
+		return
+	}
+
+	data := revision.Data
+	// SEGURIDAD: un usuario normal no puede restaurar el documento en otro subdominio
+	// (las colecciones globales no tienen, como en CreateDocument)
+	if userSubdomain, exists := c.Get("subdomain"); exists && !isAdmin(c) && tenantScoped(c) {
+		data["subdomain"] = userSubdomain.(string)
+	}
+
+	// El esquema pudo cambiar desde la revisión: lo restaurado debe cumplir el actual
+	if !h.validateDocument(c, collection, data) {
+		return
+	}
+
+	// Se conserva la creación original y la restauración cuenta como modificación
+	stampUpdated(c, data, time.Now().UTC())
+
+	revisionID, err := h.recordRevision(c, collection, docID, revisionRestore, previous)
+	if err != nil {
+		revisionFailed(c, err)
+		return
+	}
+	if version != "" {
+		err = h.docs.ReplaceDocumentIfMatch(ctx, collection, docID, data, version)
+	} else {
+		err = h.docs.CreateDocumentWithID(ctx, collection, docID, data)
+	}
+	if err != nil {
+		h.discardRevision(c, revisionID)
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			preconditionFailed(c, h.currentETag(c, collection, docID))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to restore document",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	kind := changefeed.Updated
+	if previous == nil {
+		kind = changefeed.Created
+	}
+	h.publishChange(c, kind, collection, docID, data)
+
+	view := documentView{ID: docID, Collection: collection, Data: data}
+	if doc, err := h.docs.GetDocument(ctx, collection, docID); err == nil {
+		view = newDocumentView(collection, doc)
+		c.Header("ETag", documentETag(doc))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Document restored successfully",
+		"revision_id": revision.ID,
+		"document":    view,
+	})
+}
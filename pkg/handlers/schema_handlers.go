@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/schema"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// schemasCollection guarda un JSON Schema por colección. El esquema se guarda como texto
+// JSON porque Firestore no admite todo lo que un esquema puede contener (arrays anidados).
+const schemasCollection = "collection_schemas"
+
+// schemaPattern es la clave del esquema de collection: en una subcolección los IDs de los
+// documentos padre se sustituyen por "*", así que orders/O1/items usa el esquema de
+// orders/*/items, común a los items de todos los pedidos.
+func schemaPattern(collection string) string {
+	segments := strings.Split(collection, "/")
+	for i := 1; i < len(segments); i += 2 {
+		segments[i] = "*"
+	}
+	return strings.Join(segments, "/")
+}
+
+// schemaCollection es la colección de las rutas de esquemas: :collection o, con
+// ?subcollection=items/notes, el patrón de esa subcolección (orders/*/items/*/notes).
+// Si la subcolección no es válida responde 400 y devuelve false.
+func schemaCollection(c *gin.Context) (string, bool) {
+	collection := c.Param("collection")
+	sub := c.Query("subcollection")
+	if sub == "" {
+		return collection, true
+	}
+	for _, segment := range strings.Split(sub, "/") {
+		if segment == "" || segment == "*" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":         "Invalid 'subcollection'",
+				"subcollection": sub,
+			})
+			return "", false
+		}
+		collection += "/*/" + segment
+	}
+	return collection, true
+}
+
+// systemFields son campos que gestiona el servidor y quedan fuera de la validación,
+// para que los esquemas solo describan los datos de la aplicación.
+var systemFields = append([]string{"subdomain"}, auditFields...)
+
+// loadSchema devuelve el esquema de collection (por su schemaPattern), o nil si no tiene.
+// Se busca con una consulta (y no por ID) para distinguir "no existe" de un error del backend.
+func (h *Handler) loadSchema(c *gin.Context, collection string) (*schema.Schema, map[string]interface{}, error) {
+	docs, err := h.docs.QueryDocuments(c.Request.Context(), schemasCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "collection", Operator: "==", Value: schemaPattern(collection)}},
+		Limit:   1,
+	})
+	if err != nil || len(docs) == 0 {
+		return nil, nil, err
+	}
+	raw, _ := docs[0].Data["schema_json"].(string)
+	var definition map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &definition); err != nil {
+		return nil, nil, err
+	}
+	compiled, err := schema.Compile(definition)
+	return compiled, definition, err
+}
+
+// validateDocument comprueba data contra el esquema de la colección. Si no lo cumple
+// responde 422 con los errores por campo y devuelve false.
+func (h *Handler) validateDocument(c *gin.Context, collection string, data map[string]interface{}) bool {
+	errs, err := h.schemaErrors(c, collection, data)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load collection schema",
+			"details": err.Error(),
+		})
+		return false
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "El documento no cumple el esquema de la colección",
+			"collection": collection,
+			"fields":     errs,
+		})
+		return false
+	}
+	return true
+}
+
+// schemaErrors valida data contra el esquema de la colección, sin los campos del sistema.
+// Sin esquema no hay errores.
+func (h *Handler) schemaErrors(c *gin.Context, collection string, data map[string]interface{}) ([]schema.FieldError, error) {
+	compiled, _, err := h.loadSchema(c, collection)
+	if err != nil || compiled == nil {
+		return nil, err
+	}
+
+	appData := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		appData[key] = value
+	}
+	for _, field := range systemFields {
+		delete(appData, field)
+	}
+	return compiled.Validate(appData), nil
+}
+
+// GetCollectionSchema devuelve el JSON Schema registrado para la colección
+func (h *Handler) GetCollectionSchema(c *gin.Context) {
+	collection, ok := schemaCollection(c)
+	if !ok {
+		return
+	}
+
+	compiled, definition, err := h.loadSchema(c, collection)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load collection schema",
+			"details": err.Error(),
+		})
+		return
+	}
+	if compiled == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "La colección no tiene esquema",
+			"collection": collection,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"collection": collection,
+		"schema":     definition,
+	})
+}
+
+// SetCollectionSchema registra o reemplaza el JSON Schema de una colección (solo admins).
+// Los documentos existentes no se revalidan; el esquema se aplica a las siguientes escrituras.
+func (h *Handler) SetCollectionSchema(c *gin.Context) {
+	collection, ok := schemaCollection(c)
+	if !ok {
+		return
+	}
+
+	var definition map[string]interface{}
+	if err := c.ShouldBindJSON(&definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+		return
+	}
+	if _, err := schema.Compile(definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid or unsupported JSON Schema",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	raw, err := json.Marshal(definition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON Schema",
+			"details": err.Error(),
+		})
+		return
+	}
+	err = h.docs.CreateDocumentWithID(c.Request.Context(), schemasCollection, url.PathEscape(collection), map[string]interface{}{
+		"collection":  collection,
+		"schema_json": string(raw),
+		"updated_at":  time.Now().UTC(),
+		"updated_by":  c.GetString("uid"),
+	})
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save collection schema",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"message":    "Schema saved successfully",
+		"collection": collection,
+		"schema":     definition,
+	})
+}
+
+// DeleteCollectionSchema elimina el esquema de una colección (solo admins)
+func (h *Handler) DeleteCollectionSchema(c *gin.Context) {
+	collection, ok := schemaCollection(c)
+	if !ok {
+		return
+	}
+
+	if err := h.docs.DeleteDocument(c.Request.Context(), schemasCollection, url.PathEscape(collection)); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":      "La colección no tiene esquema",
+				"collection": collection,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete collection schema",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"message":    "Schema deleted successfully",
+		"collection": collection,
+	})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// streamReset es el evento que recibe un cliente cuyo Last-Event-ID ya no se puede
+// reanudar (el servidor se reinició o el cambio salió del buffer): debe recargar la
+// colección con ListDocuments y seguir escuchando.
+const streamReset = "reset"
+
+// publishChange avisa a los streams y a los webhooks del subdominio de un cambio ya
+// aplicado en collection/docID.
+func (h *Handler) publishChange(c *gin.Context, kind, collection, docID string, data map[string]interface{}) {
+	subdomain, _ := data["subdomain"].(string)
+	e := h.changes.Publish(changefeed.Event{
+		Type:       kind,
+		Collection: collection,
+		DocumentID: docID,
+		Subdomain:  subdomain,
+		Data:       data,
+		Actor:      c.GetString("uid"),
+	})
+	h.enqueueWebhook(c, "document."+kind, subdomain, map[string]interface{}{
+		"collection":  e.Collection,
+		"document_id": e.DocumentID,
+		"data":        e.Data,
+		"actor":       e.Actor,
+	})
+}
+
+// CloseStreams cierra los streams abiertos para que el apagado no espere por ellos.
+func (h *Handler) CloseStreams() {
+	h.changes.Close()
+}
+
+// StreamDocuments emite como Server-Sent Events los cambios (created, updated, deleted) de
+// la colección. Los usuarios normales solo reciben los de su subdominio, igual que en
+// ListDocuments; los admins los de todos. ?filters= acota los eventos con filtros como los
+// de QueryDocuments (JSON), evaluados sobre el estado tras el cambio. Con Last-Event-ID
+// (o ?last_event_id=) se reciben primero los cambios perdidos desde ese evento.
+func (h *Handler) StreamDocuments(c *gin.Context) {
+	collection := c.Param("collection")
+
+	userSubdomain, exists := c.Get("subdomain")
+	if !exists {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "No se pudo determinar el subdominio del usuario",
+		})
+		return
+	}
+
+	var filters []firebase.QueryFilter
+	if raw := c.Query("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid 'filters': must be a JSON array of {field, operator, value}",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	if err := normalizeAuditFilters(filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid filter value",
+			"details": err.Error(),
+		})
+		return
+	}
+	for _, filter := range filters {
+		if _, err := storage.MatchFilter(nil, filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid filter",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	// SEGURIDAD: mismo filtro por subdominio que ListDocuments
+	if !isAdmin(c) && tenantScoped(c) {
+		filters = append(filters, firebase.QueryFilter{Field: "subdomain", Operator: "==", Value: userSubdomain.(string)})
+	}
+	matches := func(e changefeed.Event) bool {
+		if e.Collection != collection {
+			return false
+		}
+		for _, filter := range filters {
+			if ok, _ := storage.MatchFilter(e.Data, filter); !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	sub, backlog, complete := h.changes.Subscribe(lastEventID)
+	defer sub.Close()
+
+	if isAdmin(c) && tenantScoped(c) {
+		h.recordAudit(c, auditCrossTenantStream, collection, nil, map[string]interface{}{
+			"route":   c.FullPath(),
+			"filters": c.Query("filters"),
+		})
+	}
+
+	// El stream dura lo que quiera el cliente: sin el write timeout del servidor
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx no debe acumular la respuesta
+	c.Status(http.StatusOK)
+
+	write := func(id, event string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		if id != "" {
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\n", id); err != nil {
+				return false
+			}
+		}
+		if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	// Comentario inicial: el cliente recibe las cabeceras en cuanto está suscrito
+	if _, err := fmt.Fprint(c.Writer, ": connected\n\n"); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	if !complete && !write("", streamReset, gin.H{"collection": collection, "last_event_id": lastEventID}) {
+		return
+	}
+	for _, e := range backlog {
+		if matches(e) && !write(e.ID, e.Type, e) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(h.cfg.Stream.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			// Canal cerrado: el cliente se quedó atrás o el servidor se apaga; al
+			// reconectarse con Last-Event-ID recupera lo que falte
+			if !ok {
+				return
+			}
+			if matches(e) && !write(e.ID, e.Type, e) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// subcollectionRoute es una ruta bajo /collections/:collection/documents/:id/collections/
+// ya interpretada. collection es la ruta completa de Firestore (orders/O1/items).
+type subcollectionRoute struct {
+	collection string
+	action     string
+	id         string
+	rev        string
+}
+
+// Acciones de una ruta de subcolección; equivalen a las rutas de documentos de primer nivel
+const (
+	subcollectionDocuments = "documents" // .../:sub/documents
+	subcollectionDocument  = "document"  // .../:sub/documents/:id
+	subcollectionQuery     = "query"     // .../:sub/query
+	subcollectionStream    = "stream"    // .../:sub/stream
+	subcollectionRevisions = "revisions" // .../:sub/documents/:id/revisions
+	subcollectionRevision  = "revision"  // .../:sub/documents/:id/revisions/:rev
+	subcollectionRestore   = "restore"   // .../:sub/documents/:id/revisions/:rev/restore
+	subcollectionDiff      = "diff"      // .../:sub/documents/:id/diff
+)
+
+// parseSubcollectionPath interpreta path (lo que sigue a .../documents/:id/collections/)
+// bajo el documento root/rootID. Las subcolecciones se pueden anidar sin límite:
+// items/documents/I1/collections/notes/documents/N1
+func parseSubcollectionPath(root, rootID, path string) (subcollectionRoute, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	collection := root + "/" + rootID
+	for {
+		if len(segments) < 2 || segments[0] == "" {
+			return subcollectionRoute{}, false
+		}
+		collection += "/" + segments[0]
+		route := subcollectionRoute{collection: collection}
+
+		switch {
+		case segments[1] == "query" && len(segments) == 2:
+			route.action = subcollectionQuery
+			return route, true
+		case segments[1] == "stream" && len(segments) == 2:
+			route.action = subcollectionStream
+			return route, true
+		case segments[1] != "documents":
+			return subcollectionRoute{}, false
+		case len(segments) == 2:
+			route.action = subcollectionDocuments
+			return route, true
+		}
+
+		route.id = segments[2]
+		tail := segments[3:]
+		if route.id == "" {
+			return subcollectionRoute{}, false
+		}
+		switch {
+		case len(tail) == 0:
+			route.action = subcollectionDocument
+		case tail[0] == "collections":
+			collection += "/" + route.id
+			segments = tail[1:]
+			continue
+		case tail[0] == "diff" && len(tail) == 1:
+			route.action = subcollectionDiff
+		case tail[0] == "revisions" && len(tail) == 1:
+			route.action = subcollectionRevisions
+		case tail[0] == "revisions" && len(tail) == 2 && tail[1] != "":
+			route.action, route.rev = subcollectionRevision, tail[1]
+		case tail[0] == "revisions" && len(tail) == 3 && tail[1] != "" && tail[2] == "restore":
+			route.action, route.rev = subcollectionRestore, tail[1]
+		default:
+			return subcollectionRoute{}, false
+		}
+		return route, true
+	}
+}
+
+// subcollectionHandler devuelve el handler de primer nivel que atiende action con method
+// y si modifica documentos; nil si la combinación no existe.
+func (h *Handler) subcollectionHandler(method, action string) (gin.HandlerFunc, bool) {
+	switch action + " " + method {
+	case "documents GET":
+		return h.ListDocuments, false
+	case "documents POST":
+		return h.CreateDocument, true
+	case "document GET":
+		return h.GetDocument, false
+	case "document PUT":
+		return h.UpdateDocument, true
+	case "document PATCH":
+		return h.PatchDocument, true
+	case "document DELETE":
+		return h.DeleteDocument, true
+	case "query POST":
+		return h.QueryDocuments, false
+	case "stream GET":
+		return h.StreamDocuments, false
+	case "revisions GET":
+		return h.ListRevisions, false
+	case "revision GET":
+		return h.GetRevision, false
+	case "restore POST":
+		return h.RestoreRevision, true
+	case "diff GET":
+		return h.DiffRevisions, false
+	default:
+		return nil, false
+	}
+}
+
+// Subcollection atiende las rutas de subcolecciones con los mismos handlers que las de
+// primer nivel. El registro de colecciones, el ámbito y el tenant se heredan de la
+// colección y del documento raíz: quien no puede acceder a orders/O1 tampoco accede a
+// orders/O1/items ni a nada por debajo.
+func (h *Handler) Subcollection(c *gin.Context) {
+	rootCollection := c.Param("collection")
+	rootID := c.Param("id")
+
+	route, ok := parseSubcollectionPath(rootCollection, rootID, c.Param("path"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found", "path": c.Request.URL.Path})
+		return
+	}
+	handler, write := h.subcollectionHandler(c.Request.Method, route.action)
+	if handler == nil {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed", "path": c.Request.URL.Path})
+		return
+	}
+
+	// SEGURIDAD: el acceso lo decide el documento raíz
+	rootDoc, err := h.docs.GetDocument(c.Request.Context(), rootCollection, rootID)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":       "Document not found",
+			"collection":  rootCollection,
+			"document_id": rootID,
+		})
+		return
+	}
+	if !canAccessSubdomain(c, rootDoc.Data) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para acceder a este documento"})
+		return
+	}
+	if write {
+		value, _ := c.Get("collection_settings")
+		settings, _ := value.(config.CollectionSettings)
+		if reason := middleware.CollectionWriteDenied(settings, isAdmin(c)); reason != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": reason})
+			return
+		}
+	} else {
+		h.auditCrossTenantRead(c, rootCollection, []*firebase.Document{rootDoc})
+	}
+
+	// Los documentos anidados pertenecen al tenant del documento raíz: para un admin que
+	// opera desde otro subdominio, los handlers deben usar el del documento raíz
+	if subdomain, ok := rootDoc.Data["subdomain"].(string); ok && tenantScoped(c) {
+		c.Set("subdomain", subdomain)
+	}
+
+	c.Params = gin.Params{
+		{Key: "collection", Value: route.collection},
+		{Key: "id", Value: route.id},
+		{Key: "rev", Value: route.rev},
+	}
+	handler(c)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/trash"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// trashView es una entrada de la papelera con la fecha en que se purgará.
+type trashView struct {
+	trash.Entry
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+func (h *Handler) newTrashView(entry trash.Entry) trashView {
+	return trashView{Entry: entry, PurgeAt: entry.DeletedAt.Add(h.cfg.Trash.Retention)}
+}
+
+// getTrashEntry carga la entrada :id de la papelera de la colección comprobando el subdominio.
+// Si algo falla ya respondió y devuelve false.
+func (h *Handler) getTrashEntry(c *gin.Context, collection, docID string) (trash.Entry, bool) {
+	entry, err := trash.Get(c.Request.Context(), h.docs, collection, docID)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return entry, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":       "Document not found in trash",
+			"collection":  collection,
+			"document_id": docID,
+		})
+		return entry, false
+	}
+	if !canAccessSubdomain(c, map[string]interface{}{"subdomain": entry.Subdomain}) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para ver este documento"})
+		return entry, false
+	}
+	return entry, true
+}
+
+// ListTrash lista los documentos eliminados de una colección, del más reciente al más
+// antiguo, paginado con ?limit= y ?page_token=
+func (h *Handler) ListTrash(c *gin.Context) {
+	collection := c.Param("collection")
+
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "collection", Operator: "==", Value: collection}},
+		OrderBy: []firebase.OrderBy{{Field: "deleted_at", Direction: "desc"}},
+	}
+	// SEGURIDAD: cada tenant solo ve su propia papelera
+	if userSubdomain, exists := c.Get("subdomain"); exists && !isAdmin(c) && tenantScoped(c) {
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "subdomain", Operator: "==", Value: userSubdomain.(string)})
+	}
+
+	limit := h.pageSize(c)
+	after, err := h.decodePageToken(c.Query("page_token"), trash.Collection, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	query := options
+	query.Limit = limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(c.Request.Context(), trash.Collection, query, after)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list trash",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	docs, nextToken, err := h.paginate(trash.Collection, options, docs, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	entries := make([]trashView, len(docs))
+	for i, doc := range docs {
+		entries[i] = h.newTrashView(trash.FromDocument(doc))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"documents":       entries,
+		"count":           len(entries),
+		"collection":      collection,
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
+	})
+}
+
+// UndeleteDocument devuelve un documento de la papelera a su colección con el mismo ID
+func (h *Handler) UndeleteDocument(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	entry, ok := h.getTrashEntry(c, collection, docID)
+	if !ok {
+		return
+	}
+
+	// No sobreescribimos un documento que se haya vuelto a crear con el mismo ID
+	ctx := c.Request.Context()
+	if _, err := h.docs.GetDocument(ctx, collection, docID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "Ya existe un documento con ese ID en la colección",
+			"collection":  collection,
+			"document_id": docID,
+		})
+		return
+	} else if middleware.AbortIfContextDone(c, err) {
+		return
+	}
+
+	// El esquema pudo cambiar mientras el documento estaba en la papelera
+	if !h.validateDocument(c, collection, entry.Data) {
+		return
+	}
+
+	stampUpdated(c, entry.Data, time.Now().UTC())
+
+	revisionID, err := h.recordRevision(c, collection, docID, revisionUndelete, nil)
+	if err != nil {
+		revisionFailed(c, err)
+		return
+	}
+	if err := h.docs.CreateDocumentWithID(ctx, collection, docID, entry.Data); err != nil {
+		h.discardRevision(c, revisionID)
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to restore document",
+			"details": err.Error(),
+		})
+		return
+	}
+	// El documento ya está restaurado; una entrada huérfana la acabará borrando el purgador
+	if err := trash.Remove(ctx, h.docs, entry.ID); err != nil {
+		log.Printf("⚠️ No se pudo quitar %s/%s de la papelera: %v", collection, docID, err)
+	}
+	h.publishChange(c, changefeed.Created, collection, docID, entry.Data)
+
+	view := documentView{ID: docID, Collection: collection, Data: entry.Data}
+	if doc, err := h.docs.GetDocument(ctx, collection, docID); err == nil {
+		view = newDocumentView(collection, doc)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Document restored from trash",
+		"document": view,
+	})
+}
+
+// PurgeTrashDocument elimina definitivamente un documento de la papelera, con su historial (solo admins)
+func (h *Handler) PurgeTrashDocument(c *gin.Context) {
+	collection := c.Param("collection")
+	docID := c.Param("id")
+
+	entry, ok := h.getTrashEntry(c, collection, docID)
+	if !ok {
+		return
+	}
+	if err := trash.Discard(c.Request.Context(), h.docs, entry); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to purge document",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "Document permanently deleted",
+		"collection":  collection,
+		"document_id": docID,
+	})
+}
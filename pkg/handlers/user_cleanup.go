@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/changefeed"
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// deletedUserID es el autor que queda en created_by al anonimizar los documentos de un
+// usuario eliminado.
+const deletedUserID = "deleted-user"
+
+// userDocumentsBatch es el número de documentos que se reescriben por consulta.
+const userDocumentsBatch = 100
+
+// Qué hacer al eliminar un usuario con los documentos que creó (?documents=).
+const (
+	userDocumentsKeep      = "keep"
+	userDocumentsReassign  = "reassign"
+	userDocumentsAnonymize = "anonymize"
+)
+
+// userDeletion son las opciones de DELETE /users/:uid.
+type userDeletion struct {
+	documents   string
+	author      string // el nuevo created_by si documents no es keep
+	collections []string
+}
+
+// userDeletionReport es lo que eliminó (o reescribió) DELETE /users/:uid. Si el borrado
+// falla a medias, refleja lo que llegó a hacerse.
+type userDeletionReport struct {
+	SessionsRevoked    int    `json:"sessions_revoked"`
+	ProfilesDeleted    int    `json:"profiles_deleted"`
+	ClaimsDeleted      bool   `json:"claims_deleted"`
+	CredentialsDeleted bool   `json:"credentials_deleted"`
+	UserDeleted        bool   `json:"user_deleted"`
+	Documents          string `json:"documents"`
+	// DocumentsUpdated es cuántos documentos se reasignaron o anonimizaron por colección.
+	DocumentsUpdated map[string]int `json:"documents_updated,omitempty"`
+	ReassignedTo     string         `json:"reassigned_to,omitempty"`
+}
+
+// userDeletionOptions lee ?documents=keep|reassign|anonymize, ?reassign_to= y
+// ?collections= (por defecto, las colecciones del registro que la sesión puede escribir).
+// Responde 400 o 403 si no son válidas.
+func (h *Handler) userDeletionOptions(c *gin.Context, user *identity.User) (userDeletion, bool) {
+	opts := userDeletion{documents: c.DefaultQuery("documents", userDocumentsKeep)}
+	badRequest := func(message string) (userDeletion, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return userDeletion{}, false
+	}
+
+	switch opts.documents {
+	case userDocumentsKeep:
+		return opts, true
+	case userDocumentsAnonymize:
+		opts.author = deletedUserID
+	case userDocumentsReassign:
+		target := c.Query("reassign_to")
+		if target == "" {
+			return badRequest("'reassign_to' is required with documents=reassign")
+		}
+		if target == user.UID {
+			return badRequest("'reassign_to' must be a different user")
+		}
+		// Solo se reasigna a usuarios visibles para la sesión
+		ctx := c.Request.Context()
+		other, err := h.users.GetUser(ctx, target)
+		if middleware.AbortIfContextDone(c, err) {
+			return userDeletion{}, false
+		}
+		if err != nil || userAccessFor(c, other) == userAccessNone {
+			return badRequest("'reassign_to' user not found")
+		}
+		opts.author = target
+	default:
+		return badRequest("Invalid 'documents' (use keep, reassign or anonymize)")
+	}
+
+	collections, ok := h.userCollections(c, true)
+	if !ok {
+		return userDeletion{}, false
+	}
+	opts.collections = collections
+	return opts, true
+}
+
+// userCollections lee ?collections=: las colecciones en las que buscar los documentos que
+// creó un usuario. Si write, por defecto son las del registro que la sesión puede escribir
+// y todas deben poder escribirse; si no, todas las del registro. Responde 400 o 403 si no
+// son válidas.
+func (h *Handler) userCollections(c *gin.Context, write bool) ([]string, bool) {
+	badRequest := func(message string) ([]string, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return nil, false
+	}
+	var collections []string
+	if raw := c.Query("collections"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				collections = append(collections, name)
+			}
+		}
+	} else {
+		for _, name := range slices.Sorted(maps.Keys(h.cfg.Collections.Registry)) {
+			settings, ok := h.cfg.Collections.Lookup(name)
+			if ok && (!write || middleware.CollectionWriteDenied(settings, isAdmin(c)) == "") {
+				collections = append(collections, name)
+			}
+		}
+	}
+	if len(collections) == 0 {
+		return badRequest("No collections to search: pass ?collections= (collections.registry is empty)")
+	}
+	for _, name := range collections {
+		settings, ok := h.cfg.Collections.Lookup(name)
+		if !ok {
+			return badRequest("Collection '" + name + "' not found")
+		}
+		if reason := middleware.CollectionWriteDenied(settings, isAdmin(c)); write && reason != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": reason, "collection": name})
+			return nil, false
+		}
+	}
+	return collections, true
+}
+
+// rewriteCreatedBy cambia created_by de uid a author en los documentos de collection, con
+// su revisión y su aviso a streams y webhooks como cualquier actualización. Un tenant
+// admin solo reescribe los documentos de sus subdominios. Devuelve cuántos cambió.
+func (h *Handler) rewriteCreatedBy(c *gin.Context, collection, uid, author string) (int, error) {
+	settings, _ := h.cfg.Collections.Lookup(collection)
+	filters := []firebase.QueryFilter{{Field: "created_by", Operator: "==", Value: uid}}
+	if !isAdmin(c) && settings.Scope == config.ScopeTenant {
+		claims, _ := c.Get("claims")
+		claimsMap, _ := claims.(map[string]interface{})
+		subdomains := []interface{}{}
+		for _, subdomain := range claimSubdomains(claimsMap) {
+			subdomains = append(subdomains, subdomain)
+		}
+		filters = append(filters, firebase.QueryFilter{Field: "subdomain", Operator: "in", Value: subdomains})
+	}
+
+	ctx := c.Request.Context()
+	updated := 0
+	for {
+		// Cada documento reescrito deja de cumplir el filtro, así que se consulta desde el principio
+		docs, err := h.docs.QueryDocuments(ctx, collection, firebase.QueryOptions{Filters: filters, Limit: userDocumentsBatch})
+		if err != nil {
+			return updated, err
+		}
+		for _, doc := range docs {
+			data := maps.Clone(doc.Data)
+			data["created_by"] = author
+			stampUpdated(c, data, time.Now().UTC())
+			err := h.docs.ApplyBatch(ctx, []storage.BatchWrite{
+				{Op: storage.BatchReplace, Collection: collection, ID: doc.ID, Data: data, Version: storage.DocumentVersion(doc)},
+				{Op: storage.BatchCreate, Collection: revisionsCollection, ID: storage.NewDocumentID(),
+					Data: revisionData(c, collection, doc.ID, revisionUpdate, doc.Data)},
+			})
+			if err != nil {
+				return updated, err
+			}
+			h.publishChange(c, changefeed.Updated, collection, doc.ID, data)
+			updated++
+		}
+		if len(docs) < userDocumentsBatch {
+			return updated, nil
+		}
+	}
+}
+
+// purgeUserAccount borra los perfiles, la copia de los claims, las credenciales y el
+// usuario de Auth de uid, anotándolo en report. El usuario de Auth va el último: mientras
+// exista, repetir el borrado lo completa. Si falla, devuelve también qué paso falló.
+func (h *Handler) purgeUserAccount(ctx context.Context, uid string, report *userDeletionReport) (string, error) {
+	// Perfiles
+	profiles, err := h.docs.QueryDocuments(ctx, profilesCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "user_id", Operator: "==", Value: uid}},
+	})
+	if err != nil {
+		return "Failed to load profiles", err
+	}
+	for _, profile := range profiles {
+		if err := h.docs.DeleteDocument(ctx, profilesCollection, profile.ID); err != nil {
+			return "Failed to delete profile", err
+		}
+		report.ProfilesDeleted++
+	}
+
+	// Copia de los claims en Firestore
+	if _, err := h.docs.GetDocument(ctx, "user_claims", uid); err == nil {
+		if err := h.docs.DeleteDocument(ctx, "user_claims", uid); err != nil {
+			return "Failed to delete claims", err
+		}
+		report.ClaimsDeleted = true
+	} else if !storage.IsNotFound(err) {
+		return "Failed to load claims", err
+	}
+
+	// Credenciales
+	hasCredentials, err := h.users.HasCredentials(ctx, uid)
+	if err == nil && hasCredentials {
+		err = h.users.DeleteCredentials(ctx, uid)
+	}
+	if err != nil {
+		return "Failed to delete credentials", err
+	}
+	report.CredentialsDeleted = hasCredentials
+
+	// El usuario de Auth, el último: mientras exista, repetir el borrado lo completa
+	if err := h.users.DeleteUser(ctx, uid); err != nil {
+		return "Failed to delete user", err
+	}
+	report.UserDeleted = true
+	return "", nil
+}
@@ -5,130 +5,170 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/reconcile"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/webhooks"
 	"github.com/andrescris/firestore/lib/firebase"
-	"github.com/andrescris/firestore/lib/firebase/auth"
-	"github.com/andrescris/firestore/lib/firebase/firestore"
 	"github.com/gin-gonic/gin"
 )
 
-// CreateUser maneja la creación de nuevos usuarios
-func CreateUser(c *gin.Context) {
-    // Leer el cuerpo como JSON genérico
-    var body map[string]interface{}
-    if err := c.ShouldBindJSON(&body); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "error":   "Invalid JSON format",
-            "details": err.Error(),
-        })
-        return
-    }
-
-    // Validar y extraer project_id
-    projectID, ok := body["project_id"].(string)
-    if !ok || projectID == "" {
-        c.JSON(http.StatusBadRequest, gin.H{
-            "error": "Missing or invalid 'project_id'",
-        })
-        return
-    }
-
-    // Extraer los campos necesarios para crear el usuario
-    email, _ := body["email"].(string)
-    password, _ := body["password"].(string)
-    displayName, _ := body["display_name"].(string)
-
-    if email == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
-        return
-    }
-    if password == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
-        return
-    }
-
-    // Construir request para Firebase Auth
-    request := firebase.CreateUserRequest{
-        Email:       email,
-        Password:    password,
-        DisplayName: displayName,
-    }
-
-    ctx := context.Background()
-    // 1. Crear usuario en el servicio de Autenticación de Firebase
-    user, err := auth.CreateUser(ctx, request)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "error":   "Failed to create user in Auth service",
-            "details": err.Error(),
-        })
-        return
-    }
-
-    // === PASO AÑADIDO Y CRUCIAL ===
-    // 2. Guardar el hash de la contraseña en Firestore para que el login funcione
-    err = auth.StoreUserCredentials(ctx, user.UID, password)
-    if err != nil {
-        // Si esto falla, el usuario existe pero no podrá loguearse.
-        // Es importante devolver un error claro.
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "error":   "User created in Auth, but failed to store credentials in Firestore.",
-            "details": err.Error(),
-        })
-        return
-    }
-
-    // 3. Crear perfil en Firestore con project_id
-    profileData := map[string]interface{}{
-        "user_id":      user.UID,
-        "email":        user.Email,
-        "display_name": user.DisplayName,
-        "status":       "active",
-        "role":         "user",
-        "project_id":   projectID,
-    }
-
-    profileID, err := firestore.CreateDocument(ctx, "profiles", profileData)
-    if err != nil {
-        // Esto es menos crítico, por eso solo lo logueamos como advertencia.
-        log.Printf("Warning: Failed to create profile for user %s: %v", user.UID, err)
-    }
-
-    c.JSON(http.StatusCreated, gin.H{
+// CreateUser maneja la creación de nuevos usuarios (admins y tenant admins). Los usuarios
+// que crea un tenant admin quedan asignados al subdominio de la petición.
+func (h *Handler) CreateUser(c *gin.Context) {
+	if !canCreateUsers(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Acceso denegado. Solo los administradores pueden crear usuarios.",
+		})
+		return
+	}
+	fields, ok := h.userFields(c)
+	if !ok {
+		return
+	}
+
+	// Leer el cuerpo como JSON genérico
+	var body map[string]interface{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Validar y extraer project_id
+	projectID, ok := body["project_id"].(string)
+	if !ok || projectID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing or invalid 'project_id'",
+		})
+		return
+	}
+
+	// Extraer los campos necesarios para crear el usuario
+	email, _ := body["email"].(string)
+	password, _ := body["password"].(string)
+	displayName, _ := body["display_name"].(string)
+
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+	if password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+
+	// Construir request para el proveedor de identidad
+	request := identity.UserToCreate{
+		Email:       email,
+		Password:    password,
+		DisplayName: displayName,
+	}
+
+	ctx := c.Request.Context()
+	// El alta es una saga: si un paso falla se deshacen los anteriores, para no dejar
+	// usuarios que no pueden hacer login o que no tienen perfil
+	tx := saga{name: "CreateUser " + email}
+	fail := func(message string, err error) {
+		rollbackErrors := tx.compensate(ctx)
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		response := gin.H{
+			"error":       message,
+			"details":     err.Error(),
+			"rolled_back": len(rollbackErrors) == 0,
+		}
+		if len(rollbackErrors) > 0 {
+			response["rollback_errors"] = rollbackErrors
+		}
+		c.JSON(http.StatusInternalServerError, response)
+	}
+
+	// 1. Crear usuario en el servicio de Autenticación de Firebase. Si falla no hay UID que
+	// compensar; un usuario creado pese al error lo encuentra la reconciliación
+	user, err := h.users.CreateUser(ctx, request)
+	if err != nil {
+		fail("Failed to create user in Auth service", err)
+		return
+	}
+	tx.onRollback("auth user", func(ctx context.Context) error { return h.users.DeleteUser(ctx, user.UID) })
+
+	// 2. Guardar el hash de la contraseña para que el login funcione
+	tx.onRollback("credentials", func(ctx context.Context) error { return h.users.DeleteCredentials(ctx, user.UID) })
+	if err := h.users.StoreCredentials(ctx, user.UID, password); err != nil {
+		fail("Failed to store credentials; the user was not created", err)
+		return
+	}
+
+	// SEGURIDAD: el tenant admin solo crea usuarios de su subdominio; si no quedaran
+	// asignados a él, no podría gestionarlos después
+	if !isAdmin(c) {
+		claims := map[string]interface{}{"subdomain": []interface{}{c.GetString("subdomain")}}
+		// Los claims de Auth desaparecen con el usuario; la copia en Firestore no
+		tx.onRollback("claims", func(ctx context.Context) error { return h.docs.DeleteDocument(ctx, "user_claims", user.UID) })
+		if err := h.users.SetCustomClaims(ctx, user.UID, claims); err != nil {
+			fail("Failed to assign the subdomain; the user was not created", err)
+			return
+		}
+		if err := h.docs.CreateDocumentWithID(ctx, "user_claims", user.UID, map[string]interface{}{"claims": claims}); err != nil {
+			log.Printf("Warning: Failed to sync claims for user %s: %v", user.UID, err)
+		}
+		user.CustomClaims = claims
+	}
+
+	// 3. Crear perfil en Firestore con project_id. El ID se genera aquí para poder
+	// deshacerlo aunque la escritura falle después de aplicarse
+	profileData := map[string]interface{}{
+		"user_id":      user.UID,
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+		"status":       "active",
+		"role":         "user",
+		"project_id":   projectID,
+	}
+	profileID := storage.NewDocumentID()
+	tx.onRollback("profile", func(ctx context.Context) error { return h.docs.DeleteDocument(ctx, profilesCollection, profileID) })
+	if err := h.docs.CreateDocumentWithID(ctx, profilesCollection, profileID, profileData); err != nil {
+		fail("Failed to create user profile; the user was not created", err)
+		return
+	}
+	h.enqueueWebhook(c, webhooks.UserCreated, requestSubdomain(c), map[string]interface{}{
+		"uid":          user.UID,
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+		"project_id":   projectID,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
 		"message": "Usuario y credenciales creados exitosamente",
-		"user": gin.H{
-			"uid":          user.UID,
-			"email":        user.Email,
-			"display_name": user.DisplayName,
-		},
-		"profile": gin.H{
-			"profile_id":  profileID,
-			"project_id":  projectID,
-			"role":        "user",
-			"status":      "active",
-		},
+		"user":    newUserView(user, &firebase.Document{ID: profileID, Data: profileData}).Select(fields),
 	})
 }
 
-// ListUsers maneja la lista de usuarios con paginación 
-func ListUsers(c *gin.Context) {
+// ListUsers maneja la lista de usuarios con paginación
+func (h *Handler) ListUsers(c *gin.Context) {
 	// Parámetros de query opcionales
-	limitStr := c.DefaultQuery("limit", "10")
 	pageToken := c.Query("page_token")
-
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 {
-		limit = 10
-	}
-	if limit > 100 {
-		limit = 100 // Máximo para evitar sobrecarga
+	limit := h.pageSize(c)
+	fields, ok := h.userFields(c)
+	if !ok {
+		return
 	}
 
-	ctx := context.Background()
-	users, nextToken, err := auth.ListUsers(ctx, limit, pageToken)
+	ctx := c.Request.Context()
+	users, nextToken, err := h.users.ListUsers(ctx, limit, pageToken)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to list users",
 			"details": err.Error(),
@@ -136,121 +176,109 @@ func ListUsers(c *gin.Context) {
 		return
 	}
 
-	// Definimos una estructura segura para la respuesta JSON.
-	type UserResponse struct {
-		UID                 string `json:"uid"`
-		Email               string `json:"email"`
-		DisplayName         string `json:"display_name"`
-		Disabled            bool   `json:"disabled"`
-		CreationTimestamp   int64  `json:"creation_timestamp"`
-		LastLogInTimestamp  int64  `json:"last_log_in_timestamp"`
-	}
-
-	// Creamos un slice para las respuestas limpias.
-	responseUsers := make([]UserResponse, 0, len(users))
-
-	// Mapeamos los datos del usuario al formato seguro.
-	for _, user := range users {
-		responseUsers = append(responseUsers, UserResponse{
-			UID:         user.UID,
-			Email:       user.Email,
-			DisplayName: user.DisplayName,
-			Disabled:    user.Disabled,
-			// Convertimos el time.Time a un número (milisegundos Unix) que es seguro para JSON.
-			// Esto funciona incluso con la fecha del año 57522.
-			CreationTimestamp:   user.CreationTime.UnixMilli(),
-			LastLogInTimestamp:  user.LastLogInTime.UnixMilli(),
-		})
-	}
+	views := withLegacyListFields(h.userViews(ctx, users, fields))
 
 	// Enviamos la respuesta final y segura.
 	c.JSON(http.StatusOK, gin.H{
 		"success":         true,
-		"users":           responseUsers,
-		"count":           len(responseUsers),
+		"users":           views,
+		"count":           len(views),
 		"next_page_token": nextToken,
 		"has_more":        nextToken != "",
 	})
 }
 
 // GetUser obtiene un usuario por su UID
-func GetUser(c *gin.Context) {
+func (h *Handler) GetUser(c *gin.Context) {
 	uid := c.Param("uid")
 	if uid == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "UID is required"})
 		return
 	}
+	fields, ok := h.userFields(c)
+	if !ok {
+		return
+	}
 
-	ctx := context.Background()
-	user, err := auth.GetUser(ctx, uid)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-			"uid": uid,
-		})
+	ctx := c.Request.Context()
+	user, _, ok := h.loadUser(c, userAccessSelf, func() (*identity.User, error) { return h.users.GetUser(ctx, uid) })
+	if !ok {
 		return
 	}
 
-	// ### LA CORRECCIÓN ESTÁ AQUÍ ###
-	// En lugar de devolver el objeto 'user' directamente, creamos una respuesta segura.
+	// Nunca devolvemos el objeto del proveedor directamente, sino la vista segura
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"user": gin.H{
-			"uid":                   user.UID,
-			"email":                 user.Email,
-			"email_verified":        user.EmailVerified,
-			"display_name":          user.DisplayName,
-			"photo_url":             user.PhotoURL,
-			"disabled":              user.Disabled,
-			"creation_timestamp":    user.CreationTime.UnixMilli(), // Convertimos la fecha a un número
-			"last_signin_timestamp": user.LastLogInTime.UnixMilli(), // Convertimos la fecha a un número
-			"custom_claims":         user.CustomClaims,
-		},
+		"user":    h.userView(ctx, user, fields),
 	})
 }
 
 // GetUserByEmail obtiene un usuario por su email
-func GetUserByEmail(c *gin.Context) {
+func (h *Handler) GetUserByEmail(c *gin.Context) {
 	email := c.Param("email")
 	if email == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
 		return
 	}
+	fields, ok := h.userFields(c)
+	if !ok {
+		return
+	}
 
-	ctx := context.Background()
-	user, err := auth.GetUserByEmail(ctx, email)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-			"email": email,
-		})
+	ctx := c.Request.Context()
+	user, _, ok := h.loadUser(c, userAccessSelf, func() (*identity.User, error) { return h.users.GetUserByEmail(ctx, email) })
+	if !ok {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"user":    user,
+		"user":    h.userView(ctx, user, fields),
 	})
 }
 
-// UpdateUser actualiza la información de un usuario
-func UpdateUser(c *gin.Context) {
+// UpdateUser actualiza la información de un usuario. Un usuario solo puede cambiar de
+// sí mismo selfUpdatableFields.
+func (h *Handler) UpdateUser(c *gin.Context) {
 	uid := c.Param("uid")
-	var request firebase.UpdateUserRequest
-	
+	fields, ok := h.userFields(c)
+	if !ok {
+		return
+	}
+	var request identity.UserToUpdate
+
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid JSON format",
+			"error":   "Invalid JSON format",
 			"details": err.Error(),
 		})
 		return
 	}
 
-	ctx := context.Background()
-	user, err := auth.UpdateUser(ctx, uid, request)
+	ctx := c.Request.Context()
+	_, access, ok := h.loadUser(c, userAccessSelf, func() (*identity.User, error) { return h.users.GetUser(ctx, uid) })
+	if !ok {
+		return
+	}
+	if access == userAccessSelf {
+		for _, field := range updatedFields(request) {
+			if !slices.Contains(selfUpdatableFields, field) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":   "Acceso denegado. No puedes modificar el campo '" + field + "'.",
+					"allowed": selfUpdatableFields,
+				})
+				return
+			}
+		}
+	}
+
+	user, err := h.users.UpdateUser(ctx, uid, request)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update user",
+			"error":   "Failed to update user",
 			"details": err.Error(),
 		})
 		return
@@ -258,70 +286,143 @@ func UpdateUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"user":    user,
+		"user":    h.userView(ctx, user, fields),
 		"message": "User updated successfully",
 	})
 }
 
-// DeleteUser elimina un usuario
-func DeleteUser(c *gin.Context) {
+// DeleteUser elimina un usuario y todo lo suyo: sesiones, perfiles, la copia de claims,
+// credenciales y, por último, el usuario de Auth. Con ?documents=reassign|anonymize
+// además cambia el autor (created_by) de los documentos que creó. Si un paso falla, el
+// borrado se puede repetir para completarlo.
+func (h *Handler) DeleteUser(c *gin.Context) {
 	uid := c.Param("uid")
 	if uid == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "UID is required"})
 		return
 	}
 
-	ctx := context.Background()
-	err := auth.DeleteUser(ctx, uid)
-	if err != nil {
+	ctx := c.Request.Context()
+	user, _, ok := h.loadUser(c, userAccessManage, func() (*identity.User, error) { return h.users.GetUser(ctx, uid) })
+	if !ok {
+		return
+	}
+	opts, ok := h.userDeletionOptions(c, user)
+	if !ok {
+		return
+	}
+	before := auditUserState(user)
+
+	report := userDeletionReport{Documents: opts.documents}
+	fail := func(message string, err error) {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to delete user",
+			"error":   message,
 			"details": err.Error(),
+			"report":  report,
 		})
+	}
+
+	// 1. Sesiones primero: el usuario deja de poder actuar mientras se limpia el resto
+	revoked, err := h.users.RevokeSessions(ctx, uid)
+	if err != nil {
+		fail("Failed to revoke sessions", err)
 		return
 	}
+	report.SessionsRevoked = revoked
+
+	// 2. Documentos que creó
+	if opts.documents != userDocumentsKeep {
+		report.DocumentsUpdated = map[string]int{}
+		if opts.documents == userDocumentsReassign {
+			report.ReassignedTo = opts.author
+		}
+		for _, collection := range opts.collections {
+			updated, err := h.rewriteCreatedBy(c, collection, uid, opts.author)
+			report.DocumentsUpdated[collection] = updated
+			if err != nil {
+				fail("Failed to update documents in '"+collection+"'", err)
+				return
+			}
+		}
+	}
+
+	// 3. Perfiles, claims, credenciales y el usuario de Auth
+	if message, err := h.purgeUserAccount(ctx, uid, &report); err != nil {
+		fail(message, err)
+		return
+	}
+	h.recordAudit(c, auditUserDelete, uid, before, nil)
+
+	// Avisamos a cada subdominio del usuario; sin claims, al de la petición
+	subdomains := claimSubdomains(user.CustomClaims)
+	if len(subdomains) == 0 {
+		subdomains = []string{requestSubdomain(c)}
+	}
+	for _, subdomain := range subdomains {
+		h.enqueueWebhook(c, webhooks.UserDeleted, subdomain, map[string]interface{}{
+			"uid":          uid,
+			"email":        user.Email,
+			"display_name": user.DisplayName,
+		})
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "User deleted successfully",
 		"uid":     uid,
+		"report":  report,
 	})
 }
 
 // SetUserClaims establece claims y los sincroniza con Firestore
-func SetUserClaims(c *gin.Context) {
+func (h *Handler) SetUserClaims(c *gin.Context) {
 	uid := c.Param("uid")
 	var claims map[string]interface{}
-	
+
 	if err := c.ShouldBindJSON(&claims); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
 		return
 	}
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
+
+	var before map[string]interface{}
+	if user, err := h.users.GetUser(ctx, uid); err == nil {
+		before = user.CustomClaims
+	}
 
 	// 1. Establecer los claims en Firebase Authentication (como antes)
-	err := auth.SetCustomClaims(ctx, uid, claims)
+	err := h.users.SetCustomClaims(ctx, uid, claims)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set custom claims in Firebase Auth"})
 		return
 	}
 
 	// 2. ACTUALIZAR (o crear si no existe) el documento en Firestore
 	// Usamos UpdateDocument para asegurarnos de que se sobreescriba si ya existe.
-	err = firestore.UpdateDocument(ctx, "user_claims", uid, map[string]interface{}{
+	err = h.docs.UpdateDocument(ctx, "user_claims", uid, map[string]interface{}{
 		"claims": claims,
 	})
 	if err != nil {
 		// Si Update falla porque el doc no existe, intentamos crearlo (esto lo hace más robusto)
-		errCreate := firestore.CreateDocumentWithID(ctx, "user_claims", uid, map[string]interface{}{
+		errCreate := h.docs.CreateDocumentWithID(ctx, "user_claims", uid, map[string]interface{}{
 			"claims": claims,
 		})
 		if errCreate != nil {
+			if middleware.AbortIfContextDone(c, errCreate) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync claims to Firestore"})
 			return
 		}
 	}
+	h.recordAudit(c, auditClaimsSet, uid, before, claims)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -330,28 +431,31 @@ func SetUserClaims(c *gin.Context) {
 }
 
 // UpdateUserClaims (PATCH) actualiza claims existentes sin borrar los que no se envían.
-func UpdateUserClaims(c *gin.Context) {
+func (h *Handler) UpdateUserClaims(c *gin.Context) {
 	uid := c.Param("uid")
 	var newClaims map[string]interface{}
-	
+
 	if err := c.ShouldBindJSON(&newClaims); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
 		return
 	}
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	// 1. OBTENER los claims actuales del usuario desde Firebase Auth.
-	user, err := auth.GetUser(ctx, uid)
+	user, err := h.users.GetUser(ctx, uid)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
-	
-	// Si el usuario no tiene claims, empezamos con un mapa vacío.
-	existingClaims := user.CustomClaims
-	if existingClaims == nil {
-		existingClaims = make(map[string]interface{})
+
+	// Copiamos los claims actuales (o empezamos con un mapa vacío) para conservar el estado previo.
+	existingClaims := make(map[string]interface{}, len(user.CustomClaims)+len(newClaims))
+	for key, value := range user.CustomClaims {
+		existingClaims[key] = value
 	}
 
 	// 2. FUSIONAR los claims existentes con los nuevos.
@@ -362,19 +466,23 @@ func UpdateUserClaims(c *gin.Context) {
 
 	// 3. ESTABLECER el mapa de claims completo y fusionado.
 	// Llamamos a la misma función que antes, pero ahora con el set completo de claims.
-	err = auth.SetCustomClaims(ctx, uid, existingClaims)
+	err = h.users.SetCustomClaims(ctx, uid, existingClaims)
 	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set custom claims in Firebase Auth"})
 		return
 	}
 
 	// 4. Sincronizar con Firestore (como en la otra función)
-	err = firestore.UpdateDocument(ctx, "user_claims", uid, map[string]interface{}{
+	err = h.docs.UpdateDocument(ctx, "user_claims", uid, map[string]interface{}{
 		"claims": existingClaims,
 	})
 	if err != nil {
 		// ... (manejo de error si la sincronización falla)
 	}
+	h.recordAudit(c, auditClaimsUpdate, uid, user.CustomClaims, existingClaims)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -384,3 +492,44 @@ func UpdateUserClaims(c *gin.Context) {
 	})
 }
 
+// ReconcileUsers busca las cuentas a medias (solo admins). Por defecto solo informa;
+// con ?repair=true aplica las reparaciones seguras y audita cada una.
+func (h *Handler) ReconcileUsers(c *gin.Context) {
+	repair := false
+	if raw := c.Query("repair"); raw != "" {
+		var err error
+		if repair, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repair parameter (use true or false)"})
+			return
+		}
+	}
+
+	report, err := reconcile.Run(c.Request.Context(), h.users, h.docs, reconcile.Options{
+		Repair:      repair,
+		GracePeriod: h.cfg.Reconcile.GracePeriod,
+	})
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to reconcile users",
+			"details": err.Error(),
+		})
+		return
+	}
+	for _, issue := range report.Issues {
+		if issue.Repaired {
+			h.recordAudit(c, auditUserReconcile, issue.UID, map[string]interface{}{
+				"kind":       issue.Kind,
+				"email":      issue.Email,
+				"profile_id": issue.ProfileID,
+			}, map[string]interface{}{"repair": issue.Repair})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
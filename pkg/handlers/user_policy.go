@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// roleTenantAdmin es el rol de quien gestiona los usuarios de sus subdominios.
+const roleTenantAdmin = "tenant_admin"
+
+// userAccess es lo que la sesión puede hacer con un usuario.
+type userAccess int
+
+const (
+	// userAccessNone: el usuario no es visible para la sesión.
+	userAccessNone userAccess = iota
+	// userAccessSelf: es el propio usuario de la sesión; puede leerse y cambiar selfUpdatableFields.
+	userAccessSelf
+	// userAccessManage: admin, o tenant admin de todos los subdominios del usuario.
+	userAccessManage
+)
+
+// selfUpdatableFields son los campos de UserToUpdate que un usuario puede cambiar de sí mismo.
+var selfUpdatableFields = []string{"display_name", "photo_url", "password"}
+
+// sessionRole devuelve el claim role de la sesión.
+func sessionRole(c *gin.Context) string {
+	claims, _ := c.Get("claims")
+	claimsMap, _ := claims.(map[string]interface{})
+	role, _ := claimsMap["role"].(string)
+	return role
+}
+
+// userAccessFor aplica la política de usuarios: los admins gestionan a cualquiera; un
+// tenant admin, a los usuarios (no admins) cuyos subdominios son todos suyos; y cada
+// usuario se ve a sí mismo.
+func userAccessFor(c *gin.Context, target *identity.User) userAccess {
+	if isAdmin(c) {
+		return userAccessManage
+	}
+	if sessionRole(c) == roleTenantAdmin && target.CustomClaims["role"] != "admin" {
+		claims, _ := c.Get("claims")
+		claimsMap, _ := claims.(map[string]interface{})
+		own := map[string]bool{}
+		for _, subdomain := range claimSubdomains(claimsMap) {
+			own[subdomain] = true
+		}
+		targetSubdomains := claimSubdomains(target.CustomClaims)
+		managed := len(targetSubdomains) > 0
+		for _, subdomain := range targetSubdomains {
+			managed = managed && own[subdomain]
+		}
+		if managed {
+			return userAccessManage
+		}
+	}
+	if target.UID == c.GetString("uid") {
+		return userAccessSelf
+	}
+	return userAccessNone
+}
+
+// loadUser obtiene el usuario con lookup y comprueba que la sesión tenga al menos min
+// sobre él. Responde 404 tanto si no existe como si no es visible, para no revelar qué
+// cuentas existen, y 403 si es visible pero el acceso no alcanza.
+func (h *Handler) loadUser(c *gin.Context, min userAccess, lookup func() (*identity.User, error)) (*identity.User, userAccess, bool) {
+	user, err := lookup()
+	if middleware.AbortIfContextDone(c, err) {
+		return nil, userAccessNone, false
+	}
+	// Como antes, cualquier error del proveedor se trata como usuario inexistente
+	access := userAccessNone
+	if err == nil {
+		access = userAccessFor(c, user)
+	}
+	if access == userAccessNone {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, userAccessNone, false
+	}
+	if access < min {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Acceso denegado. No tienes permiso para gestionar este usuario.",
+		})
+		return nil, access, false
+	}
+	return user, access, true
+}
+
+// canCreateUsers indica si la sesión puede crear usuarios: admins y tenant admins.
+func canCreateUsers(c *gin.Context) bool {
+	return isAdmin(c) || sessionRole(c) == roleTenantAdmin
+}
+
+// updatedFields devuelve los campos JSON que update modifica.
+func updatedFields(update identity.UserToUpdate) []string {
+	var fields []string
+	if update.Email != nil {
+		fields = append(fields, "email")
+	}
+	if update.Password != nil {
+		fields = append(fields, "password")
+	}
+	if update.DisplayName != nil {
+		fields = append(fields, "display_name")
+	}
+	if update.PhotoURL != nil {
+		fields = append(fields, "photo_url")
+	}
+	if update.EmailVerified != nil {
+		fields = append(fields, "email_verified")
+	}
+	if update.Disabled != nil {
+		fields = append(fields, "disabled")
+	}
+	return fields
+}
+
+// claimSubdomains devuelve los subdominios del claim "subdomain" (una lista o un solo valor).
+func claimSubdomains(claims map[string]interface{}) []string {
+	switch v := claims["subdomain"].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
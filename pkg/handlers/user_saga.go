@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// compensationTimeout limita cada compensación. Se ejecutan fuera del contexto de la
+// petición, que puede haber vencido justo por el fallo que obliga a compensar.
+const compensationTimeout = 10 * time.Second
+
+// saga ejecuta una operación que abarca varios sistemas sin transacción común (Auth,
+// credenciales, Firestore). Cada paso registra antes de ejecutarse cómo deshacerse y, si
+// uno falla, compensate deshace en orden inverso todos los registrados. Las compensaciones
+// son idempotentes, así que también cubren un paso que falló después de escribir (por
+// ejemplo, por timeout).
+type saga struct {
+	name  string
+	steps []sagaStep
+}
+
+type sagaStep struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// onRollback registra cómo deshacer el paso name.
+func (s *saga) onRollback(name string, undo func(ctx context.Context) error) {
+	s.steps = append(s.steps, sagaStep{name: name, undo: undo})
+}
+
+// compensate deshace los pasos registrados en orden inverso. Sigue aunque una
+// compensación falle y devuelve sus errores: lo que quede a medias lo encuentra la
+// reconciliación de usuarios.
+func (s *saga) compensate(ctx context.Context) []string {
+	ctx = context.WithoutCancel(ctx)
+	var failed []string
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		stepCtx, cancel := context.WithTimeout(ctx, compensationTimeout)
+		err := step.undo(stepCtx)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ %s: no se pudo deshacer %s: %v", s.name, step.name, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", step.name, err))
+		}
+	}
+	s.steps = nil
+	return failed
+}
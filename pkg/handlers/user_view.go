@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// profilesCollection guarda el perfil que CreateUser crea para cada usuario (campo user_id).
+const profilesCollection = "profiles"
+
+// profileLookupBatch es el máximo de valores de un filtro "in" en Firestore.
+const profileLookupBatch = 30
+
+// userViewFields son los campos que acepta ?fields=. "profile.<campo>" selecciona un
+// campo del perfil.
+var userViewFields = []string{
+	"uid", "email", "email_verified", "display_name", "photo_url", "disabled",
+	"creation_timestamp", "last_signin_timestamp", "custom_claims", "profile",
+}
+
+// UserView es la representación de un usuario en todas las rutas de /users: solo los
+// campos públicos del proveedor, las fechas como milisegundos Unix (seguros en JSON aunque
+// el proveedor devuelva fechas absurdas) y el perfil de la colección profiles.
+type UserView struct {
+	UID                 string                 `json:"uid"`
+	Email               string                 `json:"email"`
+	EmailVerified       bool                   `json:"email_verified"`
+	DisplayName         string                 `json:"display_name"`
+	PhotoURL            string                 `json:"photo_url"`
+	Disabled            bool                   `json:"disabled"`
+	CreationTimestamp   int64                  `json:"creation_timestamp"`
+	LastSignInTimestamp int64                  `json:"last_signin_timestamp"`
+	CustomClaims        map[string]interface{} `json:"custom_claims"`
+	// Profile son los datos del perfil más su ID (profile_id); nil si el usuario no tiene.
+	Profile map[string]interface{} `json:"profile"`
+	// LastLogInTimestamp es el nombre que usaba el listado para LastSignInTimestamp. Obsoleto:
+	// solo lo rellena ListUsers, para los clientes anteriores.
+	LastLogInTimestamp *int64 `json:"last_log_in_timestamp,omitempty"`
+}
+
+func newUserView(user *identity.User, profile *firebase.Document) UserView {
+	view := UserView{
+		UID:                 user.UID,
+		Email:               user.Email,
+		EmailVerified:       user.EmailVerified,
+		DisplayName:         user.DisplayName,
+		PhotoURL:            user.PhotoURL,
+		Disabled:            user.Disabled,
+		CreationTimestamp:   user.CreationTime.UnixMilli(),
+		LastSignInTimestamp: user.LastLogInTime.UnixMilli(),
+		CustomClaims:        user.CustomClaims,
+	}
+	if profile != nil {
+		view.Profile = map[string]interface{}{"profile_id": profile.ID}
+		for key, value := range profile.Data {
+			if key != "user_id" {
+				view.Profile[key] = value
+			}
+		}
+	}
+	return view
+}
+
+// Select devuelve solo los campos pedidos (uid siempre se incluye). Sin campos, devuelve la vista completa.
+func (v UserView) Select(fields []string) interface{} {
+	if len(fields) == 0 {
+		return v
+	}
+	all := map[string]interface{}{
+		"uid":                   v.UID,
+		"email":                 v.Email,
+		"email_verified":        v.EmailVerified,
+		"display_name":          v.DisplayName,
+		"photo_url":             v.PhotoURL,
+		"disabled":              v.Disabled,
+		"creation_timestamp":    v.CreationTimestamp,
+		"last_signin_timestamp": v.LastSignInTimestamp,
+		"custom_claims":         v.CustomClaims,
+		"profile":               v.Profile,
+	}
+	out := map[string]interface{}{"uid": v.UID}
+	for _, field := range fields {
+		name, sub, nested := strings.Cut(field, ".")
+		if !nested {
+			out[name] = all[name]
+			continue
+		}
+		// profile.<campo>: se acumulan en un perfil parcial
+		partial, _ := out["profile"].(map[string]interface{})
+		if partial == nil {
+			partial = map[string]interface{}{}
+			out["profile"] = partial
+		}
+		if value, ok := v.Profile[sub]; ok {
+			partial[sub] = value
+		}
+	}
+	return out
+}
+
+// withLegacyListFields añade a las vistas del listado last_log_in_timestamp con el valor de
+// last_signin_timestamp (si se seleccionó), para no romper a los clientes anteriores.
+func withLegacyListFields(views []interface{}) []interface{} {
+	for i, view := range views {
+		switch v := view.(type) {
+		case UserView:
+			ts := v.LastSignInTimestamp
+			v.LastLogInTimestamp = &ts
+			views[i] = v
+		case map[string]interface{}:
+			if ts, ok := v["last_signin_timestamp"]; ok {
+				v["last_log_in_timestamp"] = ts
+			}
+		}
+	}
+	return views
+}
+
+// parseUserFields lee ?fields= (lista separada por comas). Devuelve nil si no se pidió.
+func parseUserFields(c *gin.Context) ([]string, error) {
+	raw := c.Query("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, sub, nested := strings.Cut(field, ".")
+		known := false
+		for _, allowed := range userViewFields {
+			known = known || name == allowed
+		}
+		if !known || (nested && (name != "profile" || sub == "")) {
+			return nil, fmt.Errorf("unknown field '%s' (allowed: %s, profile.<field>)", field, strings.Join(userViewFields, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// userFields es parseUserFields respondiendo 400 si ?fields= no es válido.
+func (h *Handler) userFields(c *gin.Context) ([]string, bool) {
+	fields, err := parseUserFields(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid fields parameter",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+	return fields, true
+}
+
+// wantsProfile indica si fields necesita el perfil.
+func wantsProfile(fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, field := range fields {
+		if field == "profile" || strings.HasPrefix(field, "profile.") {
+			return true
+		}
+	}
+	return false
+}
+
+// userViews construye las vistas de users con sus perfiles. Un fallo al leer los perfiles
+// no impide responder: se registra y las vistas salen sin perfil.
+func (h *Handler) userViews(ctx context.Context, users []*identity.User, fields []string) []interface{} {
+	profiles := map[string]*firebase.Document{}
+	if wantsProfile(fields) {
+		uids := make([]interface{}, len(users))
+		for i, user := range users {
+			uids[i] = user.UID
+		}
+		for start := 0; start < len(uids); start += profileLookupBatch {
+			end := min(start+profileLookupBatch, len(uids))
+			docs, err := h.docs.QueryDocuments(ctx, profilesCollection, firebase.QueryOptions{
+				Filters: []firebase.QueryFilter{{Field: "user_id", Operator: "in", Value: uids[start:end]}},
+			})
+			if err != nil {
+				log.Printf("Warning: Failed to load profiles: %v", err)
+				break
+			}
+			for _, doc := range docs {
+				uid, _ := doc.Data["user_id"].(string)
+				if _, seen := profiles[uid]; !seen {
+					profiles[uid] = doc
+				}
+			}
+		}
+	}
+
+	views := make([]interface{}, len(users))
+	for i, user := range users {
+		views[i] = newUserView(user, profiles[user.UID]).Select(fields)
+	}
+	return views
+}
+
+// userView es userViews para un solo usuario.
+func (h *Handler) userView(ctx context.Context, user *identity.User, fields []string) interface{} {
+	return h.userViews(ctx, []*identity.User{user}, fields)[0]
+}
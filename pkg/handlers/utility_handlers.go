@@ -2,21 +2,19 @@
 package handlers
 
 import (
-	"context"
 	"log"
 	"net/http"
 
 	"github.com/andrescris/firestore/lib/firebase"
-	"github.com/andrescris/firestore/lib/firebase/auth"
 	"github.com/gin-gonic/gin"
 )
 
 // GetStats obtiene estadísticas generales del servidor
-func GetStats(c *gin.Context) {
-	ctx := context.Background()
-	
+func (h *Handler) GetStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	// Obtener estadísticas básicas
-	userCount, err := auth.GetUserCount(ctx)
+	userCount, err := h.users.CountUsers(ctx)
 	if err != nil {
 		log.Printf("Error getting user count: %v", err)
 		userCount = -1
@@ -39,24 +37,71 @@ func ApiDocs(c *gin.Context) {
 
 BASE URL: http://localhost:8080/api/v1
 
-=== USUARIOS ===
-POST   /users                    - Crear usuario
-GET    /users                    - Listar usuarios (?limit=10&page_token=xxx)
-GET    /users/:uid               - Obtener usuario por UID
-GET    /users/email/:email       - Obtener usuario por email
-PUT    /users/:uid               - Actualizar usuario
-DELETE /users/:uid               - Eliminar usuario
+=== USUARIOS === (requieren sesión)
+POST   /users                    - Crear usuario (admin o tenant_admin; si un paso falla se deshace todo)
+GET    /users                    - Listar usuarios (admin, ?limit=10&page_token=xxx)
+GET    /users/:uid               - Obtener usuario por UID (uno mismo, tenant_admin o admin)
+GET    /users/email/:email       - Obtener usuario por email (ídem)
+PUT    /users/:uid               - Actualizar usuario (uno mismo: display_name, photo_url, password)
+DELETE /users/:uid               - Eliminar usuario con sesiones, perfiles, claims y credenciales (tenant_admin o admin)
+                                   ?documents=keep|reassign|anonymize&reassign_to=uid&collections=a,b
+                                   Las que devuelven usuarios aceptan ?fields=email,profile.role
 POST   /users/:uid/claims        - Establecer claims personalizados
+POST   /users/reconcile          - Buscar cuentas a medias (admin, ?repair=true las repara)
+GET    /users/:uid/export        - Exportar todos los datos del usuario (admin, ?format=json|zip&collections=a,b)
+POST   /users/:uid/erase         - Borrar todos los datos del usuario y devolver un recibo firmado
+                                   (admin, ?mode=delete|pseudonymize&collections=a,b)
+POST   /users/erasure-receipts/verify - Verificar un recibo de borrado (admin)
 
 === DOCUMENTOS ===
 POST   /collections/:collection/documents     - Crear documento
-GET    /collections/:collection/documents     - Listar documentos
+GET    /collections/:collection/documents     - Listar documentos (?limit=10&page_token=xxx)
 GET    /collections/:collection/documents/:id - Obtener documento
 PUT    /collections/:collection/documents/:id - Actualizar documento
-DELETE /collections/:collection/documents/:id - Eliminar documento
+PATCH  /collections/:collection/documents/:id - Actualización parcial (merge-patch+json o json-patch+json)
+DELETE /collections/:collection/documents/:id - Mover documento a la papelera (?hard=true solo admins)
+
+=== SUBCOLECCIONES ===
+*      /collections/:collection/documents/:id/collections/:sub/documents[/:id...] - Mismas rutas que los documentos
+POST   /collections/:collection/documents/:id/collections/:sub/query             - Consultar subcolección
+       (anidables: .../collections/:sub/documents/:id/collections/:sub2/...; tenant del documento raíz)
+
+=== LOTES ===
+POST   /collections/batch                     - Crear/actualizar/eliminar en varias colecciones (todo o nada)
+
+=== ESQUEMAS ===
+GET    /collections/:collection/schema             - Ver JSON Schema de la colección
+PUT    /collections/:collection/schema             - Registrar JSON Schema (admin)
+DELETE /collections/:collection/schema             - Eliminar JSON Schema (admin)
+
+=== PAPELERA ===
+GET    /collections/:collection/trash              - Listar eliminados (?limit=10&page_token=xxx)
+POST   /collections/:collection/trash/:id/restore  - Recuperar documento
+DELETE /collections/:collection/trash/:id          - Eliminar definitivamente (admin)
+
+=== HISTORIAL ===
+GET    /collections/:collection/documents/:id/revisions            - Listar revisiones (?limit=10&page_token=xxx)
+GET    /collections/:collection/documents/:id/revisions/:rev       - Obtener revisión
+GET    /collections/:collection/documents/:id/diff?from=:rev&to=:rev - Comparar revisiones (to=current por defecto)
+POST   /collections/:collection/documents/:id/revisions/:rev/restore - Restaurar revisión
+
+=== AUDITORÍA ===
+GET    /audit/events                          - Eventos de auditoría (admin; ?actor=&target=&action=&from=&to=)
+GET    /audit/verify                          - Verificar la cadena de hashes (admin)
 
 === CONSULTAS ===
-POST   /collections/:collection/query         - Consultar con filtros
+POST   /collections/:collection/query         - Consultar con filtros (limit, page_token)
+
+=== STREAM DE CAMBIOS ===
+GET    /collections/:collection/stream        - Cambios como Server-Sent Events (?filters=[...], Last-Event-ID)
+
+=== WEBHOOKS ===
+POST   /webhooks/endpoints                    - Registrar endpoint {url, events} (admin o tenant_admin; devuelve el secreto)
+GET    /webhooks/endpoints                    - Listar endpoints del subdominio
+PATCH  /webhooks/endpoints/:id                - Cambiar url, events o active (admin o tenant_admin; una url nueva rota el secreto)
+DELETE /webhooks/endpoints/:id                - Eliminar endpoint (admin o tenant_admin)
+GET    /webhooks/deliveries                   - Listar entregas (?status=dead&endpoint_id=; admin o tenant_admin)
+POST   /webhooks/deliveries/:id/redeliver     - Reenviar una entrega (admin o tenant_admin)
 
 === UTILIDADES ===
 GET    /stats                                 - Estadísticas del servidor
@@ -66,4 +111,4 @@ https://github.com/andrescris/firestore/tree/main/examples/postman
 `
 
 	c.String(http.StatusOK, docs)
-}
\ No newline at end of file
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/middleware"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/alimedia/pkg/webhooks"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/gin-gonic/gin"
+)
+
+// Acciones del registro de auditoría sobre webhooks
+const (
+	auditWebhookCreate    = "webhook.endpoint.create"
+	auditWebhookUpdate    = "webhook.endpoint.update"
+	auditWebhookDelete    = "webhook.endpoint.delete"
+	auditWebhookRedeliver = "webhook.delivery.redeliver"
+)
+
+// enqueueWebhook encola eventType para los endpoints de subdomain. La acción ya se hizo,
+// así que un fallo solo se registra en el log.
+func (h *Handler) enqueueWebhook(c *gin.Context, eventType, subdomain string, data map[string]interface{}) {
+	if subdomain == "" {
+		return
+	}
+	// La cola no depende de que el cliente siga esperando la respuesta
+	ctx := context.WithoutCancel(c.Request.Context())
+	if _, err := h.hooks.Enqueue(ctx, eventType, subdomain, data); err != nil {
+		log.Printf("⚠️ No se pudo encolar el webhook %s de %s: %v", eventType, subdomain, err)
+	}
+}
+
+// canManageWebhooks indica si la sesión gestiona los webhooks del subdominio de la petición:
+// los admins y los tenant admins de ese subdominio. Un endpoint recibe los cambios de todo
+// el tenant y las entregas guardan esos datos, así que un usuario normal no los gestiona.
+func canManageWebhooks(c *gin.Context) bool {
+	if isAdmin(c) {
+		return true
+	}
+	if sessionRole(c) != roleTenantAdmin {
+		return false
+	}
+	claims, _ := c.Get("claims")
+	claimsMap, _ := claims.(map[string]interface{})
+	subdomain := c.GetString("subdomain")
+	for _, own := range claimSubdomains(claimsMap) {
+		if own == subdomain {
+			return true
+		}
+	}
+	return false
+}
+
+// requireWebhookManager responde 403 y devuelve false si la sesión no gestiona los webhooks
+// del subdominio.
+func requireWebhookManager(c *gin.Context) bool {
+	if canManageWebhooks(c) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Solo los administradores del subdominio pueden gestionar sus webhooks",
+	})
+	return false
+}
+
+// endpointRequest es el cuerpo de CreateWebhookEndpoint y UpdateWebhookEndpoint.
+type endpointRequest struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// validate comprueba los campos presentes; con create, url y events son obligatorios.
+func (r endpointRequest) validate(create, allowInsecure bool) error {
+	if create && (r.URL == nil || r.Events == nil) {
+		return errors.New("'url' and 'events' are required")
+	}
+	if r.URL != nil {
+		if err := webhooks.ValidateURL(*r.URL, allowInsecure); err != nil {
+			return errors.New("'url' " + err.Error())
+		}
+	}
+	if r.Events != nil {
+		if len(*r.Events) == 0 {
+			return errors.New("'events' must not be empty")
+		}
+		for _, event := range *r.Events {
+			if !webhooks.ValidEventType(event) {
+				return errors.New("unknown event type '" + event + "'")
+			}
+		}
+	}
+	return nil
+}
+
+// CreateWebhookEndpoint registra un endpoint para el subdominio de la petición. El secreto
+// de firma solo se devuelve en esta respuesta.
+func (h *Handler) CreateWebhookEndpoint(c *gin.Context) {
+	if !requireWebhookManager(c) {
+		return
+	}
+	// SessionAuthMiddleware ya validó el acceso al subdominio de X-Client-Subdomain
+	subdomain := c.GetString("subdomain")
+
+	var req endpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := req.validate(true, h.cfg.Webhooks.AllowInsecureURLs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid webhook endpoint",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	endpoint := webhooks.Endpoint{
+		Subdomain: subdomain,
+		URL:       *req.URL,
+		Events:    *req.Events,
+		Active:    req.Active == nil || *req.Active,
+		Secret:    webhooks.NewSecret(),
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: c.GetString("uid"),
+	}
+	id, err := h.docs.CreateDocument(c.Request.Context(), webhooks.EndpointsCollection, map[string]interface{}{
+		"subdomain":  endpoint.Subdomain,
+		"url":        endpoint.URL,
+		"events":     stringsToInterfaces(endpoint.Events),
+		"active":     endpoint.Active,
+		"secret":     endpoint.Secret,
+		"created_at": endpoint.CreatedAt,
+		"created_by": endpoint.CreatedBy,
+	})
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create webhook endpoint",
+			"details": err.Error(),
+		})
+		return
+	}
+	endpoint.ID = id
+	h.recordAudit(c, auditWebhookCreate, id, nil, map[string]interface{}{
+		"subdomain": endpoint.Subdomain,
+		"url":       endpoint.URL,
+		"events":    stringsToInterfaces(endpoint.Events),
+		"active":    endpoint.Active,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"message":  "Guarda el secreto: no se volverá a mostrar",
+		"endpoint": endpoint,
+	})
+}
+
+// ListWebhookEndpoints lista los endpoints del subdominio, sin sus secretos.
+func (h *Handler) ListWebhookEndpoints(c *gin.Context) {
+	subdomain := c.GetString("subdomain")
+
+	docs, err := h.docs.QueryDocuments(c.Request.Context(), webhooks.EndpointsCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "subdomain", Operator: "==", Value: subdomain}},
+	})
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list webhook endpoints",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	endpoints := make([]webhooks.Endpoint, len(docs))
+	for i, doc := range docs {
+		endpoints[i] = webhooks.EndpointFromDocument(doc)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"endpoints": endpoints,
+		"count":     len(endpoints),
+	})
+}
+
+// loadWebhookDocument lee collection/:id y responde 404 si no existe o es de otro
+// subdominio, para no revelar los IDs de otros tenants.
+func (h *Handler) loadWebhookDocument(c *gin.Context, collection, what string) (*firebase.Document, bool) {
+	id := c.Param("id")
+	doc, err := h.docs.GetDocument(c.Request.Context(), collection, id)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return nil, false
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to get " + what,
+				"details": err.Error(),
+			})
+			return nil, false
+		}
+	}
+	if err != nil || doc.Data["subdomain"] != c.GetString("subdomain") {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": what + " not found",
+			"id":    id,
+		})
+		return nil, false
+	}
+	return doc, true
+}
+
+// UpdateWebhookEndpoint cambia la URL, los eventos o el estado (active) de un endpoint.
+// Desactivarlo hace que sus entregas pendientes pasen a "dead" en el siguiente intento.
+// Cambiar la URL genera un secreto nuevo, que solo se devuelve en esta respuesta: el
+// destino nuevo no debe poder verificar firmas con el secreto que conocía el anterior.
+func (h *Handler) UpdateWebhookEndpoint(c *gin.Context) {
+	if !requireWebhookManager(c) {
+		return
+	}
+	doc, ok := h.loadWebhookDocument(c, webhooks.EndpointsCollection, "Webhook endpoint")
+	if !ok {
+		return
+	}
+
+	var req endpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := req.validate(false, h.cfg.Webhooks.AllowInsecureURLs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid webhook endpoint",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	before := webhooks.EndpointFromDocument(doc)
+	update := map[string]interface{}{}
+	secret := ""
+	if req.URL != nil {
+		update["url"] = *req.URL
+		if *req.URL != before.URL {
+			secret = webhooks.NewSecret()
+			update["secret"] = secret
+		}
+	}
+	if req.Events != nil {
+		update["events"] = stringsToInterfaces(*req.Events)
+	}
+	if req.Active != nil {
+		update["active"] = *req.Active
+	}
+	if len(update) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update: send url, events or active"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.docs.UpdateDocument(ctx, webhooks.EndpointsCollection, doc.ID, update); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to update webhook endpoint",
+			"details": err.Error(),
+		})
+		return
+	}
+	after := maps.Clone(update)
+	if secret != "" {
+		// El secreto no va al registro de auditoría, solo que se rotó
+		after["secret"] = "rotated"
+	}
+	h.recordAudit(c, auditWebhookUpdate, doc.ID, map[string]interface{}{
+		"url":    before.URL,
+		"events": stringsToInterfaces(before.Events),
+		"active": before.Active,
+	}, after)
+
+	updated, err := h.docs.GetDocument(ctx, webhooks.EndpointsCollection, doc.ID)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Webhook endpoint updated, but failed to read it back",
+			"details": err.Error(),
+		})
+		return
+	}
+	endpoint := webhooks.EndpointFromDocument(updated)
+	if secret != "" {
+		endpoint.Secret = secret
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"message":  "La URL cambió y se generó un secreto nuevo: guárdalo, no se volverá a mostrar",
+			"endpoint": endpoint,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"endpoint": endpoint,
+	})
+}
+
+// DeleteWebhookEndpoint elimina un endpoint. Sus entregas pendientes pasan a "dead" en el
+// siguiente intento y se conservan para consulta.
+func (h *Handler) DeleteWebhookEndpoint(c *gin.Context) {
+	if !requireWebhookManager(c) {
+		return
+	}
+	doc, ok := h.loadWebhookDocument(c, webhooks.EndpointsCollection, "Webhook endpoint")
+	if !ok {
+		return
+	}
+
+	if err := h.docs.DeleteDocument(c.Request.Context(), webhooks.EndpointsCollection, doc.ID); err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete webhook endpoint",
+			"details": err.Error(),
+		})
+		return
+	}
+	before := webhooks.EndpointFromDocument(doc)
+	h.recordAudit(c, auditWebhookDelete, doc.ID, map[string]interface{}{
+		"url":    before.URL,
+		"events": stringsToInterfaces(before.Events),
+		"active": before.Active,
+	}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook endpoint deleted successfully",
+		"id":      doc.ID,
+	})
+}
+
+// ListWebhookDeliveries lista las entregas del subdominio, de la más reciente a la más
+// antigua. Filtros: ?status= (pending, delivered, dead) y ?endpoint_id=, paginado con
+// ?limit= y ?page_token=. Los payloads llevan datos de todo el tenant, así que solo la
+// ven quienes gestionan sus webhooks.
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	if !requireWebhookManager(c) {
+		return
+	}
+	subdomain := c.GetString("subdomain")
+
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "subdomain", Operator: "==", Value: subdomain}},
+		OrderBy: []firebase.OrderBy{{Field: "created_at", Direction: "desc"}},
+	}
+	switch status := c.Query("status"); status {
+	case "":
+	case webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead:
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "status", Operator: "==", Value: status})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid status parameter: use pending, delivered or dead",
+		})
+		return
+	}
+	if endpointID := c.Query("endpoint_id"); endpointID != "" {
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "endpoint_id", Operator: "==", Value: endpointID})
+	}
+
+	limit := h.pageSize(c)
+	after, err := h.decodePageToken(c.Query("page_token"), webhooks.DeliveriesCollection, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired page_token"})
+		return
+	}
+
+	query := options
+	query.Limit = limit + 1
+	docs, err := h.docs.QueryDocumentsAfter(c.Request.Context(), webhooks.DeliveriesCollection, query, after)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to query webhook deliveries",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	docs, nextToken, err := h.paginate(webhooks.DeliveriesCollection, options, docs, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build page token",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	deliveries := make([]webhooks.Delivery, len(docs))
+	for i, doc := range docs {
+		deliveries[i] = webhooks.DeliveryFromDocument(doc)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"deliveries":      deliveries,
+		"count":           len(deliveries),
+		"next_page_token": nextToken,
+		"has_more":        nextToken != "",
+	})
+}
+
+// RedeliverWebhook vuelve a poner en cola una entrega (normalmente una de la lista de
+// "dead") con los intentos a cero. Una entrega ya entregada también se puede reenviar.
+func (h *Handler) RedeliverWebhook(c *gin.Context) {
+	if !requireWebhookManager(c) {
+		return
+	}
+	doc, ok := h.loadWebhookDocument(c, webhooks.DeliveriesCollection, "Webhook delivery")
+	if !ok {
+		return
+	}
+	if doc.Data["status"] == webhooks.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Delivery is already pending",
+			"id":    doc.ID,
+		})
+		return
+	}
+
+	delivery, err := h.hooks.Redeliver(c.Request.Context(), doc.ID)
+	if err != nil {
+		if middleware.AbortIfContextDone(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to redeliver webhook",
+			"details": err.Error(),
+		})
+		return
+	}
+	h.recordAudit(c, auditWebhookRedeliver, doc.ID, map[string]interface{}{"status": doc.Data["status"]},
+		map[string]interface{}{"status": delivery.Status})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":  true,
+		"delivery": delivery,
+	})
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
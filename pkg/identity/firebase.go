@@ -0,0 +1,331 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/andrescris/firestore/lib/firebase/auth"
+	"github.com/andrescris/firestore/lib/firebase/firestore"
+)
+
+const (
+	// DefaultCredentialsCollection es la colección donde auth.StoreUserCredentials guarda el
+	// hash de la contraseña, un documento por usuario con el UID como ID.
+	DefaultCredentialsCollection = "user_credentials"
+	// DefaultSessionsCollection es la colección donde auth.Login guarda las sesiones, un
+	// documento por sesión con el ID de sesión como ID.
+	DefaultSessionsCollection = "user_sessions"
+	// DefaultSessionUIDField es el campo de la sesión con el UID del usuario.
+	DefaultSessionUIDField = "uid"
+)
+
+// ErrStorageLayout indica que la librería de auth no guarda las credenciales o las sesiones
+// donde dicen CredentialsCollection, SessionsCollection y SessionUIDField.
+var ErrStorageLayout = errors.New("auth library storage layout does not match the configuration")
+
+// FirebaseProvider implementa Provider con Firebase Authentication a través de la librería auth.
+// Requiere que Firebase se haya inicializado con firebase.InitFirebaseFromEnv.
+type FirebaseProvider struct {
+	// CredentialsCollection, SessionsCollection y SessionUIDField describen dónde guarda la
+	// librería de auth las credenciales y las sesiones. La librería no permite consultar ni
+	// borrar en bloque credenciales y sesiones, así que se accede a los documentos.
+	//
+	// Si no coinciden con la librería, las consultas no encuentran nada y RevokeSessions
+	// devolvería 0 sin cerrar ninguna sesión. Por eso StoreCredentials y Login comprueban
+	// que lo que acaba de escribir la librería está donde se espera; si no, lo registran y
+	// los métodos que dependen de ello devuelven ErrStorageLayout.
+	CredentialsCollection string
+	SessionsCollection    string
+	SessionUIDField       string
+
+	mu        sync.Mutex
+	layoutErr error
+}
+
+// NewFirebaseProvider crea un Provider respaldado por Firebase Authentication.
+func NewFirebaseProvider() *FirebaseProvider {
+	return &FirebaseProvider{
+		CredentialsCollection: DefaultCredentialsCollection,
+		SessionsCollection:    DefaultSessionsCollection,
+		SessionUIDField:       DefaultSessionUIDField,
+	}
+}
+
+// layoutError devuelve el error de la última comprobación fallida de StoreCredentials o Login.
+func (p *FirebaseProvider) layoutError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.layoutErr
+}
+
+// checkLayout registra err si es un ErrStorageLayout. Otros errores (red, permisos) no
+// dicen nada de la configuración y se ignoran: la operación de la librería ya se hizo.
+func (p *FirebaseProvider) checkLayout(err error) {
+	if !errors.Is(err, ErrStorageLayout) {
+		return
+	}
+	log.Printf("❌ %v", err)
+	p.mu.Lock()
+	p.layoutErr = err
+	p.mu.Unlock()
+}
+
+// verifyCredentials comprueba que las credenciales de uid están en CredentialsCollection.
+func (p *FirebaseProvider) verifyCredentials(ctx context.Context, uid string) error {
+	if _, err := firestore.GetDocument(ctx, p.CredentialsCollection, uid); err != nil {
+		if storage.IsNotFound(err) {
+			return fmt.Errorf("%w: credentials of %s are not in %q (check auth.credentials_collection)", ErrStorageLayout, uid, p.CredentialsCollection)
+		}
+		return err
+	}
+	return nil
+}
+
+// verifySession comprueba que la sesión sessionID está en SessionsCollection con el UID
+// en SessionUIDField.
+func (p *FirebaseProvider) verifySession(ctx context.Context, sessionID, uid string) error {
+	doc, err := firestore.GetDocument(ctx, p.SessionsCollection, sessionID)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return fmt.Errorf("%w: session %s is not in %q (check auth.sessions_collection)", ErrStorageLayout, sessionID, p.SessionsCollection)
+		}
+		return err
+	}
+	if got, _ := doc.Data[p.SessionUIDField].(string); got != uid {
+		return fmt.Errorf("%w: session %s has no %q field with the user UID (check auth.session_uid_field)", ErrStorageLayout, sessionID, p.SessionUIDField)
+	}
+	return nil
+}
+
+func (p *FirebaseProvider) CreateUser(ctx context.Context, user UserToCreate) (*User, error) {
+	u, err := auth.CreateUser(ctx, firebase.CreateUserRequest{
+		Email:       user.Email,
+		Password:    user.Password,
+		DisplayName: user.DisplayName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &User{UID: u.UID, Email: u.Email, DisplayName: u.DisplayName}, nil
+}
+
+func (p *FirebaseProvider) StoreCredentials(ctx context.Context, uid, password string) error {
+	if err := auth.StoreUserCredentials(ctx, uid, password); err != nil {
+		return err
+	}
+	p.checkLayout(p.verifyCredentials(ctx, uid))
+	return nil
+}
+
+// DeleteCredentials no falla si no hay credenciales: Firestore no falla al borrar un
+// documento inexistente.
+func (p *FirebaseProvider) DeleteCredentials(ctx context.Context, uid string) error {
+	if err := p.layoutError(); err != nil {
+		return err
+	}
+	return firestore.DeleteDocument(ctx, p.CredentialsCollection, uid)
+}
+
+func (p *FirebaseProvider) HasCredentials(ctx context.Context, uid string) (bool, error) {
+	if err := p.layoutError(); err != nil {
+		return false, err
+	}
+	doc, err := firestore.GetDocument(ctx, p.CredentialsCollection, uid)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return doc != nil, nil
+}
+
+func (p *FirebaseProvider) GetUser(ctx context.Context, uid string) (*User, error) {
+	u, err := auth.GetUser(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		UID:           u.UID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		PhotoURL:      u.PhotoURL,
+		Disabled:      u.Disabled,
+		CreationTime:  u.CreationTime,
+		LastLogInTime: u.LastLogInTime,
+		CustomClaims:  u.CustomClaims,
+	}, nil
+}
+
+func (p *FirebaseProvider) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := auth.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		UID:           u.UID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		PhotoURL:      u.PhotoURL,
+		Disabled:      u.Disabled,
+		CreationTime:  u.CreationTime,
+		LastLogInTime: u.LastLogInTime,
+		CustomClaims:  u.CustomClaims,
+	}, nil
+}
+
+func (p *FirebaseProvider) ListUsers(ctx context.Context, limit int, pageToken string) ([]*User, string, error) {
+	users, nextToken, err := auth.ListUsers(ctx, limit, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]*User, 0, len(users))
+	for _, u := range users {
+		result = append(result, &User{
+			UID:           u.UID,
+			Email:         u.Email,
+			EmailVerified: u.EmailVerified,
+			DisplayName:   u.DisplayName,
+			PhotoURL:      u.PhotoURL,
+			Disabled:      u.Disabled,
+			CreationTime:  u.CreationTime,
+			LastLogInTime: u.LastLogInTime,
+			CustomClaims:  u.CustomClaims,
+		})
+	}
+	return result, nextToken, nil
+}
+
+func (p *FirebaseProvider) UpdateUser(ctx context.Context, uid string, update UserToUpdate) (*User, error) {
+	u, err := auth.UpdateUser(ctx, uid, firebase.UpdateUserRequest{
+		Email:         update.Email,
+		Password:      update.Password,
+		DisplayName:   update.DisplayName,
+		PhotoURL:      update.PhotoURL,
+		EmailVerified: update.EmailVerified,
+		Disabled:      update.Disabled,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		UID:           u.UID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		PhotoURL:      u.PhotoURL,
+		Disabled:      u.Disabled,
+		CreationTime:  u.CreationTime,
+		LastLogInTime: u.LastLogInTime,
+		CustomClaims:  u.CustomClaims,
+	}, nil
+}
+
+func (p *FirebaseProvider) DeleteUser(ctx context.Context, uid string) error {
+	return auth.DeleteUser(ctx, uid)
+}
+
+func (p *FirebaseProvider) CountUsers(ctx context.Context) (int, error) {
+	return auth.GetUserCount(ctx)
+}
+
+func (p *FirebaseProvider) SetCustomClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	return auth.SetCustomClaims(ctx, uid, claims)
+}
+
+func (p *FirebaseProvider) Login(ctx context.Context, creds Credentials) (*LoginResult, error) {
+	resp, err := auth.Login(ctx, auth.LoginRequest{Email: creds.Email, Password: creds.Password})
+	if err != nil {
+		return nil, err
+	}
+	result := &LoginResult{
+		Success:     resp.Success,
+		Message:     resp.Message,
+		SessionID:   resp.SessionID,
+		CustomToken: resp.CustomToken,
+		ExpiresAt:   resp.ExpiresAt,
+		Claims:      resp.Claims,
+	}
+	if resp.User != nil {
+		result.UID = resp.User.UID
+	}
+	if result.Success && result.SessionID != "" {
+		p.checkLayout(p.verifySession(ctx, result.SessionID, result.UID))
+	}
+	return result, nil
+}
+
+func (p *FirebaseProvider) Logout(ctx context.Context, uid, sessionID string) error {
+	_, err := auth.Logout(ctx, auth.LogoutRequest{UID: uid, SessionID: sessionID})
+	return err
+}
+
+// ListSessions busca los documentos de sesión del usuario y valida cada uno con la
+// librería, que sabe si sigue activa y cuándo vence. Una sesión que la librería rechaza
+// (por ejemplo, vencida) se devuelve como inactiva.
+func (p *FirebaseProvider) ListSessions(ctx context.Context, uid string) ([]*Session, error) {
+	if err := p.layoutError(); err != nil {
+		return nil, err
+	}
+	docs, err := firestore.QueryDocuments(ctx, p.SessionsCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: p.SessionUIDField, Operator: "==", Value: uid}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(docs))
+	for _, doc := range docs {
+		session, err := p.ValidateSession(ctx, doc.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			session = &Session{ID: doc.ID, UID: uid}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// RevokeSessions borra los documentos de sesión del usuario: ValidateSession deja de
+// encontrarlas.
+func (p *FirebaseProvider) RevokeSessions(ctx context.Context, uid string) (int, error) {
+	if err := p.layoutError(); err != nil {
+		return 0, err
+	}
+	docs, err := firestore.QueryDocuments(ctx, p.SessionsCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: p.SessionUIDField, Operator: "==", Value: uid}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, doc := range docs {
+		if err := firestore.DeleteDocument(ctx, p.SessionsCollection, doc.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (p *FirebaseProvider) ValidateSession(ctx context.Context, sessionID string) (*Session, error) {
+	info, err := auth.ValidateSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:        sessionID,
+		UID:       info.UID,
+		Active:    info.Active,
+		ExpiresAt: info.ExpiresAt,
+		Claims:    info.Claims,
+	}, nil
+}
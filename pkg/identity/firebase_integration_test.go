@@ -0,0 +1,79 @@
+//go:build integration
+
+package identity
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// TestFirebaseStorageLayout comprueba contra la librería de auth real (un proyecto de pruebas
+// o el emulador) que las credenciales y las sesiones están donde las busca FirebaseProvider.
+// Se ejecuta con:
+//
+//	go test -tags integration ./pkg/identity/
+//
+// con la misma configuración de Firebase que la API y, si no son las de por defecto,
+// AUTH_CREDENTIALS_COLLECTION, AUTH_SESSIONS_COLLECTION y AUTH_SESSION_UID_FIELD.
+func TestFirebaseStorageLayout(t *testing.T) {
+	if err := firebase.InitFirebaseFromEnv(); err != nil {
+		t.Skipf("Firebase is not configured: %v", err)
+	}
+	defer firebase.Close()
+	ctx := context.Background()
+
+	p := NewFirebaseProvider()
+	if v := os.Getenv("AUTH_CREDENTIALS_COLLECTION"); v != "" {
+		p.CredentialsCollection = v
+	}
+	if v := os.Getenv("AUTH_SESSIONS_COLLECTION"); v != "" {
+		p.SessionsCollection = v
+	}
+	if v := os.Getenv("AUTH_SESSION_UID_FIELD"); v != "" {
+		p.SessionUIDField = v
+	}
+
+	email := fmt.Sprintf("layout-check-%d@example.com", time.Now().UnixNano())
+	user, err := p.CreateUser(ctx, UserToCreate{Email: email, Password: "secret123"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	defer p.DeleteUser(ctx, user.UID)
+
+	if err := p.StoreCredentials(ctx, user.UID, "secret123"); err != nil {
+		t.Fatalf("StoreCredentials: %v", err)
+	}
+	if err := p.verifyCredentials(ctx, user.UID); err != nil {
+		t.Fatalf("credentials: %v", err)
+	}
+	login, err := p.Login(ctx, Credentials{Email: email, Password: "secret123"})
+	if err != nil || !login.Success {
+		t.Fatalf("Login = %+v, %v", login, err)
+	}
+	if err := p.verifySession(ctx, login.SessionID, user.UID); err != nil {
+		t.Fatalf("session: %v", err)
+	}
+
+	sessions, err := p.ListSessions(ctx, user.UID)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != login.SessionID || !sessions[0].Active {
+		t.Fatalf("ListSessions = %+v, %v", sessions, err)
+	}
+	if revoked, err := p.RevokeSessions(ctx, user.UID); err != nil || revoked != 1 {
+		t.Fatalf("RevokeSessions = %d, %v", revoked, err)
+	}
+	if session, err := p.ValidateSession(ctx, login.SessionID); err == nil && session.Active {
+		t.Fatal("revoked session is still valid")
+	}
+
+	if err := p.DeleteCredentials(ctx, user.UID); err != nil {
+		t.Fatalf("DeleteCredentials: %v", err)
+	}
+	if ok, err := p.HasCredentials(ctx, user.UID); err != nil || ok {
+		t.Fatalf("HasCredentials after delete = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package identity abstrae el proveedor de identidad (usuarios, credenciales,
+// sesiones y custom claims) que usan los handlers y el middleware de sesión.
+//
+// FirebaseProvider delega en la librería firestore/lib/firebase/auth y
+// MemoryProvider implementa lo mismo en memoria para tests.
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUserNotFound se devuelve cuando no existe un usuario con el UID o email indicado.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailExists se devuelve al crear un usuario con un email ya registrado.
+	ErrEmailExists = errors.New("email already exists")
+	// ErrSessionNotFound se devuelve cuando la sesión no existe o no pertenece al usuario.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// User es la representación de un usuario del proveedor de identidad.
+type User struct {
+	UID           string
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	PhotoURL      string
+	Disabled      bool
+	CreationTime  time.Time
+	LastLogInTime time.Time
+	CustomClaims  map[string]interface{}
+}
+
+// UserToCreate contiene los datos para crear un usuario.
+type UserToCreate struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	DisplayName string `json:"display_name"`
+}
+
+// UserToUpdate contiene los campos a modificar de un usuario; los nil no se tocan.
+type UserToUpdate struct {
+	Email         *string `json:"email,omitempty"`
+	Password      *string `json:"password,omitempty"`
+	DisplayName   *string `json:"display_name,omitempty"`
+	PhotoURL      *string `json:"photo_url,omitempty"`
+	EmailVerified *bool   `json:"email_verified,omitempty"`
+	Disabled      *bool   `json:"disabled,omitempty"`
+}
+
+// Credentials son el email y la contraseña enviados al hacer login.
+type Credentials struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginResult es el resultado de un intento de login.
+// Success es false (sin error) cuando las credenciales no son válidas.
+type LoginResult struct {
+	Success     bool
+	Message     string
+	SessionID   string
+	CustomToken string
+	ExpiresAt   time.Time
+	UID         string
+	Claims      map[string]interface{}
+}
+
+// Session es una sesión validada.
+type Session struct {
+	ID        string
+	UID       string
+	Active    bool
+	ExpiresAt time.Time
+	Claims    map[string]interface{}
+}
+
+// Provider es el proveedor de identidad de la API.
+type Provider interface {
+	// CreateUser crea un usuario en el proveedor.
+	CreateUser(ctx context.Context, user UserToCreate) (*User, error)
+	// StoreCredentials guarda el hash de la contraseña que usa Login.
+	StoreCredentials(ctx context.Context, uid, password string) error
+	// DeleteCredentials borra las credenciales guardadas con StoreCredentials; no falla si
+	// el usuario no tiene.
+	DeleteCredentials(ctx context.Context, uid string) error
+	// HasCredentials indica si el usuario tiene credenciales guardadas.
+	HasCredentials(ctx context.Context, uid string) (bool, error)
+	// RevokeSessions cierra todas las sesiones del usuario y devuelve cuántas había.
+	RevokeSessions(ctx context.Context, uid string) (int, error)
+	// ListSessions devuelve las sesiones del usuario, activas o no.
+	ListSessions(ctx context.Context, uid string) ([]*Session, error)
+	// GetUser obtiene un usuario por UID.
+	GetUser(ctx context.Context, uid string) (*User, error)
+	// GetUserByEmail obtiene un usuario por email.
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// ListUsers lista usuarios paginados; devuelve el token de la siguiente página o "".
+	ListUsers(ctx context.Context, limit int, pageToken string) ([]*User, string, error)
+	// UpdateUser modifica un usuario y devuelve su estado final.
+	UpdateUser(ctx context.Context, uid string, update UserToUpdate) (*User, error)
+	// DeleteUser elimina un usuario.
+	DeleteUser(ctx context.Context, uid string) error
+	// CountUsers devuelve el número total de usuarios.
+	CountUsers(ctx context.Context) (int, error)
+	// SetCustomClaims reemplaza los custom claims de un usuario.
+	SetCustomClaims(ctx context.Context, uid string, claims map[string]interface{}) error
+
+	// Login valida las credenciales y abre una sesión.
+	Login(ctx context.Context, creds Credentials) (*LoginResult, error)
+	// Logout cierra la sesión sessionID del usuario uid.
+	Logout(ctx context.Context, uid, sessionID string) error
+	// ValidateSession devuelve la sesión y los claims asociados a sessionID.
+	ValidateSession(ctx context.Context, sessionID string) (*Session, error)
+}
@@ -0,0 +1,429 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionTTL es la duración de las sesiones que abre MemoryProvider.
+	DefaultSessionTTL = 24 * time.Hour
+
+	passwordIterations = 4096
+	minPasswordLength  = 6
+)
+
+type passwordHash struct {
+	salt []byte
+	hash []byte
+}
+
+type memorySession struct {
+	uid       string
+	expiresAt time.Time
+	active    bool
+}
+
+// MemoryProvider es un Provider en memoria pensado para tests.
+// Guarda las contraseñas con PBKDF2 y emite sesiones locales.
+type MemoryProvider struct {
+	mu          sync.RWMutex
+	users       map[string]*User
+	emails      map[string]string
+	credentials map[string]passwordHash
+	sessions    map[string]*memorySession
+
+	// SessionTTL es la duración de las sesiones nuevas.
+	SessionTTL time.Duration
+	now        func() time.Time
+}
+
+// NewMemoryProvider crea un MemoryProvider vacío.
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		users:       make(map[string]*User),
+		emails:      make(map[string]string),
+		credentials: make(map[string]passwordHash),
+		sessions:    make(map[string]*memorySession),
+		SessionTTL:  DefaultSessionTTL,
+		now:         time.Now,
+	}
+}
+
+func (p *MemoryProvider) CreateUser(ctx context.Context, user UserToCreate) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+	if email == "" {
+		return nil, fmt.Errorf("email is required")
+	}
+	if len(user.Password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.emails[email]; exists {
+		return nil, fmt.Errorf("%s: %w", email, ErrEmailExists)
+	}
+	u := &User{
+		UID:          randomToken(14),
+		Email:        email,
+		DisplayName:  user.DisplayName,
+		CreationTime: p.now(),
+	}
+	p.users[u.UID] = u
+	p.emails[email] = u.UID
+	return copyUser(u), nil
+}
+
+func (p *MemoryProvider) StoreCredentials(ctx context.Context, uid, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.users[uid]; !ok {
+		return fmt.Errorf("%s: %w", uid, ErrUserNotFound)
+	}
+	p.credentials[uid] = hashPassword(password)
+	return nil
+}
+
+func (p *MemoryProvider) DeleteCredentials(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.credentials, uid)
+	return nil
+}
+
+func (p *MemoryProvider) HasCredentials(ctx context.Context, uid string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.credentials[uid]
+	return ok, nil
+}
+
+func (p *MemoryProvider) GetUser(ctx context.Context, uid string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u, ok := p.users[uid]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", uid, ErrUserNotFound)
+	}
+	return copyUser(u), nil
+}
+
+func (p *MemoryProvider) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	uid, ok := p.emails[strings.ToLower(email)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", email, ErrUserNotFound)
+	}
+	return copyUser(p.users[uid]), nil
+}
+
+// ListUsers devuelve los usuarios ordenados por UID; el token de página es el UID
+// del primer usuario de la página siguiente.
+func (p *MemoryProvider) ListUsers(ctx context.Context, limit int, pageToken string) ([]*User, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	uids := make([]string, 0, len(p.users))
+	for uid := range p.users {
+		if uid >= pageToken {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+
+	nextToken := ""
+	if limit > 0 && len(uids) > limit {
+		nextToken = uids[limit]
+		uids = uids[:limit]
+	}
+	users := make([]*User, len(uids))
+	for i, uid := range uids {
+		users[i] = copyUser(p.users[uid])
+	}
+	return users, nextToken, nil
+}
+
+func (p *MemoryProvider) UpdateUser(ctx context.Context, uid string, update UserToUpdate) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.users[uid]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", uid, ErrUserNotFound)
+	}
+	if update.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*update.Email))
+		if owner, exists := p.emails[email]; exists && owner != uid {
+			return nil, fmt.Errorf("%s: %w", email, ErrEmailExists)
+		}
+		delete(p.emails, u.Email)
+		p.emails[email] = uid
+		u.Email = email
+	}
+	if update.Password != nil {
+		if len(*update.Password) < minPasswordLength {
+			return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+		}
+		p.credentials[uid] = hashPassword(*update.Password)
+	}
+	if update.DisplayName != nil {
+		u.DisplayName = *update.DisplayName
+	}
+	if update.PhotoURL != nil {
+		u.PhotoURL = *update.PhotoURL
+	}
+	if update.EmailVerified != nil {
+		u.EmailVerified = *update.EmailVerified
+	}
+	if update.Disabled != nil {
+		u.Disabled = *update.Disabled
+	}
+	return copyUser(u), nil
+}
+
+func (p *MemoryProvider) DeleteUser(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.users[uid]
+	if !ok {
+		return fmt.Errorf("%s: %w", uid, ErrUserNotFound)
+	}
+	delete(p.emails, u.Email)
+	delete(p.users, uid)
+	return nil
+}
+
+func (p *MemoryProvider) CountUsers(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.users), nil
+}
+
+func (p *MemoryProvider) SetCustomClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.users[uid]
+	if !ok {
+		return fmt.Errorf("%s: %w", uid, ErrUserNotFound)
+	}
+	u.CustomClaims = copyClaims(claims)
+	return nil
+}
+
+func (p *MemoryProvider) Login(ctx context.Context, creds Credentials) (*LoginResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	invalid := &LoginResult{Success: false, Message: "Credenciales inválidas"}
+	uid, ok := p.emails[strings.ToLower(creds.Email)]
+	if !ok {
+		return invalid, nil
+	}
+	stored, ok := p.credentials[uid]
+	if !ok || !stored.matches(creds.Password) {
+		return invalid, nil
+	}
+	u := p.users[uid]
+	if u.Disabled {
+		return &LoginResult{Success: false, Message: "Usuario deshabilitado"}, nil
+	}
+
+	now := p.now()
+	sessionID := randomToken(32)
+	session := &memorySession{uid: uid, expiresAt: now.Add(p.SessionTTL), active: true}
+	p.sessions[sessionID] = session
+	u.LastLogInTime = now
+
+	return &LoginResult{
+		Success:     true,
+		Message:     "Login exitoso",
+		SessionID:   sessionID,
+		CustomToken: randomToken(32),
+		ExpiresAt:   session.expiresAt,
+		UID:         uid,
+		Claims:      copyClaims(u.CustomClaims),
+	}, nil
+}
+
+func (p *MemoryProvider) Logout(ctx context.Context, uid, sessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session, ok := p.sessions[sessionID]
+	if !ok || session.uid != uid {
+		return ErrSessionNotFound
+	}
+	session.active = false
+	return nil
+}
+
+func (p *MemoryProvider) RevokeSessions(ctx context.Context, uid string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	revoked := 0
+	for id, session := range p.sessions {
+		if session.uid == uid {
+			delete(p.sessions, id)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// ListSessions devuelve las sesiones del usuario ordenadas por vencimiento.
+func (p *MemoryProvider) ListSessions(ctx context.Context, uid string) ([]*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	u, exists := p.users[uid]
+	var sessions []*Session
+	for id, session := range p.sessions {
+		if session.uid != uid {
+			continue
+		}
+		sessions = append(sessions, &Session{
+			ID:        id,
+			UID:       uid,
+			Active:    exists && session.active && !u.Disabled && p.now().Before(session.expiresAt),
+			ExpiresAt: session.expiresAt,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt) })
+	return sessions, nil
+}
+
+// ValidateSession devuelve los claims actuales del usuario, de modo que los cambios
+// hechos con SetCustomClaims se aplican sin volver a hacer login.
+func (p *MemoryProvider) ValidateSession(ctx context.Context, sessionID string) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	session, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	u, ok := p.users[session.uid]
+	if !ok {
+		return &Session{ID: sessionID, UID: session.uid, Active: false, ExpiresAt: session.expiresAt}, nil
+	}
+	return &Session{
+		ID:        sessionID,
+		UID:       session.uid,
+		Active:    session.active && !u.Disabled && p.now().Before(session.expiresAt),
+		ExpiresAt: session.expiresAt,
+		Claims:    copyClaims(u.CustomClaims),
+	}, nil
+}
+
+func hashPassword(password string) passwordHash {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return passwordHash{salt: salt, hash: derivePassword(password, salt)}
+}
+
+func (h passwordHash) matches(password string) bool {
+	return hmac.Equal(h.hash, derivePassword(password, h.salt))
+}
+
+func derivePassword(password string, salt []byte) []byte {
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func copyUser(u *User) *User {
+	clone := *u
+	clone.CustomClaims = copyClaims(u.CustomClaims)
+	return &clone
+}
+
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	if claims == nil {
+		return nil
+	}
+	clone := make(map[string]interface{}, len(claims))
+	for key, value := range claims {
+		clone[key] = value
+	}
+	return clone
+}
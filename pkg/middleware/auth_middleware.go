@@ -1,26 +1,25 @@
 package middleware
 
 import (
-	"context"
 	"crypto/subtle"
 	"net/http"
-	"os"
 
-	"github.com/andrescris/firestore/lib/firebase/auth"
+	"github.com/andrescris/alimedia/pkg/identity"
 	"github.com/gin-gonic/gin"
 )
 
 // APIKeyAuthMiddleware se encarga de verificar el API Key estática en las solicitudes.
-func APIKeyAuthMiddleware() gin.HandlerFunc {
-	requiredAPIKey := os.Getenv("API_KEY")
-	if requiredAPIKey == "" {
-		// Esto es un log fatal porque el servidor no puede operar de forma segura sin esta clave.
-		panic("Error: La variable de entorno API_KEY no está definida.")
-	}
-
+// Acepta cualquiera de las claves configuradas, lo que permite rotarlas sin cortar el servicio.
+func APIKeyAuthMiddleware(apiKeys []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientKey := c.GetHeader("X-API-KEY")
-		if subtle.ConstantTimeCompare([]byte(clientKey), []byte(requiredAPIKey)) != 1 {
+		clientKey := []byte(c.GetHeader("X-API-KEY"))
+		valid := false
+		for _, key := range apiKeys {
+			if subtle.ConstantTimeCompare(clientKey, []byte(key)) == 1 {
+				valid = true
+			}
+		}
+		if !valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API Key inválida o no proporcionada."})
 			return
 		}
@@ -28,8 +27,9 @@ func APIKeyAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-
-func SessionAuthMiddleware() gin.HandlerFunc {
+// SessionAuthMiddleware valida la sesión (X-Session-ID) contra el proveedor de identidad
+// y el acceso del usuario al subdominio indicado en X-Client-Subdomain.
+func SessionAuthMiddleware(users identity.Provider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionID := c.GetHeader("X-Session-ID")
 		clientSubdomain := c.GetHeader("X-Client-Subdomain")
@@ -40,7 +40,10 @@ func SessionAuthMiddleware() gin.HandlerFunc {
 		}
 
 		// 1. Validar la sesión
-		sessionInfo, err := auth.ValidateSession(context.Background(), sessionID)
+		sessionInfo, err := users.ValidateSession(c.Request.Context(), sessionID)
+		if AbortIfContextDone(c, err) {
+			return
+		}
 		if err != nil || !sessionInfo.Active {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Sesión inválida o expirada."})
 			return
@@ -48,11 +51,12 @@ func SessionAuthMiddleware() gin.HandlerFunc {
 
 		// 2. Validar permiso para el subdominio
 		claims := sessionInfo.Claims
-		
+
 		// Un admin tiene acceso a todo
 		if role, ok := claims["role"].(string); ok && role == "admin" {
 			// Si es admin, guardamos los datos y continuamos
 			c.Set("uid", sessionInfo.UID)
+			c.Set("session_id", sessionID)
 			c.Set("claims", claims)
 			c.Set("subdomain", clientSubdomain) // El admin opera en el subdominio que elija
 			c.Next()
@@ -81,9 +85,10 @@ func SessionAuthMiddleware() gin.HandlerFunc {
 
 		// 3. Si todo está bien, guardamos los datos en el contexto
 		c.Set("uid", sessionInfo.UID)
+		c.Set("session_id", sessionID)
 		c.Set("claims", claims)
 		c.Set("subdomain", clientSubdomain) // Guardamos el subdominio validado
-		
+
 		c.Next()
 	}
 }
@@ -112,7 +117,7 @@ func SubdomainMatchMiddleware() gin.HandlerFunc {
 				return
 			}
 		}
-		
+
 		c.Next()
 	}
 }
@@ -144,4 +149,3 @@ func AdminOnlyMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
-
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/gin-gonic/gin"
+)
+
+// CollectionAccessMiddleware rechaza las colecciones internas y las que no están en el
+// registro (o están deshabilitadas), y deja su configuración en "collection_settings".
+// Responde 404 en todos los casos para no revelar qué colecciones existen.
+func CollectionAccessMiddleware(collections config.CollectionsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("collection")
+		settings, ok := collections.Lookup(name)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error":      "Collection not found",
+				"collection": name,
+			})
+			return
+		}
+		c.Set("collection_settings", settings)
+		c.Next()
+	}
+}
+
+// CollectionWriteMiddleware protege las rutas que modifican documentos: las colecciones
+// read_only no admiten escrituras y las globales solo las admiten de administradores.
+// Debe ir después de CollectionAccessMiddleware y SessionAuthMiddleware.
+func CollectionWriteMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("collection_settings")
+		settings, _ := value.(config.CollectionSettings)
+
+		claims, _ := c.Get("claims")
+		claimsMap, _ := claims.(map[string]interface{})
+		role, _ := claimsMap["role"].(string)
+
+		if reason := CollectionWriteDenied(settings, role == "admin"); reason != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": reason})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CollectionWriteDenied devuelve por qué no se puede escribir en una colección con
+// settings, o "" si se puede. La usan también las rutas que escriben en varias colecciones.
+func CollectionWriteDenied(settings config.CollectionSettings, admin bool) string {
+	if settings.ReadOnly {
+		return "La colección es de solo lectura"
+	}
+	if settings.Scope == config.ScopeGlobal && !admin {
+		return "Solo los administradores pueden modificar colecciones globales"
+	}
+	return ""
+}
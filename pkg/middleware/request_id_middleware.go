@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader es la cabecera con el identificador de la petición.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limita el ID que aceptamos del cliente, que acaba en el registro de auditoría.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware asigna a cada petición un ID (el de X-Request-ID si el cliente o el
+// proxy lo envían), lo guarda en el contexto como "request_id" y lo devuelve en la respuesta.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err != nil {
+				panic(err)
+			}
+			requestID = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest es el código (convención de nginx) que registramos
+// cuando el cliente se desconecta antes de recibir la respuesta.
+const statusClientClosedRequest = 499
+
+// TimeoutMiddleware aplica un deadline al contexto de cada petición. routeTimeouts
+// permite sobreescribir el valor por defecto por ruta, con claves "MÉTODO /ruta/:param";
+// un timeout 0 desactiva el deadline.
+func TimeoutMiddleware(defaultTimeout time.Duration, routeTimeouts map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := defaultTimeout
+		if t, ok := routeTimeouts[c.Request.Method+" "+c.FullPath()]; ok {
+			timeout = t
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		// Si el handler no llegó a responder y el plazo venció, devolvemos el 504.
+		if !c.Writer.Written() {
+			AbortIfContextDone(c, ctx.Err())
+		}
+	}
+}
+
+// AbortIfContextDone comprueba si err proviene del contexto de la petición. Si venció el
+// deadline responde 504; si el cliente se desconectó aborta sin cuerpo. Devuelve true en
+// ambos casos, para que el handler deje de procesar la petición.
+func AbortIfContextDone(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{
+			"success": false,
+			"error":   "La petición excedió el tiempo máximo de respuesta.",
+			"path":    c.FullPath(),
+		})
+		return true
+	case errors.Is(err, context.Canceled):
+		log.Printf("Request canceled by client: %s %s", c.Request.Method, c.Request.URL.Path)
+		c.AbortWithStatus(statusClientClosedRequest)
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,324 @@
+// Package patch aplica JSON Merge Patch (RFC 7396) y JSON Patch (RFC 6902) sobre los
+// datos de un documento. Nunca modifica el mapa recibido: trabaja sobre una copia.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalid indica un patch mal formado (operación desconocida, ruta inválida...).
+	ErrInvalid = errors.New("invalid patch")
+	// ErrConflict indica un patch bien formado que no se puede aplicar al documento
+	// actual: una operación test que falla o una ruta que no existe.
+	ErrConflict = errors.New("patch does not apply to the current document")
+)
+
+// Operation es una operación de JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Merge aplica un JSON Merge Patch: las claves con null se eliminan, los objetos se
+// fusionan recursivamente y cualquier otro valor reemplaza al anterior.
+func Merge(doc, patch map[string]interface{}) map[string]interface{} {
+	target, _ := copyValue(doc).(map[string]interface{})
+	return mergeObject(target, patch)
+}
+
+func mergeObject(target, patch map[string]interface{}) map[string]interface{} {
+	if target == nil {
+		target = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]interface{}:
+			existing, _ := target[key].(map[string]interface{})
+			target[key] = mergeObject(existing, v)
+		default:
+			target[key] = copyValue(v)
+		}
+	}
+	return target
+}
+
+// Apply aplica las operaciones de JSON Patch en orden. Si una falla no se aplica ninguna.
+func Apply(doc map[string]interface{}, ops []Operation) (map[string]interface{}, error) {
+	var root interface{} = copyValue(doc)
+	for i, op := range ops {
+		var err error
+		if root, err = op.apply(root); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	result, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: the document must remain a JSON object", ErrInvalid)
+	}
+	return result, nil
+}
+
+func (op Operation) apply(root interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			return replace(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, fmt.Errorf("%w: test failed", ErrConflict)
+			}
+			return root, nil
+		}
+
+	case "remove":
+		return remove(root, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(root, path, copyValue(value))
+		}
+		if isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", ErrInvalid)
+		}
+		if root, err = remove(root, from); err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalid, op.Op)
+	}
+}
+
+func (op Operation) value() (interface{}, error) {
+	if len(op.Value) == 0 {
+		return nil, fmt.Errorf("%w: missing value", ErrInvalid)
+	}
+	var value interface{}
+	if err := json.Unmarshal(op.Value, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return value, nil
+}
+
+// parsePointer convierte un JSON Pointer (RFC 6901) en sus tokens. "" es el documento completo.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalid, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func get(root interface{}, path []string) (interface{}, error) {
+	node := root
+	for _, token := range path {
+		var err error
+		if node, err = child(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func add(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(root, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(token, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("%w: parent of %q is not an object or array", ErrConflict, token)
+		}
+	})
+}
+
+func remove(root interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalid)
+	}
+	return update(root, path, func(container interface{}, token string) (interface{}, error) {
+		if _, err := child(container, token); err != nil {
+			return nil, err
+		}
+		switch c := container.(type) {
+		case map[string]interface{}:
+			delete(c, token)
+			return c, nil
+		default:
+			list := c.([]interface{})
+			i, _ := index(token, len(list))
+			return append(list[:i], list[i+1:]...), nil
+		}
+	})
+}
+
+func replace(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(root, path, func(container interface{}, token string) (interface{}, error) {
+		if _, err := child(container, token); err != nil {
+			return nil, err
+		}
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		default:
+			list := c.([]interface{})
+			i, _ := index(token, len(list))
+			list[i] = value
+			return list, nil
+		}
+	})
+}
+
+// update recorre path hasta el contenedor del último token, le aplica leaf y vuelve a
+// colocar los contenedores modificados (los slices pueden cambiar al insertar o borrar).
+func update(node interface{}, path []string, leaf func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return leaf(node, path[0])
+	}
+	next, err := child(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := update(next, path[1:], leaf)
+	if err != nil {
+		return nil, err
+	}
+	switch c := node.(type) {
+	case map[string]interface{}:
+		c[path[0]] = updated
+	case []interface{}:
+		i, _ := index(path[0], len(c))
+		c[i] = updated
+	}
+	return node, nil
+}
+
+func child(node interface{}, token string) (interface{}, error) {
+	switch c := node.(type) {
+	case map[string]interface{}:
+		value, ok := c[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q not found", ErrConflict, token)
+		}
+		return value, nil
+	case []interface{}:
+		i, err := index(token, len(c))
+		if err != nil {
+			return nil, err
+		}
+		return c[i], nil
+	default:
+		return nil, fmt.Errorf("%w: %q is not inside an object or array", ErrConflict, token)
+	}
+}
+
+// index valida un índice de array de JSON Pointer (sin ceros a la izquierda) menor que limit.
+func index(token string, limit int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalid, token)
+	}
+	if i >= limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrConflict, i)
+	}
+	return i, nil
+}
+
+// equal compara por representación JSON, para que 3 (int64 del backend) y 3.0 (del
+// cuerpo de la petición) sean iguales.
+func equal(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// copyValue copia en profundidad mapas y slices conservando el tipo de los demás valores.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = copyValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
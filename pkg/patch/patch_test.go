@@ -0,0 +1,83 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, raw string, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var doc, p, want map[string]interface{}
+	decodeJSON(t, `{"a": "b", "c": {"d": "e", "f": "g"}, "tags": ["x"]}`, &doc)
+	decodeJSON(t, `{"a": "z", "c": {"f": null, "h": 1}, "tags": ["y"]}`, &p)
+	decodeJSON(t, `{"a": "z", "c": {"d": "e", "h": 1}, "tags": ["y"]}`, &want)
+
+	got := Merge(doc, p)
+	if !equal(got, want) {
+		t.Fatalf("Merge = %v, want %v", got, want)
+	}
+	if doc["a"] != "b" {
+		t.Fatalf("Merge modified the original document: %v", doc)
+	}
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{"status": "new", "address": {"city": "Lima", "zip": "15001"}, "items": [1, 2, 3], "a/b": 1}`
+
+	tests := []struct {
+		name    string
+		ops     string
+		want    string
+		wantErr error
+	}{
+		{"add nested field", `[{"op": "add", "path": "/address/street", "value": "Av. Sol"}]`,
+			`{"status": "new", "address": {"city": "Lima", "zip": "15001", "street": "Av. Sol"}, "items": [1, 2, 3], "a/b": 1}`, nil},
+		{"replace and remove", `[{"op": "replace", "path": "/address/city", "value": "Cusco"}, {"op": "remove", "path": "/address/zip"}]`,
+			`{"status": "new", "address": {"city": "Cusco"}, "items": [1, 2, 3], "a/b": 1}`, nil},
+		{"array insert, append and remove", `[{"op": "add", "path": "/items/0", "value": 0}, {"op": "add", "path": "/items/-", "value": 4}, {"op": "remove", "path": "/items/2"}]`,
+			`{"status": "new", "address": {"city": "Lima", "zip": "15001"}, "items": [0, 1, 3, 4], "a/b": 1}`, nil},
+		{"move and copy", `[{"op": "copy", "from": "/address/city", "path": "/city"}, {"op": "move", "from": "/status", "path": "/state"}]`,
+			`{"state": "new", "city": "Lima", "address": {"city": "Lima", "zip": "15001"}, "items": [1, 2, 3], "a/b": 1}`, nil},
+		{"escaped pointer", `[{"op": "test", "path": "/a~1b", "value": 1}, {"op": "remove", "path": "/a~1b"}]`,
+			`{"status": "new", "address": {"city": "Lima", "zip": "15001"}, "items": [1, 2, 3]}`, nil},
+		{"test passes", `[{"op": "test", "path": "/address", "value": {"zip": "15001", "city": "Lima"}}]`, doc, nil},
+		{"test fails", `[{"op": "test", "path": "/status", "value": "paid"}]`, "", ErrConflict},
+		{"missing path", `[{"op": "replace", "path": "/nope", "value": 1}]`, "", ErrConflict},
+		{"index out of range", `[{"op": "remove", "path": "/items/3"}]`, "", ErrConflict},
+		{"unknown op", `[{"op": "merge", "path": "/status"}]`, "", ErrInvalid},
+		{"missing value", `[{"op": "add", "path": "/status"}]`, "", ErrInvalid},
+		{"move into child", `[{"op": "move", "from": "/address", "path": "/address/old"}]`, "", ErrInvalid},
+		{"replace root with scalar", `[{"op": "replace", "path": "", "value": 1}]`, "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original map[string]interface{}
+			var ops []Operation
+			decodeJSON(t, doc, &original)
+			decodeJSON(t, tt.ops, &ops)
+
+			got, err := Apply(original, ops)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want map[string]interface{}
+			decodeJSON(t, tt.want, &want)
+			if !equal(got, want) {
+				t.Fatalf("Apply = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package privacy reúne las piezas de las solicitudes de protección de datos (RGPD) que
+// no dependen de HTTP: el seudónimo que sustituye a un usuario borrado y el recibo firmado
+// que acredita el borrado.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Modos de borrado de los documentos que creó el usuario.
+const (
+	// ModeDelete borra los documentos.
+	ModeDelete = "delete"
+	// ModePseudonymize conserva los documentos y sustituye el UID por un seudónimo.
+	ModePseudonymize = "pseudonymize"
+)
+
+// Erased es lo que eliminó (o seudonimizó) un borrado.
+type Erased struct {
+	SessionsRevoked    int  `json:"sessions_revoked"`
+	ProfilesDeleted    int  `json:"profiles_deleted"`
+	ClaimsDeleted      bool `json:"claims_deleted"`
+	CredentialsDeleted bool `json:"credentials_deleted"`
+	UserDeleted        bool `json:"user_deleted"`
+	// Documents es cuántos documentos creados por el usuario se borraron o seudonimizaron
+	// por colección.
+	Documents        map[string]int `json:"documents"`
+	RevisionsDeleted int            `json:"revisions_deleted"`
+	// TrashDeleted es cuántas entradas de la papelera se borraron o seudonimizaron.
+	TrashDeleted int `json:"trash_deleted"`
+	// UpdatedByReplaced es cuántos documentos (o entradas de la papelera) de otros usuarios
+	// tenían al usuario en updated_by.
+	UpdatedByReplaced int `json:"updated_by_replaced"`
+	// RevisionsRedacted es cuántas revisiones de documentos de otros usuarios tenían al
+	// usuario como actor o en updated_by.
+	RevisionsRedacted int `json:"revisions_redacted"`
+	// WebhookDeliveriesRedacted es cuántas entregas de webhooks perdieron los datos del usuario.
+	WebhookDeliveriesRedacted int `json:"webhook_deliveries_redacted"`
+	// AuditEventsRedacted es cuántos eventos de auditoría perdieron los datos del usuario.
+	AuditEventsRedacted int `json:"audit_events_redacted"`
+}
+
+// Retention son datos del usuario que el borrado no pudo eliminar, con el motivo.
+type Retention struct {
+	Collection string `json:"collection"`
+	Count      int    `json:"count"`
+	Reason     string `json:"reason"`
+}
+
+// Receipt es el recibo de un borrado completado. Signature cubre el resto de campos, así
+// que cualquier cambio lo invalida.
+type Receipt struct {
+	ID          string    `json:"id"`
+	UID         string    `json:"uid"`
+	Mode        string    `json:"mode"`
+	Pseudonym   string    `json:"pseudonym,omitempty"`
+	RequestedBy string    `json:"requested_by"`
+	CompletedAt time.Time `json:"completed_at"`
+	Erased      Erased    `json:"erased"`
+	// Retained son las excepciones al borrado.
+	Retained  []Retention `json:"retained,omitempty"`
+	Signature string      `json:"signature,omitempty"`
+}
+
+// NewReceiptID genera un ID aleatorio para un recibo.
+func NewReceiptID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Pseudonym es el seudónimo estable de uid: el mismo secreto y UID dan siempre el mismo,
+// de modo que los documentos del usuario siguen relacionados entre sí, pero sin el
+// secreto no se puede volver al UID.
+func Pseudonym(secret []byte, uid string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(uid))
+	return "erased-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Sign firma el recibo con secret.
+func (r *Receipt) Sign(secret []byte) {
+	r.Signature = r.signature(secret)
+}
+
+// Verify indica si la firma del recibo es la de secret.
+func (r Receipt) Verify(secret []byte) bool {
+	return r.Signature != "" && hmac.Equal([]byte(r.Signature), []byte(r.signature(secret)))
+}
+
+// signature es "sha256=" + el HMAC-SHA256 del JSON del recibo sin firma. El JSON de un
+// struct (y de un map, con las claves ordenadas) es determinista, así que el recibo
+// firmado sobrevive a la ida y vuelta por JSON.
+func (r Receipt) signature(secret []byte) string {
+	r.Signature = ""
+	r.CompletedAt = r.CompletedAt.UTC()
+	body, err := json.Marshal(r)
+	if err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package privacy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiptSignature(t *testing.T) {
+	secret := []byte("receipt-secret")
+	receipt := Receipt{
+		ID:          NewReceiptID(),
+		UID:         "u1",
+		Mode:        ModePseudonymize,
+		Pseudonym:   Pseudonym(secret, "u1"),
+		RequestedBy: "admin",
+		CompletedAt: time.Date(2026, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600)),
+		Erased:      Erased{SessionsRevoked: 2, UserDeleted: true, Documents: map[string]int{"orders": 3, "notes": 1}},
+	}
+	receipt.Sign(secret)
+	if !strings.HasPrefix(receipt.Signature, "sha256=") || !receipt.Verify(secret) {
+		t.Fatalf("signed receipt does not verify: %+v", receipt)
+	}
+
+	// La firma sobrevive a la ida y vuelta por JSON, que es como la recibe el cliente
+	body, _ := json.Marshal(receipt)
+	var decoded Receipt
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Verify(secret) {
+		t.Fatal("decoded receipt does not verify")
+	}
+
+	tampered := decoded
+	tampered.Erased.Documents = map[string]int{"orders": 4, "notes": 1}
+	for name, r := range map[string]Receipt{
+		"tampered":  tampered,
+		"unsigned":  {UID: "u1"},
+		"other uid": func() Receipt { r := decoded; r.UID = "u2"; return r }(),
+	} {
+		if r.Verify(secret) {
+			t.Errorf("%s: receipt verifies", name)
+		}
+	}
+	if decoded.Verify([]byte("other-secret")) {
+		t.Error("receipt verifies with another secret")
+	}
+}
+
+func TestPseudonym(t *testing.T) {
+	secret := []byte("receipt-secret")
+	p := Pseudonym(secret, "u1")
+	if !strings.HasPrefix(p, "erased-") || len(p) != len("erased-")+16 {
+		t.Fatalf("pseudonym = %q", p)
+	}
+	if Pseudonym(secret, "u1") != p {
+		t.Error("pseudonym is not stable")
+	}
+	if Pseudonym(secret, "u2") == p || Pseudonym([]byte("other"), "u1") == p {
+		t.Error("pseudonym collides")
+	}
+}
@@ -0,0 +1,211 @@
+// Package reconcile busca las cuentas a medias que dejan las altas de usuario fallidas
+// (usuarios de Auth sin perfil o sin credenciales, perfiles de usuarios que ya no
+// existen) y, si se pide, las repara.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+const (
+	// ProfilesCollection es la colección de perfiles que crea el alta de usuarios (campo user_id).
+	ProfilesCollection = "profiles"
+	// ClaimsCollection es la copia en Firestore de los custom claims, con el UID como ID.
+	ClaimsCollection = "user_claims"
+
+	// pageSize es el número de usuarios que se leen por página.
+	pageSize = 500
+)
+
+// Tipos de problema.
+const (
+	// OrphanedUser es un usuario de Auth sin perfil.
+	OrphanedUser = "orphaned_user"
+	// MissingCredentials es un usuario con perfil pero sin credenciales: no puede hacer login.
+	MissingCredentials = "missing_credentials"
+	// OrphanedProfile es un perfil cuyo user_id no existe en Auth.
+	OrphanedProfile = "orphaned_profile"
+)
+
+// Reparaciones.
+const (
+	// RepairDeleteUser borra el usuario de Auth, sus credenciales y su copia de claims.
+	RepairDeleteUser = "delete_user"
+	// RepairDeleteProfile borra el perfil.
+	RepairDeleteProfile = "delete_profile"
+)
+
+// Issue es un problema encontrado.
+type Issue struct {
+	Kind      string `json:"kind"`
+	UID       string `json:"uid"`
+	Email     string `json:"email,omitempty"`
+	ProfileID string `json:"profile_id,omitempty"`
+	// Repair es la reparación que corresponde; vacía si hay que revisarlo a mano.
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report es el resultado de una ejecución de Run.
+type Report struct {
+	Repair          bool      `json:"repair"`
+	StartedAt       time.Time `json:"started_at"`
+	UsersScanned    int       `json:"users_scanned"`
+	ProfilesScanned int       `json:"profiles_scanned"`
+	// Skipped son los usuarios creados dentro del periodo de gracia.
+	Skipped  int     `json:"skipped"`
+	Issues   []Issue `json:"issues"`
+	Repaired int     `json:"repaired"`
+	Failed   int     `json:"failed"`
+}
+
+// Options configura Run.
+type Options struct {
+	// Repair aplica las reparaciones; sin él Run solo informa.
+	Repair bool
+	// GracePeriod excluye los usuarios creados hace menos de este tiempo.
+	GracePeriod time.Duration
+}
+
+// Run recorre los usuarios de Auth y los perfiles y devuelve los problemas encontrados.
+//
+// Solo se proponen reparaciones seguras: se borran los usuarios sin perfil que tampoco
+// tienen credenciales y nunca han hecho login, porque su alta se quedó antes de guardar la
+// contraseña, y los perfiles huérfanos. Un usuario sin perfil con credenciales o con login
+// puede ser una cuenta en uso creada fuera de la API: solo se informa. A un usuario sin
+// credenciales no se le puede inventar la contraseña: se informa para que la restablezca.
+func Run(ctx context.Context, users identity.Provider, docs storage.DocumentStore, opts Options) (*Report, error) {
+	report := &Report{Repair: opts.Repair, StartedAt: time.Now().UTC(), Issues: []Issue{}}
+	cutoff := report.StartedAt.Add(-opts.GracePeriod)
+
+	// Los perfiles se leen antes que los usuarios: un perfil siempre se crea después que
+	// su usuario, así que un perfil leído aquí tiene su usuario en el listado posterior
+	all, err := docs.GetAllDocuments(ctx, ProfilesCollection)
+	if err != nil {
+		return nil, fmt.Errorf("list profiles: %w", err)
+	}
+	report.ProfilesScanned = len(all)
+	profiles := map[string][]*firebase.Document{}
+	for _, doc := range all {
+		uid, _ := doc.Data["user_id"].(string)
+		profiles[uid] = append(profiles[uid], doc)
+	}
+
+	existing := map[string]bool{}
+	pageToken := ""
+	for {
+		page, next, err := users.ListUsers(ctx, pageSize, pageToken)
+		if err != nil {
+			return nil, fmt.Errorf("list users: %w", err)
+		}
+		for _, user := range page {
+			existing[user.UID] = true
+			report.UsersScanned++
+			if user.CreationTime.After(cutoff) {
+				report.Skipped++
+				continue
+			}
+			hasCredentials, err := users.HasCredentials(ctx, user.UID)
+			if err != nil {
+				return nil, fmt.Errorf("check credentials of %s: %w", user.UID, err)
+			}
+			if len(profiles[user.UID]) == 0 {
+				issue := Issue{Kind: OrphanedUser, UID: user.UID, Email: user.Email}
+				if !hasCredentials && user.LastLogInTime.IsZero() {
+					issue.Repair = RepairDeleteUser
+				}
+				report.add(issue, opts.Repair, func() error { return deleteUser(ctx, users, docs, user.UID) })
+				continue
+			}
+			if !hasCredentials {
+				report.add(Issue{Kind: MissingCredentials, UID: user.UID, Email: user.Email}, opts.Repair, nil)
+			}
+		}
+		if next == "" {
+			break
+		}
+		pageToken = next
+	}
+
+	uids := make([]string, 0, len(profiles))
+	for uid := range profiles {
+		if !existing[uid] {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		for _, doc := range profiles[uid] {
+			issue := Issue{Kind: OrphanedProfile, UID: uid, ProfileID: doc.ID, Repair: RepairDeleteProfile}
+			report.add(issue, opts.Repair, func() error { return docs.DeleteDocument(ctx, ProfilesCollection, doc.ID) })
+		}
+	}
+	return report, nil
+}
+
+// add añade issue al informe aplicando fix si hay que reparar.
+func (r *Report) add(issue Issue, repair bool, fix func() error) {
+	if repair && issue.Repair != "" {
+		if err := fix(); err != nil {
+			issue.Error = err.Error()
+			r.Failed++
+		} else {
+			issue.Repaired = true
+			r.Repaired++
+		}
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// deleteUser borra lo que queda de un alta a medias. El usuario de Auth va el último:
+// si algo falla antes, la siguiente ejecución lo vuelve a encontrar.
+func deleteUser(ctx context.Context, users identity.Provider, docs storage.DocumentStore, uid string) error {
+	if err := docs.DeleteDocument(ctx, ClaimsCollection, uid); err != nil {
+		return fmt.Errorf("delete claims: %w", err)
+	}
+	if err := users.DeleteCredentials(ctx, uid); err != nil {
+		return fmt.Errorf("delete credentials: %w", err)
+	}
+	if err := users.DeleteUser(ctx, uid); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return nil
+}
+
+// RunReconciler ejecuta Run cada interval hasta que ctx termine y registra el resultado.
+// Un interval de 0 desactiva la reconciliación periódica.
+func RunReconciler(ctx context.Context, users identity.Provider, docs storage.DocumentStore, interval time.Duration, opts Options) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := Run(ctx, users, docs, opts)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("⚠️ Error reconciliando usuarios: %v", err)
+				}
+				continue
+			}
+			if len(report.Issues) > 0 {
+				log.Printf("🧹 Reconciliación de usuarios: %d problemas, %d reparados, %d fallidos",
+					len(report.Issues), report.Repaired, report.Failed)
+			}
+		}
+	}
+}
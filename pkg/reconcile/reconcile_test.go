@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/identity"
+	"github.com/andrescris/alimedia/pkg/storage"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	users := identity.NewMemoryProvider()
+	docs := storage.NewMemoryStore()
+
+	// newUser crea un usuario con lo que haya llegado a guardar su alta
+	newUser := func(email string, credentials, profile, login bool) string {
+		t.Helper()
+		u, err := users.CreateUser(ctx, identity.UserToCreate{Email: email, Password: "secret123"})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if credentials {
+			users.StoreCredentials(ctx, u.UID, "secret123")
+		}
+		if profile {
+			docs.CreateDocument(ctx, ProfilesCollection, map[string]interface{}{"user_id": u.UID})
+		}
+		if login {
+			users.Login(ctx, identity.Credentials{Email: email, Password: "secret123"})
+		}
+		return u.UID
+	}
+	complete := newUser("complete@example.com", true, true, true)
+	half := newUser("half@example.com", false, false, false)
+	unused := newUser("unused@example.com", true, false, false)
+	legacy := newUser("legacy@example.com", true, false, true)
+	noCredentials := newUser("nocreds@example.com", false, true, false)
+	ghost, _ := docs.CreateDocument(ctx, ProfilesCollection, map[string]interface{}{"user_id": "ghost"})
+	docs.CreateDocumentWithID(ctx, ClaimsCollection, half, map[string]interface{}{"claims": map[string]interface{}{}})
+
+	// Dentro del periodo de gracia los usuarios no se revisan; los perfiles sí
+	report, err := Run(ctx, users, docs, Options{GracePeriod: time.Hour})
+	if err != nil || report.Skipped != 5 || len(report.Issues) != 1 || report.Issues[0].Kind != OrphanedProfile {
+		t.Fatalf("Run with grace period = %+v, %v", report, err)
+	}
+
+	report, err = Run(ctx, users, docs, Options{Repair: true})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	got := map[string]Issue{}
+	for _, issue := range report.Issues {
+		got[issue.UID] = issue
+	}
+	want := map[string]Issue{
+		half:          {Kind: OrphanedUser, UID: half, Email: "half@example.com", Repair: RepairDeleteUser, Repaired: true},
+		unused:        {Kind: OrphanedUser, UID: unused, Email: "unused@example.com"},
+		legacy:        {Kind: OrphanedUser, UID: legacy, Email: "legacy@example.com"},
+		noCredentials: {Kind: MissingCredentials, UID: noCredentials, Email: "nocreds@example.com"},
+		"ghost":       {Kind: OrphanedProfile, UID: "ghost", ProfileID: ghost, Repair: RepairDeleteProfile, Repaired: true},
+	}
+	if len(got) != len(want) || report.Repaired != 2 || report.Failed != 0 || report.UsersScanned != 5 || report.ProfilesScanned != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+	for uid, issue := range want {
+		if got[uid] != issue {
+			t.Errorf("issue for %s = %+v, want %+v", uid, got[uid], issue)
+		}
+	}
+
+	// La reparación borra el usuario con sus credenciales y claims, y el perfil huérfano
+	if _, err := users.GetUser(ctx, half); err == nil {
+		t.Error("half-created user still exists")
+	}
+	if ok, _ := users.HasCredentials(ctx, half); ok {
+		t.Error("credentials of the half-created user still exist")
+	}
+	if _, err := docs.GetDocument(ctx, ClaimsCollection, half); err == nil {
+		t.Error("claims of the half-created user still exist")
+	}
+	if _, err := docs.GetDocument(ctx, ProfilesCollection, ghost); err == nil {
+		t.Error("orphaned profile still exists")
+	}
+	for _, uid := range []string{complete, unused, legacy} {
+		if _, err := users.GetUser(ctx, uid); err != nil {
+			t.Errorf("user %s: %v", uid, err)
+		}
+	}
+
+	// Una segunda ejecución ya solo encuentra lo que requiere revisión manual
+	report, _ = Run(ctx, users, docs, Options{Repair: true})
+	if len(report.Issues) != 3 || report.Repaired != 0 {
+		t.Fatalf("second run = %+v", report)
+	}
+}
@@ -0,0 +1,49 @@
+// Package revisions agrupa el acceso al historial de documentos que guardan los handlers,
+// para que la papelera y el borrado definitivo puedan eliminarlo junto al documento.
+package revisions
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// Collection guarda el historial de todas las colecciones.
+const Collection = "document_revisions"
+
+// deleteBatchSize es el número de revisiones que Delete borra por consulta.
+const deleteBatchSize = 100
+
+// Delete borra las revisiones de collection/docID creadas hasta until (todas si until es
+// cero) y devuelve cuántas borró. El límite permite purgar la historia de un documento
+// eliminado sin tocar la de uno que se volvió a crear después con el mismo ID.
+func Delete(ctx context.Context, store storage.DocumentStore, collection, docID string, until time.Time) (int, error) {
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{
+			{Field: "collection", Operator: "==", Value: collection},
+			{Field: "document_id", Operator: "==", Value: docID},
+		},
+		Limit: deleteBatchSize,
+	}
+	if !until.IsZero() {
+		options.Filters = append(options.Filters, firebase.QueryFilter{Field: "created_at", Operator: "<=", Value: until.UTC()})
+	}
+	deleted := 0
+	for {
+		docs, err := store.QueryDocuments(ctx, Collection, options)
+		if err != nil {
+			return deleted, err
+		}
+		for _, doc := range docs {
+			if err := store.DeleteDocument(ctx, Collection, doc.ID); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if len(docs) < deleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
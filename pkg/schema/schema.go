@@ -0,0 +1,427 @@
+// Package schema valida documentos contra un subconjunto de JSON Schema (draft 2020-12):
+// type, properties, required, additionalProperties, items, enum, const, minLength,
+// maxLength, pattern, format, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// minItems, maxItems y uniqueItems. Compile rechaza cualquier otra palabra clave para que
+// un esquema nunca se aplique a medias.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Schema es un esquema compilado.
+type Schema struct {
+	types                []string
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	format               string
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minItems, maxItems   *int
+	uniqueItems          bool
+}
+
+// FieldError es un error de validación de un campo. Field usa la notación con puntos
+// de los filtros (los índices de array también: "items.0.sku"); vacío es el documento.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// annotations son palabras clave sin efecto en la validación que se aceptan y se ignoran.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true,
+	"title": true, "description": true, "default": true, "examples": true,
+}
+
+var formats = map[string]func(string) bool{
+	"date-time": func(s string) bool { _, err := time.Parse(time.RFC3339, s); return err == nil },
+	"date":      func(s string) bool { _, err := time.Parse(time.DateOnly, s); return err == nil },
+	"email":     func(s string) bool { a, err := mail.ParseAddress(s); return err == nil && a.Address == s },
+	"uri":       func(s string) bool { u, err := url.Parse(s); return err == nil && u.Scheme != "" },
+}
+
+var validTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile valida y compila un esquema JSON decodificado.
+func Compile(raw map[string]interface{}) (*Schema, error) {
+	return compile(raw, "")
+}
+
+func compile(raw map[string]interface{}, at string) (*Schema, error) {
+	s := &Schema{}
+	fail := func(keyword, format string, args ...interface{}) error {
+		return fmt.Errorf("schema%s: %s: %s", at, keyword, fmt.Sprintf(format, args...))
+	}
+
+	for keyword, value := range raw {
+		switch keyword {
+		case "type":
+			switch v := value.(type) {
+			case string:
+				s.types = []string{v}
+			case []interface{}:
+				for _, item := range v {
+					name, ok := item.(string)
+					if !ok {
+						return nil, fail(keyword, "must be a string or an array of strings")
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fail(keyword, "must be a string or an array of strings")
+			}
+			for _, name := range s.types {
+				if !validTypes[name] {
+					return nil, fail(keyword, "unknown type %q", name)
+				}
+			}
+
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be an object")
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, sub := range props {
+				subMap, ok := sub.(map[string]interface{})
+				if !ok {
+					return nil, fail(keyword, "%q must be a schema object", name)
+				}
+				compiled, err := compile(subMap, at+"."+name)
+				if err != nil {
+					return nil, err
+				}
+				s.properties[name] = compiled
+			}
+
+		case "required":
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be an array of strings")
+			}
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fail(keyword, "must be an array of strings")
+				}
+				s.required = append(s.required, name)
+			}
+
+		case "additionalProperties":
+			switch v := value.(type) {
+			case bool:
+				s.noAdditional = !v
+			case map[string]interface{}:
+				compiled, err := compile(v, at+".*")
+				if err != nil {
+					return nil, err
+				}
+				s.additionalProperties = compiled
+			default:
+				return nil, fail(keyword, "must be a boolean or a schema object")
+			}
+
+		case "items":
+			itemMap, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fail(keyword, "must be a schema object")
+			}
+			compiled, err := compile(itemMap, at+"[]")
+			if err != nil {
+				return nil, err
+			}
+			s.items = compiled
+
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fail(keyword, "must be a non-empty array")
+			}
+			s.enum = list
+
+		case "const":
+			s.constValue, s.hasConst = value, true
+
+		case "minLength", "maxLength", "minItems", "maxItems":
+			n, ok := nonNegativeInt(value)
+			if !ok {
+				return nil, fail(keyword, "must be a non-negative integer")
+			}
+			switch keyword {
+			case "minLength":
+				s.minLength = &n
+			case "maxLength":
+				s.maxLength = &n
+			case "minItems":
+				s.minItems = &n
+			default:
+				s.maxItems = &n
+			}
+
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			f, ok := toFloat(value)
+			if !ok {
+				return nil, fail(keyword, "must be a number")
+			}
+			switch keyword {
+			case "minimum":
+				s.minimum = &f
+			case "maximum":
+				s.maximum = &f
+			case "exclusiveMinimum":
+				s.exclusiveMinimum = &f
+			default:
+				s.exclusiveMaximum = &f
+			}
+
+		case "pattern":
+			expr, ok := value.(string)
+			if !ok {
+				return nil, fail(keyword, "must be a string")
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fail(keyword, "%v", err)
+			}
+			s.pattern = re
+
+		case "format":
+			name, ok := value.(string)
+			if !ok || formats[name] == nil {
+				return nil, fail(keyword, "unsupported format %v (use date-time, date, email or uri)", value)
+			}
+			s.format = name
+
+		case "uniqueItems":
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fail(keyword, "must be a boolean")
+			}
+			s.uniqueItems = b
+
+		default:
+			if !annotations[keyword] {
+				return nil, fail(keyword, "unsupported keyword")
+			}
+		}
+	}
+	return s, nil
+}
+
+// Validate devuelve los errores de data ordenados por campo; ninguno si es válido.
+func (s *Schema) Validate(data map[string]interface{}) []FieldError {
+	var errs []FieldError
+	s.validate(data, "", &errs)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func (s *Schema) validate(value interface{}, field string, errs *[]FieldError) {
+	report := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !matchesAnyType(value, s.types) {
+		report("must be of type %s, got %s", joinTypes(s.types), typeName(value))
+		return
+	}
+	if s.hasConst && !equal(value, s.constValue) {
+		report("must be %s", jsonString(s.constValue))
+	}
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if equal(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("must be one of %s", jsonString(s.enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Field: join(field, name), Message: "is required"})
+			}
+		}
+		for name, item := range v {
+			if prop, ok := s.properties[name]; ok {
+				prop.validate(item, join(field, name), errs)
+				continue
+			}
+			switch {
+			case s.noAdditional:
+				*errs = append(*errs, FieldError{Field: join(field, name), Message: "is not allowed"})
+			case s.additionalProperties != nil:
+				s.additionalProperties.validate(item, join(field, name), errs)
+			}
+		}
+
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			report("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			report("must have at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			seen := make(map[string]bool, len(v))
+			for _, item := range v {
+				key := jsonString(item)
+				if seen[key] {
+					report("items must be unique")
+					break
+				}
+				seen[key] = true
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, join(field, strconv.Itoa(i)), errs)
+			}
+		}
+
+	case string:
+		length := len([]rune(v))
+		if s.minLength != nil && length < *s.minLength {
+			report("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			report("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("must match pattern %s", s.pattern)
+		}
+		if s.format != "" && !formats[s.format](v) {
+			report("must be a valid %s", s.format)
+		}
+
+	default:
+		if f, ok := toFloat(value); ok {
+			if s.minimum != nil && f < *s.minimum {
+				report("must be >= %v", *s.minimum)
+			}
+			if s.maximum != nil && f > *s.maximum {
+				report("must be <= %v", *s.maximum)
+			}
+			if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+				report("must be > %v", *s.exclusiveMinimum)
+			}
+			if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+				report("must be < %v", *s.exclusiveMaximum)
+			}
+		}
+	}
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, name := range types {
+		if typeName(value) == name || (name == "number" && typeName(value) == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeName devuelve el tipo JSON de value. Las fechas del backend cuentan como string.
+func typeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string, time.Time:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		f, ok := toFloat(v)
+		if !ok {
+			return fmt.Sprintf("%T", v)
+		}
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return jsonString(types)
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func nonNegativeInt(value interface{}) (int, bool) {
+	f, ok := toFloat(value)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// equal compara por representación JSON, para que 3 (int64 del backend) y 3.0 (del
+// cuerpo de la petición) sean iguales.
+func equal(a, b interface{}) bool {
+	return jsonString(a) == jsonString(b)
+}
+
+func jsonString(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return out
+}
+
+const orderSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["project_id", "total"],
+	"additionalProperties": false,
+	"properties": {
+		"project_id": {"type": "string", "minLength": 1},
+		"total": {"type": "number", "minimum": 0},
+		"status": {"enum": ["new", "paid"]},
+		"email": {"type": "string", "format": "email"},
+		"address": {
+			"type": "object",
+			"properties": {"zip": {"type": "string", "pattern": "^[0-9]{5}$"}}
+		},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {"type": "object", "required": ["sku"], "properties": {"qty": {"type": "integer"}}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Compile(decodeJSON(t, orderSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want []string // campos con error
+	}{
+		{"valid", `{"project_id": "p1", "total": 10, "status": "paid", "email": "a@b.co", "address": {"zip": "15001"}, "items": [{"sku": "x", "qty": 2}]}`, nil},
+		{"missing required", `{"project_id": "p1"}`, []string{"total"}},
+		{"wrong types", `{"project_id": 1, "total": "10"}`, []string{"project_id", "total"}},
+		{"nested errors", `{"project_id": "p1", "total": 1, "address": {"zip": "abc"}, "items": [{"qty": 1.5}]}`, []string{"address.zip", "items.0.qty", "items.0.sku"}},
+		{"enum, format and bounds", `{"project_id": "p1", "total": -1, "status": "lost", "email": "nope"}`, []string{"email", "status", "total"}},
+		{"additional property", `{"project_id": "p1", "total": 1, "extra": true}`, []string{"extra"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range s.Validate(decodeJSON(t, tt.doc)) {
+				got = append(got, e.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("fields with errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRejectsUnsupportedSchemas(t *testing.T) {
+	for _, raw := range []string{
+		`{"type": "objet"}`,
+		`{"oneOf": [{"type": "string"}]}`,
+		`{"properties": {"a": {"minLength": -1}}}`,
+		`{"format": "ipv6"}`,
+		`{"pattern": "("}`,
+	} {
+		if _, err := Compile(decodeJSON(t, raw)); err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", raw)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrAlreadyExists se devuelve al crear en un lote un documento con un ID que ya existe.
+var ErrAlreadyExists = errors.New("document already exists")
+
+// Operaciones de un lote de escrituras
+const (
+	// BatchCreate crea el documento con el ID indicado; falla si ya existe.
+	BatchCreate = "create"
+	// BatchSet crea o reemplaza el documento sin condiciones (como CreateDocumentWithID).
+	BatchSet = "set"
+	// BatchReplace sustituye todos los datos de un documento existente.
+	BatchReplace = "replace"
+	// BatchDelete elimina un documento existente.
+	BatchDelete = "delete"
+)
+
+// BatchWrite es una escritura de un lote. En BatchReplace y BatchDelete, si Version no
+// está vacía el documento debe tener esa versión (DocumentVersion) para aplicar el lote.
+// En BatchDelete, Data no se escribe: puede llevar el estado eliminado para quien lo necesite.
+type BatchWrite struct {
+	Op         string
+	Collection string
+	ID         string
+	Data       map[string]interface{}
+	Version    string
+}
+
+// BatchError indica qué escritura impidió aplicar el lote; Err es la causa
+// (ErrNotFound, ErrAlreadyExists, ErrVersionMismatch o un error del backend).
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch write %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+const idAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// NewDocumentID genera un ID aleatorio de 20 caracteres, con el mismo formato que Firestore.
+// Sirve para conocer el ID de un documento antes de crearlo (p. ej. en un lote).
+func NewDocumentID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	for i, b := range buf {
+		buf[i] = idAlphabet[int(b)%len(idAlphabet)]
+	}
+	return string(buf)
+}
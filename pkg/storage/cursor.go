@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// Cursor identifica la posición inmediatamente posterior al último documento de una página:
+// los valores de los campos de OrderBy de ese documento y su ID.
+type Cursor struct {
+	Values []interface{} `json:"v"`
+	ID     string        `json:"id"`
+}
+
+// CursorAfter construye el Cursor que apunta justo después de doc para la consulta options.
+func CursorAfter(doc *firebase.Document, options firebase.QueryOptions) *Cursor {
+	keys := orderKeys(options)
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i], _ = lookupField(doc.Data, key.field)
+	}
+	return &Cursor{Values: values, ID: doc.ID}
+}
+
+type orderKey struct {
+	field string
+	desc  bool
+}
+
+// orderKeys normaliza options.OrderBy a pares campo/dirección. Como Firestore, añade detrás
+// (ascendentes y por orden alfabético) los campos de los filtros de desigualdad que no
+// estén ya en OrderBy: Firestore ordena implícitamente por ellos.
+func orderKeys(options firebase.QueryOptions) []orderKey {
+	keys := make([]orderKey, 0, len(options.OrderBy))
+	ordered := map[string]bool{}
+	for _, order := range options.OrderBy {
+		keys = append(keys, orderKey{field: order.Field, desc: strings.EqualFold(order.Direction, "desc")})
+		ordered[order.Field] = true
+	}
+	var implicit []string
+	for _, filter := range options.Filters {
+		if inequalityOperators[filter.Operator] && !ordered[filter.Field] {
+			implicit = append(implicit, filter.Field)
+			ordered[filter.Field] = true
+		}
+	}
+	sort.Strings(implicit)
+	for _, field := range implicit {
+		keys = append(keys, orderKey{field: field})
+	}
+	return keys
+}
+
+// inequalityOperators son los operadores de desigualdad de Firestore.
+var inequalityOperators = map[string]bool{"<": true, "<=": true, ">": true, ">=": true, "!=": true, "not-in": true}
+
+// idDescending indica el sentido del desempate por ID. Como en Firestore, sigue la
+// dirección del último OrderBy (ascendente si no hay ninguno).
+func idDescending(keys []orderKey) bool {
+	return len(keys) > 0 && keys[len(keys)-1].desc
+}
+
+// compareToCursor devuelve un valor positivo si doc va después de cursor en el orden de keys.
+func compareToCursor(doc *firebase.Document, keys []orderKey, cursor *Cursor) int {
+	for i, key := range keys {
+		if i >= len(cursor.Values) {
+			break
+		}
+		value, _ := lookupField(doc.Data, key.field)
+		cmp := compareValues(value, cursor.Values[i])
+		if key.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	cmp := strings.Compare(doc.ID, cursor.ID)
+	if idDescending(keys) {
+		cmp = -cmp
+	}
+	return cmp
+}
+
+// documentsAfter descarta los documentos (ya ordenados) que no van después de cursor.
+func documentsAfter(docs []*firebase.Document, keys []orderKey, cursor *Cursor) []*firebase.Document {
+	for i, doc := range docs {
+		if compareToCursor(doc, keys, cursor) > 0 {
+			return docs[i:]
+		}
+	}
+	return docs[:0]
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gcfirestore "cloud.google.com/go/firestore"
+	"github.com/andrescris/firestore/lib/firebase"
+	"github.com/andrescris/firestore/lib/firebase/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreStore implementa DocumentStore delegando en la librería de Firestore. Lo que la
+// librería no expone (cursores, transacciones, errores tipados) lo hace con el cliente de
+// Firestore, sobre el mismo proyecto y con las mismas credenciales.
+type FirestoreStore struct {
+	client *gcfirestore.Client
+}
+
+// NewFirestoreStore crea un DocumentStore respaldado por Firestore. Requiere que Firebase se
+// haya inicializado con firebase.InitFirebaseFromEnv; el cliente propio usa las credenciales
+// por defecto (GOOGLE_APPLICATION_CREDENTIALS), igual que la librería.
+func NewFirestoreStore(ctx context.Context, projectID string) (*FirestoreStore, error) {
+	client, err := gcfirestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("create firestore client: %w", err)
+	}
+	return &FirestoreStore{client: client}, nil
+}
+
+// Close cierra el cliente de Firestore.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *FirestoreStore) CreateDocument(ctx context.Context, collection string, data map[string]interface{}) (string, error) {
+	return firestore.CreateDocument(ctx, collection, data)
+}
+
+func (s *FirestoreStore) CreateDocumentWithID(ctx context.Context, collection, id string, data map[string]interface{}) error {
+	return firestore.CreateDocumentWithID(ctx, collection, id, data)
+}
+
+// GetDocument lee con el cliente para devolver ErrNotFound si el documento no existe.
+func (s *FirestoreStore) GetDocument(ctx context.Context, collection, id string) (*firebase.Document, error) {
+	snap, err := s.client.Collection(collection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return documentFromSnapshot(snap), nil
+}
+
+func (s *FirestoreStore) GetAllDocuments(ctx context.Context, collection string) ([]*firebase.Document, error) {
+	return firestore.GetAllDocuments(ctx, collection)
+}
+
+func (s *FirestoreStore) QueryDocuments(ctx context.Context, collection string, options firebase.QueryOptions) ([]*firebase.Document, error) {
+	return firestore.QueryDocuments(ctx, collection, options)
+}
+
+// QueryDocumentsAfter ordena por los campos de orderKeys y por ID, y continúa la consulta
+// con StartAfter: cada página lee solo sus documentos, por profunda que sea.
+func (s *FirestoreStore) QueryDocumentsAfter(ctx context.Context, collection string, options firebase.QueryOptions, after *Cursor) ([]*firebase.Document, error) {
+	snaps, err := s.query(collection, options, after).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]*firebase.Document, len(snaps))
+	for i, snap := range snaps {
+		docs[i] = documentFromSnapshot(snap)
+	}
+	return docs, nil
+}
+
+// query traduce options a una consulta del cliente. El orden es explícito y completo (el
+// de orderKeys y el desempate por ID) para que los valores del cursor encajen con él.
+func (s *FirestoreStore) query(collection string, options firebase.QueryOptions, after *Cursor) gcfirestore.Query {
+	q := s.client.Collection(collection).Query
+	for _, filter := range options.Filters {
+		q = q.Where(filter.Field, filter.Operator, filter.Value)
+	}
+	keys := orderKeys(options)
+	for _, key := range keys {
+		q = q.OrderBy(key.field, direction(key.desc))
+	}
+	q = q.OrderBy(gcfirestore.DocumentID, direction(idDescending(keys)))
+	if after != nil {
+		values := append(append([]interface{}{}, after.Values...), after.ID)
+		q = q.StartAfter(values...)
+	}
+	if options.Offset > 0 {
+		q = q.Offset(options.Offset)
+	}
+	if options.Limit > 0 {
+		q = q.Limit(options.Limit)
+	}
+	return q
+}
+
+func direction(desc bool) gcfirestore.Direction {
+	if desc {
+		return gcfirestore.Desc
+	}
+	return gcfirestore.Asc
+}
+
+// documentFromSnapshot convierte un documento del cliente al formato de la librería.
+func documentFromSnapshot(snap *gcfirestore.DocumentSnapshot) *firebase.Document {
+	return &firebase.Document{
+		ID:         snap.Ref.ID,
+		Data:       snap.Data(),
+		CreateTime: snap.CreateTime,
+		UpdateTime: snap.UpdateTime,
+	}
+}
+
+func (s *FirestoreStore) UpdateDocument(ctx context.Context, collection, id string, data map[string]interface{}) error {
+	return firestore.UpdateDocument(ctx, collection, id, data)
+}
+
+func (s *FirestoreStore) DeleteDocument(ctx context.Context, collection, id string) error {
+	return firestore.DeleteDocument(ctx, collection, id)
+}
+
+func (s *FirestoreStore) ListSubcollections(ctx context.Context, collection, id string) ([]string, error) {
+	refs, err := s.client.Collection(collection).Doc(id).Collections(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.ID
+	}
+	return names, nil
+}
+
+// UpdateDocumentIfMatch comprueba la versión y escribe dentro de una transacción: si otro
+// cliente escribe el documento entre la lectura y la escritura, Firestore repite la
+// transacción y la comprobación falla. Como UpdateDocument, sustituye los campos de primer
+// nivel de data.
+func (s *FirestoreStore) UpdateDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error {
+	return s.ifMatch(ctx, collection, id, version, func(tx *gcfirestore.Transaction, ref *gcfirestore.DocumentRef) error {
+		if len(data) == 0 {
+			return nil
+		}
+		updates := make([]gcfirestore.Update, 0, len(data))
+		for key, value := range data {
+			// FieldPath y no Path: una clave con puntos es un campo, no una ruta
+			updates = append(updates, gcfirestore.Update{FieldPath: gcfirestore.FieldPath{key}, Value: value})
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
+// ReplaceDocumentIfMatch es UpdateDocumentIfMatch sustituyendo el documento completo.
+func (s *FirestoreStore) ReplaceDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error {
+	return s.ifMatch(ctx, collection, id, version, func(tx *gcfirestore.Transaction, ref *gcfirestore.DocumentRef) error {
+		return tx.Set(ref, data)
+	})
+}
+
+// DeleteDocumentIfMatch es UpdateDocumentIfMatch borrando el documento.
+func (s *FirestoreStore) DeleteDocumentIfMatch(ctx context.Context, collection, id, version string) error {
+	return s.ifMatch(ctx, collection, id, version, func(tx *gcfirestore.Transaction, ref *gcfirestore.DocumentRef) error {
+		return tx.Delete(ref)
+	})
+}
+
+// ifMatch ejecuta write en una transacción si collection/id existe y está en version.
+func (s *FirestoreStore) ifMatch(ctx context.Context, collection, id, version string, write func(*gcfirestore.Transaction, *gcfirestore.DocumentRef) error) error {
+	ref := s.client.Collection(collection).Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *gcfirestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if DocumentVersion(documentFromSnapshot(snap)) != version {
+			return fmt.Errorf("%s/%s: %w", collection, id, ErrVersionMismatch)
+		}
+		return write(tx, ref)
+	})
+}
+
+// ApplyBatch aplica el lote en una transacción de Firestore: lee todos los documentos,
+// comprueba sus condiciones y después escribe. Si una condición falla no se escribe nada,
+// y si otro cliente modifica alguno de los documentos antes del commit, Firestore repite
+// la transacción y las condiciones se comprueban de nuevo.
+func (s *FirestoreStore) ApplyBatch(ctx context.Context, writes []BatchWrite) error {
+	refs := make([]*gcfirestore.DocumentRef, len(writes))
+	for i, w := range writes {
+		switch w.Op {
+		case BatchCreate, BatchSet, BatchReplace, BatchDelete:
+		default:
+			return &BatchError{Index: i, Err: fmt.Errorf("unknown batch operation %q", w.Op)}
+		}
+		refs[i] = s.client.Collection(w.Collection).Doc(w.ID)
+	}
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *gcfirestore.Transaction) error {
+		// Firestore exige hacer todas las lecturas antes de la primera escritura
+		for i, w := range writes {
+			if w.Op == BatchSet {
+				continue
+			}
+			snap, err := tx.Get(refs[i])
+			if err != nil && status.Code(err) != codes.NotFound {
+				return &BatchError{Index: i, Err: err}
+			}
+			exists := err == nil
+			var failed error
+			switch {
+			case w.Op == BatchCreate && exists:
+				failed = ErrAlreadyExists
+			case w.Op == BatchCreate:
+			case !exists:
+				failed = ErrNotFound
+			case w.Version != "" && DocumentVersion(documentFromSnapshot(snap)) != w.Version:
+				failed = ErrVersionMismatch
+			}
+			if failed != nil {
+				return &BatchError{Index: i, Err: fmt.Errorf("%s/%s: %w", w.Collection, w.ID, failed)}
+			}
+		}
+
+		for i, w := range writes {
+			var err error
+			switch w.Op {
+			case BatchCreate:
+				err = tx.Create(refs[i], w.Data)
+			case BatchDelete:
+				err = tx.Delete(refs[i])
+			default:
+				err = tx.Set(refs[i], w.Data)
+			}
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// IsNotFound reconoce el error de documento inexistente: ErrNotFound o el error gRPC de
+// Firestore con código NotFound, que la librería devuelve sin envolver en ErrNotFound.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || status.Code(err) == codes.NotFound
+}
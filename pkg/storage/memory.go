@@ -0,0 +1,557 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// MemoryStore es un DocumentStore en memoria pensado para tests y CI.
+// Soporta los mismos filtros y ordenamientos que firebase.QueryOptions.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]*firebase.Document
+	now         func() time.Time
+}
+
+// NewMemoryStore crea un MemoryStore vacío.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		collections: make(map[string]map[string]*firebase.Document),
+		now:         time.Now,
+	}
+}
+
+func (s *MemoryStore) CreateDocument(ctx context.Context, collection string, data map[string]interface{}) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := NewDocumentID()
+	for s.collection(collection)[id] != nil {
+		id = NewDocumentID()
+	}
+	s.put(collection, id, data)
+	return id, nil
+}
+
+func (s *MemoryStore) CreateDocumentWithID(ctx context.Context, collection, id string, data map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(collection, id, data)
+	return nil
+}
+
+func (s *MemoryStore) GetDocument(ctx context.Context, collection, id string) (*firebase.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, ok := s.collections[collection][id]
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	return copyDocument(doc), nil
+}
+
+func (s *MemoryStore) GetAllDocuments(ctx context.Context, collection string) ([]*firebase.Document, error) {
+	return s.QueryDocuments(ctx, collection, firebase.QueryOptions{})
+}
+
+func (s *MemoryStore) QueryDocuments(ctx context.Context, collection string, options firebase.QueryOptions) ([]*firebase.Document, error) {
+	return s.QueryDocumentsAfter(ctx, collection, options, nil)
+}
+
+func (s *MemoryStore) QueryDocumentsAfter(ctx context.Context, collection string, options firebase.QueryOptions, after *Cursor) ([]*firebase.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	docs := make([]*firebase.Document, 0)
+	for _, doc := range s.collections[collection] {
+		match := true
+		for _, filter := range options.Filters {
+			ok, err := MatchFilter(doc.Data, filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		// Igual que Firestore, ordenar por un campo excluye los documentos que no lo tienen.
+		for _, order := range options.OrderBy {
+			if _, ok := lookupField(doc.Data, order.Field); !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			docs = append(docs, doc)
+		}
+	}
+
+	keys := orderKeys(options)
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			a, _ := lookupField(docs[i].Data, key.field)
+			b, _ := lookupField(docs[j].Data, key.field)
+			cmp := compareValues(a, b)
+			if cmp == 0 {
+				continue
+			}
+			if key.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		// Desempate por ID, como hace Firestore.
+		if idDescending(keys) {
+			return docs[i].ID > docs[j].ID
+		}
+		return docs[i].ID < docs[j].ID
+	})
+
+	if after != nil {
+		docs = documentsAfter(docs, keys, after)
+	}
+	if options.Offset > 0 {
+		if options.Offset >= len(docs) {
+			docs = docs[:0]
+		} else {
+			docs = docs[options.Offset:]
+		}
+	}
+	if options.Limit > 0 && len(docs) > options.Limit {
+		docs = docs[:options.Limit]
+	}
+
+	result := make([]*firebase.Document, len(docs))
+	for i, doc := range docs {
+		result[i] = copyDocument(doc)
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) UpdateDocument(ctx context.Context, collection, id string, data map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.collections[collection][id]
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	s.merge(doc, data)
+	return nil
+}
+
+func (s *MemoryStore) UpdateDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.collections[collection][id]
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	if DocumentVersion(doc) != version {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrVersionMismatch)
+	}
+	s.merge(doc, data)
+	return nil
+}
+
+func (s *MemoryStore) ReplaceDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.collections[collection][id]
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	if DocumentVersion(doc) != version {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrVersionMismatch)
+	}
+	doc.Data = copyMap(data)
+	doc.UpdateTime = s.nextUpdateTime(doc.UpdateTime)
+	return nil
+}
+
+func (s *MemoryStore) DeleteDocumentIfMatch(ctx context.Context, collection, id, version string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.collections[collection][id]
+	if !ok {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrNotFound)
+	}
+	if DocumentVersion(doc) != version {
+		return fmt.Errorf("%s/%s: %w", collection, id, ErrVersionMismatch)
+	}
+	delete(s.collections[collection], id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteDocument(ctx context.Context, collection, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Firestore no falla al borrar un documento inexistente.
+	delete(s.collections[collection], id)
+	return nil
+}
+
+// ApplyBatch comprueba todas las condiciones y aplica las escrituras bajo el mismo lock,
+// así que nadie ve el lote a medias.
+func (s *MemoryStore) ApplyBatch(ctx context.Context, writes []BatchWrite) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Primero todas las comprobaciones: si una falla no se escribe nada
+	for i, w := range writes {
+		doc, exists := s.collections[w.Collection][w.ID]
+		var err error
+		switch w.Op {
+		case BatchCreate:
+			if exists {
+				err = ErrAlreadyExists
+			}
+		case BatchSet:
+		case BatchReplace, BatchDelete:
+			if !exists {
+				err = ErrNotFound
+			} else if w.Version != "" && DocumentVersion(doc) != w.Version {
+				err = ErrVersionMismatch
+			}
+		default:
+			return &BatchError{Index: i, Err: fmt.Errorf("unknown batch operation %q", w.Op)}
+		}
+		if err != nil {
+			return &BatchError{Index: i, Err: fmt.Errorf("%s/%s: %w", w.Collection, w.ID, err)}
+		}
+	}
+
+	for _, w := range writes {
+		switch w.Op {
+		case BatchCreate, BatchSet:
+			s.put(w.Collection, w.ID, w.Data)
+		case BatchReplace:
+			doc := s.collections[w.Collection][w.ID]
+			doc.Data = copyMap(w.Data)
+			doc.UpdateTime = s.nextUpdateTime(doc.UpdateTime)
+		case BatchDelete:
+			delete(s.collections[w.Collection], w.ID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListSubcollections(ctx context.Context, collection, id string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := collection + "/" + id + "/"
+	var names []string
+	for name, docs := range s.collections {
+		sub, ok := strings.CutPrefix(name, prefix)
+		if ok && !strings.Contains(sub, "/") && len(docs) > 0 {
+			names = append(names, sub)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// collection devuelve (creándola si hace falta) la colección indicada. Requiere s.mu.
+func (s *MemoryStore) collection(name string) map[string]*firebase.Document {
+	docs, ok := s.collections[name]
+	if !ok {
+		docs = make(map[string]*firebase.Document)
+		s.collections[name] = docs
+	}
+	return docs
+}
+
+// merge fusiona data en doc y avanza su UpdateTime. Requiere s.mu.
+func (s *MemoryStore) merge(doc *firebase.Document, data map[string]interface{}) {
+	for key, value := range data {
+		doc.Data[key] = copyValue(value)
+	}
+	doc.UpdateTime = s.nextUpdateTime(doc.UpdateTime)
+}
+
+// nextUpdateTime garantiza que UpdateTime siempre avance, aunque el reloj no lo haga,
+// para que cada escritura produzca una versión distinta.
+func (s *MemoryStore) nextUpdateTime(previous time.Time) time.Time {
+	now := s.now()
+	if !now.After(previous) {
+		now = previous.Add(time.Nanosecond)
+	}
+	return now
+}
+
+// put guarda una copia de data bajo el ID indicado. Requiere s.mu.
+func (s *MemoryStore) put(collection, id string, data map[string]interface{}) {
+	now := s.now()
+	// Sobreescribir un documento existente también debe cambiar su versión
+	if previous, ok := s.collections[collection][id]; ok {
+		now = s.nextUpdateTime(previous.UpdateTime)
+	}
+	s.collection(collection)[id] = &firebase.Document{
+		ID:         id,
+		Data:       copyMap(data),
+		CreateTime: now,
+		UpdateTime: now,
+	}
+}
+
+// MatchFilter evalúa un QueryFilter contra los datos de un documento con la semántica
+// de Firestore. La usan MemoryStore y el stream de cambios, que filtra en memoria.
+func MatchFilter(data map[string]interface{}, filter firebase.QueryFilter) (bool, error) {
+	value, exists := lookupField(data, filter.Field)
+
+	switch filter.Operator {
+	case "==":
+		return exists && compareValues(value, filter.Value) == 0, nil
+	case "!=":
+		return exists && compareValues(value, filter.Value) != 0, nil
+	case "<":
+		return exists && sameType(value, filter.Value) && compareValues(value, filter.Value) < 0, nil
+	case "<=":
+		return exists && sameType(value, filter.Value) && compareValues(value, filter.Value) <= 0, nil
+	case ">":
+		return exists && sameType(value, filter.Value) && compareValues(value, filter.Value) > 0, nil
+	case ">=":
+		return exists && sameType(value, filter.Value) && compareValues(value, filter.Value) >= 0, nil
+	case "in":
+		return exists && containsValue(filter.Value, value), nil
+	case "not-in":
+		return exists && !containsValue(filter.Value, value), nil
+	case "array-contains":
+		return exists && containsValue(value, filter.Value), nil
+	case "array-contains-any":
+		if !exists {
+			return false, nil
+		}
+		for _, candidate := range toSlice(filter.Value) {
+			if containsValue(value, candidate) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported query operator %q", filter.Operator)
+	}
+}
+
+// lookupField resuelve rutas con puntos ("a.b.c") dentro de mapas anidados.
+func lookupField(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// containsValue indica si list (un slice) contiene value.
+func containsValue(list, value interface{}) bool {
+	for _, item := range toSlice(list) {
+		if compareValues(item, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func toSlice(value interface{}) []interface{} {
+	if items, ok := value.([]interface{}); ok {
+		return items
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items
+}
+
+// typeRank sigue el orden de tipos de Firestore: null < bool < número < fecha < string < resto.
+func typeRank(value interface{}) int {
+	if value == nil {
+		return 0
+	}
+	if _, ok := value.(bool); ok {
+		return 1
+	}
+	if _, ok := toFloat(value); ok {
+		return 2
+	}
+	if _, ok := value.(time.Time); ok {
+		return 3
+	}
+	if _, ok := value.(string); ok {
+		return 4
+	}
+	return 5
+}
+
+// sameType indica si dos valores son del mismo tipo, requisito de Firestore para <, <=, > y >=.
+func sameType(a, b interface{}) bool {
+	return typeRank(a) == typeRank(b)
+}
+
+// compareValues devuelve -1, 0 o 1 comparando a y b.
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case 0:
+		return 0
+	case 1:
+		ab, bb := a.(bool), b.(bool)
+		switch {
+		case ab == bb:
+			return 0
+		case !ab:
+			return -1
+		default:
+			return 1
+		}
+	case 2:
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	case 3:
+		return a.(time.Time).Compare(b.(time.Time))
+	case 4:
+		return strings.Compare(a.(string), b.(string))
+	default:
+		if reflect.DeepEqual(a, b) {
+			return 0
+		}
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func copyDocument(doc *firebase.Document) *firebase.Document {
+	clone := *doc
+	clone.Data = copyMap(doc.Data)
+	return &clone
+}
+
+func copyMap(data map[string]interface{}) map[string]interface{} {
+	clone := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		clone[key] = copyValue(value)
+	}
+	return clone
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyMap(v)
+	case []interface{}:
+		clone := make([]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = copyValue(item)
+		}
+		return clone
+	default:
+		return v
+	}
+}
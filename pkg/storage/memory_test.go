@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+func TestMatchFilter(t *testing.T) {
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	data := map[string]interface{}{
+		"status": "active",
+		"total":  10,
+		"price":  9.5,
+		"tags":   []interface{}{"a", "b"},
+		"at":     day,
+		"flag":   true,
+		"none":   nil,
+		"meta":   map[string]interface{}{"owner": map[string]interface{}{"uid": "u1"}, "n": 3},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		filter firebase.QueryFilter
+		want   bool
+	}{
+		{"== string", firebase.QueryFilter{Field: "status", Operator: "==", Value: "active"}, true},
+		{"== int y float", firebase.QueryFilter{Field: "total", Operator: "==", Value: 10.0}, true},
+		{"== otro tipo", firebase.QueryFilter{Field: "total", Operator: "==", Value: "10"}, false},
+		{"== null", firebase.QueryFilter{Field: "none", Operator: "==", Value: nil}, true},
+		{"== campo ausente", firebase.QueryFilter{Field: "missing", Operator: "==", Value: nil}, false},
+		{"!= distinto", firebase.QueryFilter{Field: "status", Operator: "!=", Value: "deleted"}, true},
+		{"!= igual", firebase.QueryFilter{Field: "status", Operator: "!=", Value: "active"}, false},
+		{"!= campo ausente", firebase.QueryFilter{Field: "missing", Operator: "!=", Value: "x"}, false},
+		{"< número", firebase.QueryFilter{Field: "total", Operator: "<", Value: 11}, true},
+		{"<= número", firebase.QueryFilter{Field: "total", Operator: "<=", Value: 10}, true},
+		{"> float", firebase.QueryFilter{Field: "price", Operator: ">", Value: 9}, true},
+		{">= float", firebase.QueryFilter{Field: "price", Operator: ">=", Value: 9.6}, false},
+		{"< fecha", firebase.QueryFilter{Field: "at", Operator: "<", Value: day.Add(time.Hour)}, true},
+		{"> string", firebase.QueryFilter{Field: "status", Operator: ">", Value: "aaa"}, true},
+		// Los rangos solo comparan valores del mismo tipo: un string nunca es > que un número
+		{"> tipo distinto", firebase.QueryFilter{Field: "status", Operator: ">", Value: 1}, false},
+		{"< tipo distinto", firebase.QueryFilter{Field: "total", Operator: "<", Value: "z"}, false},
+		{"> bool", firebase.QueryFilter{Field: "flag", Operator: ">", Value: false}, true},
+		{"in", firebase.QueryFilter{Field: "status", Operator: "in", Value: []interface{}{"x", "active"}}, true},
+		{"in []string", firebase.QueryFilter{Field: "status", Operator: "in", Value: []string{"x", "y"}}, false},
+		{"not-in", firebase.QueryFilter{Field: "status", Operator: "not-in", Value: []interface{}{"x"}}, true},
+		{"not-in ausente", firebase.QueryFilter{Field: "missing", Operator: "not-in", Value: []interface{}{"x"}}, false},
+		{"array-contains", firebase.QueryFilter{Field: "tags", Operator: "array-contains", Value: "b"}, true},
+		{"array-contains no", firebase.QueryFilter{Field: "tags", Operator: "array-contains", Value: "c"}, false},
+		{"array-contains-any", firebase.QueryFilter{Field: "tags", Operator: "array-contains-any", Value: []interface{}{"c", "a"}}, true},
+		{"array-contains-any no", firebase.QueryFilter{Field: "tags", Operator: "array-contains-any", Value: []interface{}{"c"}}, false},
+		{"ruta con puntos", firebase.QueryFilter{Field: "meta.owner.uid", Operator: "==", Value: "u1"}, true},
+		{"ruta con puntos número", firebase.QueryFilter{Field: "meta.n", Operator: ">=", Value: 3}, true},
+		{"ruta con puntos ausente", firebase.QueryFilter{Field: "meta.owner.email", Operator: "==", Value: nil}, false},
+		{"ruta a través de un valor", firebase.QueryFilter{Field: "status.x", Operator: "==", Value: nil}, false},
+	} {
+		got, err := MatchFilter(data, tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: MatchFilter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := MatchFilter(data, firebase.QueryFilter{Field: "status", Operator: "like", Value: "a"}); err == nil {
+		t.Error("unsupported operator: want error")
+	}
+}
+
+func TestCompareValuesTypeOrder(t *testing.T) {
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	// Orden de tipos de Firestore: null < bool < número < fecha < string < resto
+	ordered := []interface{}{nil, false, true, -1, 0.5, 2, day, day.Add(time.Second), "", "a", "b", []interface{}{"x"}}
+	for i := range ordered {
+		for j := range ordered {
+			got := compareValues(ordered[i], ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got != want {
+				t.Errorf("compareValues(%v, %v) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestQueryDocumentsOrder(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for id, data := range map[string]map[string]interface{}{
+		"a": {"rank": 2, "group": "x"},
+		"b": {"rank": "2", "group": "x"},
+		"c": {"rank": nil, "group": "y"},
+		"d": {"rank": 2, "group": "y"},
+		"e": {"rank": true, "group": "x"},
+		"f": {"group": "x"}, // sin rank: queda fuera al ordenar por rank
+		"g": {"rank": 1.5, "group": "y", "nested": map[string]interface{}{"v": 1}},
+		"h": {"rank": 1, "group": "x", "nested": map[string]interface{}{"v": 2}},
+	} {
+		if err := s.CreateDocumentWithID(ctx, "items", id, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(docs []*firebase.Document) string {
+		out := make([]string, len(docs))
+		for i, doc := range docs {
+			out[i] = doc.ID
+		}
+		return strings.Join(out, ",")
+	}
+
+	for _, tt := range []struct {
+		name    string
+		options firebase.QueryOptions
+		want    string
+	}{
+		{"sin orden: por ID", firebase.QueryOptions{}, "a,b,c,d,e,f,g,h"},
+		{"tipos mezclados asc", firebase.QueryOptions{OrderBy: []firebase.OrderBy{{Field: "rank"}}}, "c,e,h,g,a,d,b"},
+		// El desempate por ID sigue la dirección del último OrderBy
+		{"tipos mezclados desc", firebase.QueryOptions{OrderBy: []firebase.OrderBy{{Field: "rank", Direction: "desc"}}}, "b,d,a,g,h,e,c"},
+		{"dos campos", firebase.QueryOptions{OrderBy: []firebase.OrderBy{{Field: "group", Direction: "desc"}, {Field: "rank"}}}, "c,g,d,e,h,a,b"},
+		{"ruta con puntos", firebase.QueryOptions{OrderBy: []firebase.OrderBy{{Field: "nested.v", Direction: "desc"}}}, "h,g"},
+		{"filtro y límite", firebase.QueryOptions{Filters: []firebase.QueryFilter{{Field: "group", Operator: "==", Value: "x"}}, Limit: 3}, "a,b,e"},
+		{"offset", firebase.QueryOptions{Offset: 6}, "g,h"},
+		// Un filtro de desigualdad ordena implícitamente por su campo, como en Firestore
+		{"desigualdad sin orden", firebase.QueryOptions{Filters: []firebase.QueryFilter{{Field: "rank", Operator: "<", Value: 3}}}, "h,g,a,d"},
+	} {
+		docs, err := s.QueryDocuments(ctx, "items", tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := ids(docs); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Paginar con cursor recorre los mismos documentos que la consulta completa
+	options := firebase.QueryOptions{OrderBy: []firebase.OrderBy{{Field: "rank", Direction: "desc"}}, Limit: 3}
+	var pages []string
+	var after *Cursor
+	for {
+		docs, err := s.QueryDocumentsAfter(ctx, "items", options, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+		pages = append(pages, ids(docs))
+		after = CursorAfter(docs[len(docs)-1], options)
+	}
+	if got := strings.Join(pages, "|"); got != "b,d,a|g,h,e|c" {
+		t.Errorf("pages = %s", got)
+	}
+}
+
+func TestListSubcollections(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for _, collection := range []string{"orders", "orders/o1/items", "orders/o1/notes", "orders/o1/items/i1/parts", "orders/o2/items", "orders/o10/logs"} {
+		if err := s.CreateDocumentWithID(ctx, collection, "x", map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Una subcolección vacía ya no existe, como en Firestore
+	s.DeleteDocument(ctx, "orders/o2/items", "x")
+
+	for _, tt := range []struct{ collection, id, want string }{
+		{"orders", "o1", "items,notes"},
+		{"orders", "o2", ""},
+		{"orders/o1/items", "i1", "parts"},
+		{"orders", "missing", ""},
+	} {
+		names, err := s.ListSubcollections(ctx, tt.collection, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("%s/%s: got %q, want %q", tt.collection, tt.id, got, tt.want)
+		}
+	}
+}
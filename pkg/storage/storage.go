@@ -0,0 +1,55 @@
+// Package storage define el backend de documentos que usan los handlers.
+//
+// Los handlers ya no llaman directamente al paquete firestore: reciben un
+// DocumentStore, de modo que el backend se puede cambiar (Firestore en
+// producción, memoria en tests y CI).
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// ErrNotFound se devuelve cuando el documento solicitado no existe.
+var ErrNotFound = errors.New("document not found")
+
+// DocumentStore es el conjunto de operaciones sobre documentos que necesita la API.
+// Los nombres y firmas siguen a los del paquete firestore de la librería.
+type DocumentStore interface {
+	// CreateDocument crea un documento con ID generado y devuelve ese ID.
+	CreateDocument(ctx context.Context, collection string, data map[string]interface{}) (string, error)
+	// CreateDocumentWithID crea (o reemplaza) el documento con el ID indicado.
+	CreateDocumentWithID(ctx context.Context, collection, id string, data map[string]interface{}) error
+	// GetDocument obtiene un documento por ID.
+	GetDocument(ctx context.Context, collection, id string) (*firebase.Document, error)
+	// GetAllDocuments obtiene todos los documentos de una colección.
+	GetAllDocuments(ctx context.Context, collection string) ([]*firebase.Document, error)
+	// QueryDocuments consulta una colección con filtros, orden y límite.
+	QueryDocuments(ctx context.Context, collection string, options firebase.QueryOptions) ([]*firebase.Document, error)
+	// QueryDocumentsAfter es QueryDocuments empezando justo después de after (nil = desde el principio).
+	// options.Limit limita el tamaño de la página.
+	QueryDocumentsAfter(ctx context.Context, collection string, options firebase.QueryOptions, after *Cursor) ([]*firebase.Document, error)
+	// UpdateDocument fusiona data con los campos de un documento existente.
+	UpdateDocument(ctx context.Context, collection, id string, data map[string]interface{}) error
+	// DeleteDocument elimina un documento.
+	DeleteDocument(ctx context.Context, collection, id string) error
+	// ListSubcollections devuelve los nombres de las subcolecciones del documento, exista
+	// o no: en Firestore las subcolecciones sobreviven al borrado de su documento.
+	ListSubcollections(ctx context.Context, collection, id string) ([]string, error)
+
+	// UpdateDocumentIfMatch es UpdateDocument solo si la versión actual (DocumentVersion)
+	// es version; si no, devuelve ErrVersionMismatch.
+	UpdateDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error
+	// ReplaceDocumentIfMatch sustituye todos los datos del documento (las claves ausentes
+	// en data se eliminan) solo si la versión actual es version.
+	ReplaceDocumentIfMatch(ctx context.Context, collection, id string, data map[string]interface{}, version string) error
+	// DeleteDocumentIfMatch es DeleteDocument solo si la versión actual es version.
+	DeleteDocumentIfMatch(ctx context.Context, collection, id, version string) error
+
+	// ApplyBatch aplica todas las escrituras o ninguna. Las condiciones de cada escritura
+	// se comprueban contra el estado previo al lote, así que cada documento debe aparecer
+	// una sola vez. Si una escritura no se puede aplicar devuelve un *BatchError.
+	ApplyBatch(ctx context.Context, writes []BatchWrite) error
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// ErrVersionMismatch se devuelve cuando una escritura condicional encuentra el documento
+// en una versión distinta de la esperada.
+var ErrVersionMismatch = errors.New("document version mismatch")
+
+// DocumentVersion identifica la revisión actual de un documento. Se basa en UpdateTime;
+// si el backend no lo informa, usa un hash del contenido.
+func DocumentVersion(doc *firebase.Document) string {
+	if !doc.UpdateTime.IsZero() {
+		return strconv.FormatInt(doc.UpdateTime.UnixNano(), 36)
+	}
+	data, _ := json.Marshal(doc.Data)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,169 @@
+// Package trash implementa la papelera de documentos: los documentos eliminados se mueven
+// a una colección de sistema, de donde se pueden recuperar hasta que el purgador los
+// borra definitivamente al cumplirse el periodo de retención.
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/revisions"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// Collection es la colección donde se guardan los documentos eliminados de todas las colecciones.
+const Collection = "document_trash"
+
+// purgeBatchSize es el número de entradas que el purgador borra por consulta.
+const purgeBatchSize = 100
+
+// Entry es un documento en la papelera.
+type Entry struct {
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	DocumentID string                 `json:"document_id"`
+	Data       map[string]interface{} `json:"data"`
+	Subdomain  string                 `json:"subdomain,omitempty"`
+	DeletedAt  time.Time              `json:"deleted_at"`
+	DeletedBy  string                 `json:"deleted_by"`
+}
+
+// NewEntryID genera el ID de una entrada nueva. Cada borrado crea su propia entrada, así
+// que eliminar de nuevo un documento recreado con el mismo ID no pisa la anterior.
+func NewEntryID() string {
+	return storage.NewDocumentID()
+}
+
+// FromDocument convierte un documento de Collection en Entry.
+func FromDocument(doc *firebase.Document) Entry {
+	entry := Entry{ID: doc.ID}
+	entry.Collection, _ = doc.Data["collection"].(string)
+	entry.DocumentID, _ = doc.Data["document_id"].(string)
+	entry.Data, _ = doc.Data["data"].(map[string]interface{})
+	entry.Subdomain, _ = doc.Data["subdomain"].(string)
+	entry.DeletedAt, _ = doc.Data["deleted_at"].(time.Time)
+	entry.DeletedBy, _ = doc.Data["deleted_by"].(string)
+	return entry
+}
+
+// Put guarda data en la papelera como collection/docID eliminado por deletedBy y devuelve
+// el ID de la entrada.
+func Put(ctx context.Context, store storage.DocumentStore, collection, docID string, data map[string]interface{}, deletedBy string, deletedAt time.Time) (string, error) {
+	entryID := NewEntryID()
+	if err := store.CreateDocumentWithID(ctx, Collection, entryID, EntryData(collection, docID, data, deletedBy, deletedAt)); err != nil {
+		return "", err
+	}
+	return entryID, nil
+}
+
+// EntryData son los datos de la entrada que guarda Put, para incluirla en un lote de escrituras.
+func EntryData(collection, docID string, data map[string]interface{}, deletedBy string, deletedAt time.Time) map[string]interface{} {
+	subdomain, _ := data["subdomain"].(string)
+	return map[string]interface{}{
+		"collection":  collection,
+		"document_id": docID,
+		"data":        data,
+		"subdomain":   subdomain,
+		"deleted_at":  deletedAt.UTC(),
+		"deleted_by":  deletedBy,
+	}
+}
+
+// Get devuelve la entrada más reciente de collection/docID.
+func Get(ctx context.Context, store storage.DocumentStore, collection, docID string) (Entry, error) {
+	docs, err := store.QueryDocuments(ctx, Collection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{
+			{Field: "collection", Operator: "==", Value: collection},
+			{Field: "document_id", Operator: "==", Value: docID},
+		},
+		OrderBy: []firebase.OrderBy{{Field: "deleted_at", Direction: "desc"}},
+		Limit:   1,
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(docs) == 0 {
+		return Entry{}, fmt.Errorf("%s/%s: %w", collection, docID, storage.ErrNotFound)
+	}
+	return FromDocument(docs[0]), nil
+}
+
+// Remove quita de la papelera la entrada entryID sin tocar el historial del documento,
+// porque el documento ha vuelto a su colección.
+func Remove(ctx context.Context, store storage.DocumentStore, entryID string) error {
+	return store.DeleteDocument(ctx, Collection, entryID)
+}
+
+// Discard borra definitivamente entry junto con las revisiones del documento hasta su
+// borrado. Primero el historial: si falla, la entrada sigue y el siguiente purgado lo reintenta.
+func Discard(ctx context.Context, store storage.DocumentStore, entry Entry) error {
+	if _, err := revisions.Delete(ctx, store, entry.Collection, entry.DocumentID, entry.DeletedAt); err != nil {
+		return err
+	}
+	return store.DeleteDocument(ctx, Collection, entry.ID)
+}
+
+// Purge borra las entradas eliminadas antes de cutoff, con sus revisiones, y devuelve cuántas
+// borró. Una entrada que no se puede borrar no detiene el purgado: se registra, se sigue con
+// las siguientes y se devuelven todos los errores juntos.
+func Purge(ctx context.Context, store storage.DocumentStore, cutoff time.Time) (int, error) {
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "deleted_at", Operator: "<", Value: cutoff.UTC()}},
+		Limit:   purgeBatchSize,
+	}
+	purged := 0
+	var errs []error
+	// Las páginas avanzan con un cursor: si volviéramos a consultar desde el principio, una
+	// entrada que siempre falla ocuparía el primer puesto en cada vuelta
+	var after *storage.Cursor
+	for {
+		docs, err := store.QueryDocumentsAfter(ctx, Collection, options, after)
+		if err != nil {
+			return purged, errors.Join(append(errs, err)...)
+		}
+		for _, doc := range docs {
+			if err := Discard(ctx, store, FromDocument(doc)); err != nil {
+				if ctx.Err() != nil {
+					return purged, errors.Join(append(errs, err)...)
+				}
+				log.Printf("⚠️ No se pudo purgar la entrada %s de la papelera: %v", doc.ID, err)
+				errs = append(errs, fmt.Errorf("entry %s: %w", doc.ID, err))
+				continue
+			}
+			purged++
+		}
+		if len(docs) < purgeBatchSize {
+			return purged, errors.Join(errs...)
+		}
+		after = storage.CursorAfter(docs[len(docs)-1], options)
+	}
+}
+
+// RunPurger ejecuta Purge cada interval con la retención indicada hasta que ctx termine.
+// Un interval de 0 desactiva el purgador.
+func RunPurger(ctx context.Context, store storage.DocumentStore, retention, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := Purge(ctx, store, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("⚠️ Error purgando la papelera: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("🗑️ Papelera: %d documentos eliminados definitivamente", purged)
+			}
+		}
+	}
+}
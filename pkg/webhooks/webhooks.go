@@ -0,0 +1,565 @@
+// Package webhooks avisa a sistemas externos de los cambios en usuarios y documentos.
+//
+// Cada subdominio registra endpoints (URL y tipos de evento). Al producirse un evento se
+// guarda una entrega por endpoint en una colección de sistema, que actúa como cola
+// duradera: el Dispatcher las envía firmadas con HMAC, reintenta con espera exponencial y,
+// agotados los intentos, las deja como "dead" hasta que alguien las reenvíe.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/config"
+	"github.com/andrescris/alimedia/pkg/storage"
+	"github.com/andrescris/firestore/lib/firebase"
+)
+
+// Colecciones de sistema
+const (
+	EndpointsCollection  = "webhook_endpoints"
+	DeliveriesCollection = "webhook_deliveries"
+)
+
+// Tipos de evento
+const (
+	UserCreated     = "user.created"
+	UserDeleted     = "user.deleted"
+	DocumentCreated = "document.created"
+	DocumentUpdated = "document.updated"
+	DocumentDeleted = "document.deleted"
+)
+
+// EventTypes son los tipos de evento a los que se puede suscribir un endpoint.
+var EventTypes = []string{UserCreated, UserDeleted, DocumentCreated, DocumentUpdated, DocumentDeleted}
+
+// userEventTypes son los eventos cuyo payload lleva datos del usuario data.uid.
+var userEventTypes = []interface{}{UserCreated, UserDeleted}
+
+// scanBatchSize es el número de entregas que UserDeliveries lee por consulta.
+const scanBatchSize = 200
+
+// Estados de una entrega
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Cabeceras de cada entrega
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderEventID   = "X-Webhook-ID"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// dispatchBatchSize es cuántas entregas pendientes procesa el Dispatcher por consulta.
+const dispatchBatchSize = 50
+
+// maxErrorBody es cuánto de la respuesta de un endpoint se guarda como last_error.
+const maxErrorBody = 256
+
+// Endpoint es una URL registrada por un subdominio. Secret solo se devuelve al crearlo.
+type Endpoint struct {
+	ID        string    `json:"id"`
+	Subdomain string    `json:"subdomain"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// EndpointFromDocument convierte un documento de EndpointsCollection en Endpoint, sin el secreto.
+func EndpointFromDocument(doc *firebase.Document) Endpoint {
+	endpoint := Endpoint{ID: doc.ID}
+	endpoint.Subdomain, _ = doc.Data["subdomain"].(string)
+	endpoint.URL, _ = doc.Data["url"].(string)
+	endpoint.Active, _ = doc.Data["active"].(bool)
+	endpoint.CreatedAt, _ = doc.Data["created_at"].(time.Time)
+	endpoint.CreatedBy, _ = doc.Data["created_by"].(string)
+	endpoint.Events = stringList(doc.Data["events"])
+	return endpoint
+}
+
+// Payload es el cuerpo JSON que recibe un endpoint.
+type Payload struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Subdomain string                 `json:"subdomain"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Delivery es el envío de un evento a un endpoint.
+type Delivery struct {
+	ID             string     `json:"id"`
+	EndpointID     string     `json:"endpoint_id"`
+	Subdomain      string     `json:"subdomain"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// DeliveryFromDocument convierte un documento de DeliveriesCollection en Delivery.
+func DeliveryFromDocument(doc *firebase.Document) Delivery {
+	d := Delivery{ID: doc.ID}
+	d.EndpointID, _ = doc.Data["endpoint_id"].(string)
+	d.Subdomain, _ = doc.Data["subdomain"].(string)
+	d.EventID, _ = doc.Data["event_id"].(string)
+	d.EventType, _ = doc.Data["event_type"].(string)
+	d.Payload, _ = doc.Data["payload"].(string)
+	d.Status, _ = doc.Data["status"].(string)
+	d.Attempts = intValue(doc.Data["attempts"])
+	d.NextAttemptAt = timeValue(doc.Data["next_attempt_at"])
+	d.LastAttemptAt = timeValue(doc.Data["last_attempt_at"])
+	d.LastStatusCode = intValue(doc.Data["last_status_code"])
+	d.LastError, _ = doc.Data["last_error"].(string)
+	d.CreatedAt, _ = doc.Data["created_at"].(time.Time)
+	d.DeliveredAt = timeValue(doc.Data["delivered_at"])
+	return d
+}
+
+// Event decodifica el payload de la entrega.
+func (d Delivery) Event() (Payload, error) {
+	var event Payload
+	err := json.Unmarshal([]byte(d.Payload), &event)
+	return event, err
+}
+
+// Sign devuelve la firma de una entrega: HMAC-SHA256 con el secreto del endpoint sobre
+// "<timestamp>.<cuerpo>", en hexadecimal y con el prefijo "sha256=".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret genera el secreto de un endpoint.
+func NewSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(buf)
+}
+
+// ValidEventType indica si eventType es uno de EventTypes.
+func ValidEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL comprueba que raw sea una URL absoluta https. Con allowInsecure se acepta
+// también http (para desarrollo y tests); las direcciones privadas las rechaza el Dispatcher al conectar.
+func ValidateURL(raw string, allowInsecure bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && allowInsecure:
+	default:
+		return errors.New("must use https")
+	}
+	if u.User != nil {
+		return errors.New("must not contain credentials")
+	}
+	return nil
+}
+
+// Dispatcher encola y envía las entregas. No guarda estado propio: varias instancias
+// (una por réplica, o la de los handlers y la del repartidor) pueden compartir la cola.
+type Dispatcher struct {
+	store  storage.DocumentStore
+	cfg    config.WebhooksConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDispatcher crea un Dispatcher sobre store con la configuración de entrega cfg.
+func NewDispatcher(store storage.DocumentStore, cfg config.WebhooksConfig) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowInsecureURLs {
+		// SEGURIDAD: la comprobación se hace sobre la IP ya resuelta, al conectar, para que
+		// un DNS que cambia de respuesta no lleve las entregas a la red interna
+		dialer.Control = rejectPrivateAddress
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: cfg.Timeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Dispatcher{
+		store: store,
+		cfg:   cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// Una redirección es una respuesta como cualquier otra: no se sigue
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		now: func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// rejectPrivateAddress impide conectar con direcciones de loopback, privadas o locales.
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// Enqueue guarda una entrega de eventType para cada endpoint activo de subdomain suscrito
+// a ese tipo y devuelve cuántas encoló. El evento se entrega más tarde, desde Run.
+func (d *Dispatcher) Enqueue(ctx context.Context, eventType, subdomain string, data map[string]interface{}) (int, error) {
+	if subdomain == "" {
+		return 0, nil
+	}
+	endpoints, err := d.store.QueryDocuments(ctx, EndpointsCollection, firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{
+			{Field: "subdomain", Operator: "==", Value: subdomain},
+			{Field: "active", Operator: "==", Value: true},
+			{Field: "events", Operator: "array-contains", Value: eventType},
+		},
+	})
+	if err != nil || len(endpoints) == 0 {
+		return 0, err
+	}
+
+	now := d.now()
+	event := Payload{
+		ID:        storage.NewDocumentID(),
+		Type:      eventType,
+		Subdomain: subdomain,
+		CreatedAt: now,
+		Data:      data,
+	}
+	// El cuerpo se guarda ya serializado: es lo que se firma y se reenvía byte a byte
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	var writes []storage.BatchWrite
+	for _, endpoint := range endpoints {
+		writes = append(writes, storage.BatchWrite{
+			Op:         storage.BatchCreate,
+			Collection: DeliveriesCollection,
+			ID:         storage.NewDocumentID(),
+			Data: map[string]interface{}{
+				"endpoint_id":     endpoint.ID,
+				"subdomain":       subdomain,
+				"event_id":        event.ID,
+				"event_type":      eventType,
+				"payload":         string(payload),
+				"status":          StatusPending,
+				"attempts":        0,
+				"next_attempt_at": now,
+				"created_at":      now,
+			},
+		})
+	}
+	// Todas las entregas del evento o ninguna
+	if err := d.store.ApplyBatch(ctx, writes); err != nil {
+		return 0, err
+	}
+	return len(writes), nil
+}
+
+// Run procesa las entregas pendientes cada cfg.PollInterval hasta que ctx termine.
+// Un intervalo de 0 desactiva el repartidor.
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d.cfg.PollInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("⚠️ Error entregando webhooks: %v", err)
+			}
+		}
+	}
+}
+
+// ProcessDue envía las entregas pendientes cuyo próximo intento ya llegó y devuelve
+// cuántas intentó.
+func (d *Dispatcher) ProcessDue(ctx context.Context) (int, error) {
+	processed := 0
+	for {
+		docs, err := d.store.QueryDocuments(ctx, DeliveriesCollection, firebase.QueryOptions{
+			Filters: []firebase.QueryFilter{
+				{Field: "status", Operator: "==", Value: StatusPending},
+				{Field: "next_attempt_at", Operator: "<=", Value: d.now()},
+			},
+			OrderBy: []firebase.OrderBy{{Field: "next_attempt_at", Direction: "asc"}},
+			Limit:   dispatchBatchSize,
+		})
+		if err != nil {
+			return processed, err
+		}
+		attempted := 0
+		for _, doc := range docs {
+			if ctx.Err() != nil {
+				return processed, ctx.Err()
+			}
+			if d.attempt(ctx, doc) {
+				attempted++
+			}
+		}
+		processed += attempted
+		// Si no se pudo reclamar ninguna, otra instancia se está ocupando de ellas
+		if len(docs) < dispatchBatchSize || attempted == 0 {
+			return processed, nil
+		}
+	}
+}
+
+// attempt reclama la entrega doc, la envía y guarda el resultado. Devuelve false si otra
+// instancia la reclamó antes.
+func (d *Dispatcher) attempt(ctx context.Context, doc *firebase.Document) bool {
+	delivery := DeliveryFromDocument(doc)
+
+	// El reclamo aplaza el siguiente intento más allá del timeout: si este proceso muere
+	// a mitad del envío, la entrega vuelve a quedar disponible sola
+	now := d.now()
+	lease := now.Add(2 * d.cfg.Timeout)
+	err := d.store.UpdateDocumentIfMatch(ctx, DeliveriesCollection, doc.ID,
+		map[string]interface{}{"next_attempt_at": lease}, storage.DocumentVersion(doc))
+	if err != nil {
+		if !errors.Is(err, storage.ErrVersionMismatch) && ctx.Err() == nil {
+			log.Printf("⚠️ No se pudo reclamar la entrega de webhook %s: %v", doc.ID, err)
+		}
+		return false
+	}
+
+	update := map[string]interface{}{
+		"attempts":        delivery.Attempts + 1,
+		"last_attempt_at": now,
+	}
+	endpoint, err := d.store.GetDocument(ctx, EndpointsCollection, delivery.EndpointID)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return true // el reclamo caduca y se reintenta
+	case err != nil:
+		update["status"] = StatusDead
+		update["last_error"] = "endpoint no longer exists"
+	case endpoint.Data["active"] != true:
+		update["status"] = StatusDead
+		update["last_error"] = "endpoint is disabled"
+	default:
+		statusCode, sendErr := d.send(ctx, endpoint, delivery, now)
+		update["last_status_code"] = statusCode
+		if sendErr == nil {
+			update["status"] = StatusDelivered
+			update["delivered_at"] = d.now()
+			update["last_error"] = ""
+			break
+		}
+		update["last_error"] = sendErr.Error()
+		if delivery.Attempts+1 >= d.cfg.MaxAttempts {
+			update["status"] = StatusDead
+		} else {
+			update["next_attempt_at"] = d.now().Add(d.backoff(delivery.Attempts + 1))
+		}
+	}
+
+	// El envío ya ocurrió: el resultado se guarda aunque la petición que lo originó termine
+	if err := d.store.UpdateDocument(context.WithoutCancel(ctx), DeliveriesCollection, doc.ID, update); err != nil {
+		log.Printf("⚠️ No se pudo guardar el resultado de la entrega de webhook %s: %v", doc.ID, err)
+	}
+	return true
+}
+
+// send hace el POST firmado de delivery a endpoint. Cualquier respuesta que no sea 2xx es un error.
+func (d *Dispatcher) send(ctx context.Context, endpoint *firebase.Document, delivery Delivery, now time.Time) (int, error) {
+	target, _ := endpoint.Data["url"].(string)
+	secret, _ := endpoint.Data["secret"].(string)
+	if err := ValidateURL(target, d.cfg.AllowInsecureURLs); err != nil {
+		return 0, fmt.Errorf("invalid endpoint url: %w", err)
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "alimedia-webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff es la espera antes del intento attempts+1: cfg.InitialBackoff duplicado en cada
+// fallo, con cfg.MaxBackoff como máximo.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.InitialBackoff
+	for i := 1; i < attempts && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+	return wait
+}
+
+// Redeliver vuelve a poner en cola la entrega id (normalmente una "dead"), con los
+// intentos a cero, y la devuelve actualizada.
+func (d *Dispatcher) Redeliver(ctx context.Context, id string) (Delivery, error) {
+	err := d.store.UpdateDocument(ctx, DeliveriesCollection, id, map[string]interface{}{
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": d.now(),
+	})
+	if err != nil {
+		return Delivery{}, err
+	}
+	doc, err := d.store.GetDocument(ctx, DeliveriesCollection, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return DeliveryFromDocument(doc), nil
+}
+
+// UserDeliveries devuelve las entregas de eventos de usuario (user.created, user.deleted)
+// sobre uid, cuyo payload guarda su email y su nombre.
+func (d *Dispatcher) UserDeliveries(ctx context.Context, uid string) ([]Delivery, error) {
+	options := firebase.QueryOptions{
+		Filters: []firebase.QueryFilter{{Field: "event_type", Operator: "in", Value: userEventTypes}},
+		Limit:   scanBatchSize,
+	}
+	var found []Delivery
+	var after *storage.Cursor
+	for {
+		docs, err := d.store.QueryDocumentsAfter(ctx, DeliveriesCollection, options, after)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			delivery := DeliveryFromDocument(doc)
+			if event, err := delivery.Event(); err == nil && event.Data["uid"] == uid {
+				found = append(found, delivery)
+			}
+		}
+		if len(docs) < scanBatchSize {
+			return found, nil
+		}
+		after = storage.CursorAfter(docs[len(docs)-1], options)
+	}
+}
+
+// RedactDelivery sustituye los datos del evento de la entrega por data. Si sigue pendiente,
+// se envía el payload nuevo, firmado como cualquier otro.
+func (d *Dispatcher) RedactDelivery(ctx context.Context, delivery Delivery, data map[string]interface{}) error {
+	event, err := delivery.Event()
+	if err != nil {
+		return fmt.Errorf("decode webhook payload: %w", err)
+	}
+	event.Data = data
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+	return d.store.UpdateDocument(ctx, DeliveriesCollection, delivery.ID, map[string]interface{}{"payload": string(payload)})
+}
+
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// intValue lee un entero guardado por el backend (Firestore lo devuelve como int64).
+func intValue(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func timeValue(value interface{}) *time.Time {
+	if t, ok := value.(time.Time); ok {
+		return &t
+	}
+	return nil
+}
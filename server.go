@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/andrescris/alimedia/pkg/config"
+)
+
+// serve atiende peticiones en ln hasta que ctx se cancela o el servidor falla.
+// Al cancelarse ctx deja de aceptar conexiones y espera a que terminen las
+// peticiones en curso, como máximo cfg.ShutdownTimeout.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, cfg config.ServerConfig) error {
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if cfg.TLSEnabled() {
+			err = srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		// El servidor terminó por sí solo (p. ej. certificado inválido).
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("⏳ Apagando servidor, esperando peticiones en curso (máx. %s)...", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Se agotó el plazo: cortamos las conexiones que sigan abiertas.
+		srv.Close()
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
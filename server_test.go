@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrescris/alimedia/pkg/config"
+)
+
+// startServe arranca serve con un handler que tarda delay en responder.
+func startServe(t *testing.T, delay, shutdownTimeout time.Duration) (addr string, started <-chan struct{}, cancel context.CancelFunc, done <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startedCh := make(chan struct{}, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedCh <- struct{}{}
+		time.Sleep(delay)
+		io.WriteString(w, "done")
+	})}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- serve(ctx, srv, ln, config.ServerConfig{ShutdownTimeout: shutdownTimeout})
+	}()
+	t.Cleanup(cancelFn)
+	return "http://" + ln.Addr().String(), startedCh, cancelFn, doneCh
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	addr, started, cancel, done := startServe(t, 200*time.Millisecond, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(addr)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resCh <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-resCh
+	if res.err != nil || res.body != "done" {
+		t.Fatalf("in-flight request = %q, %v; want it to complete", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("serve returned %v, want nil", err)
+	}
+	if _, err := http.Get(addr); err == nil {
+		t.Fatal("server still accepts connections after shutdown")
+	}
+}
+
+func TestServeShutdownDeadline(t *testing.T) {
+	addr, started, cancel, done := startServe(t, 2*time.Second, 50*time.Millisecond)
+
+	go http.Get(addr)
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("serve returned nil, want deadline error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve did not honor the shutdown timeout")
+	}
+}